@@ -3,6 +3,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/signal"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/crypto"
+	libp2ppeer "github.com/libp2p/go-libp2p/core/peer"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 
@@ -25,6 +27,12 @@ import (
 	"github.com/crowdllama/crowdllama/pkg/version"
 )
 
+// defaultAccessTokenTTL is how long issued access tokens are valid unless --ttl says otherwise
+const defaultAccessTokenTTL = 90 * 24 * time.Hour
+
+// availabilityCommandTimeout bounds how long pause waits for the worker's in-flight requests to finish
+const availabilityCommandTimeout = 6 * time.Minute
+
 var (
 	cfg       *config.Configuration
 	logger    *zap.Logger
@@ -60,12 +68,99 @@ func main() {
 	ollamaCmd.AddCommand(networkStatusCmd)
 	ollamaCmd.AddCommand(versionCmd)
 	ollamaCmd.AddCommand(startCmd)
+	ollamaCmd.AddCommand(pauseCmd)
+	ollamaCmd.AddCommand(resumeCmd)
+	ollamaCmd.AddCommand(issueTokenCmd)
+	issueTokenCmd.Flags().String("issuer-key", "", "Private key file of the issuer (default: ~/.crowdllama/issuer.key, created if missing)")
+	issueTokenCmd.Flags().String("peer", "", "Peer ID of the gateway or consumer the token grants access to")
+	issueTokenCmd.Flags().Duration("ttl", defaultAccessTokenTTL, "How long the token is valid")
+	for _, command := range []*cobra.Command{pauseCmd, resumeCmd} {
+		command.Flags().String("socket", os.Getenv("CROWDLLAMA_SOCKET"), "IPC socket of the running worker (env: CROWDLLAMA_SOCKET)")
+	}
 
 	// Add flags to start command
 	startCmd.Flags().BoolVar(&workerMode, "worker-mode", false, "Run in worker mode (default: consumer mode)")
 	startCmd.Flags().IntVar(&port, "port", 9001, "HTTP server port (consumer mode only)")
 	startCmd.Flags().StringVar(&cfg.KeyPath, "key", "", "Path to private key file (default: ~/.crowdllama/<component>.key)")
 	startCmd.Flags().StringVar(&cfg.OllamaBaseURL, "ollama-url", "http://localhost:11434", "Base URL for Ollama API endpoint")
+	startCmd.Flags().StringSliceVar(&cfg.BootstrapPeers, "bootstrap", cfg.BootstrapPeers,
+		"Bootstrap peer multiaddrs, repeatable or comma-separated (supports /dnsaddr/; env: CROWDLLAMA_BOOTSTRAP_PEERS)")
+	startCmd.Flags().StringVar(&cfg.BootstrapFile, "bootstrap-file", cfg.BootstrapFile,
+		"Path to a file with one bootstrap multiaddr per line (env: CROWDLLAMA_BOOTSTRAP_FILE)")
+	startCmd.Flags().StringVar(&cfg.StateDir, "state-dir", cfg.StateDir,
+		"Directory to persist peers and DHT records across restarts, disabled when empty (env: CROWDLLAMA_STATE_DIR)")
+	startCmd.Flags().StringVar(&cfg.LedgerFile, "ledger-file", cfg.LedgerFile,
+		"File to record signed token receipts and credit balances in, disabled when empty (env: CROWDLLAMA_LEDGER_FILE)")
+	startCmd.Flags().StringSliceVar(&cfg.Relays, "relay", cfg.Relays,
+		"Static circuit relay multiaddrs used when behind NAT, repeatable or comma-separated (env: CROWDLLAMA_RELAYS)")
+	startCmd.Flags().IntVar(&cfg.MaxProviders, "max-providers", cfg.MaxProviders,
+		"Maximum providers looked up per discovery round, 0 uses the default of 100 (env: CROWDLLAMA_MAX_PROVIDERS)")
+	startCmd.Flags().IntVar(&cfg.MaxMessageSizeMB, "max-message-size-mb", cfg.MaxMessageSizeMB,
+		"Maximum inference message size in MB, 0 uses the default of 10 (env: CROWDLLAMA_MAX_MESSAGE_SIZE_MB)")
+	startCmd.Flags().BoolVar(&cfg.DisableCompression, "disable-compression", cfg.DisableCompression,
+		"Never compress outgoing inference messages (env: CROWDLLAMA_DISABLE_COMPRESSION)")
+	startCmd.Flags().StringSliceVar(&cfg.VisionModels, "vision-model", cfg.VisionModels,
+		"Served models that accept image input, repeatable or comma-separated (worker mode only; env: CROWDLLAMA_VISION_MODELS)")
+	startCmd.Flags().StringVar(&cfg.Backend, "backend", cfg.Backend,
+		"Inference backend: ollama, openai or echo, defaults to ollama (worker mode only; env: CROWDLLAMA_BACKEND)")
+	startCmd.Flags().StringVar(&cfg.BackendURL, "backend-url", cfg.BackendURL,
+		"Base URL of the inference backend, defaults to the Ollama URL (worker mode only; env: CROWDLLAMA_BACKEND_URL)")
+	startCmd.Flags().StringVar(&cfg.BackendAPIKey, "backend-api-key", cfg.BackendAPIKey,
+		"Bearer token for OpenAI compatible backends (worker mode only; env: CROWDLLAMA_BACKEND_API_KEY)")
+	startCmd.Flags().StringSliceVar(&cfg.BackendEndpoints, "backend-endpoint", cfg.BackendEndpoints,
+		"Further inference backends as <type>=<url>, repeatable or comma-separated (worker mode only; env: CROWDLLAMA_BACKEND_ENDPOINTS)")
+	startCmd.Flags().StringSliceVar(&cfg.Models, "model", cfg.Models,
+		"Models to advertise besides those the backends list, repeatable or comma-separated (worker mode only; env: CROWDLLAMA_MODELS)")
+	startCmd.Flags().BoolVar(&cfg.PullModels, "pull-models", cfg.PullModels,
+		"Pull requested models through Ollama on demand (worker mode only; env: CROWDLLAMA_PULL_MODELS)")
+	startCmd.Flags().IntVar(&cfg.PullThreshold, "pull-threshold", cfg.PullThreshold,
+		"Peers asking for a missing model that trigger a pull, 0 uses the default of 3 (env: CROWDLLAMA_PULL_THRESHOLD)")
+	startCmd.Flags().IntVar(&cfg.ModelDiskQuotaGB, "model-disk-quota-gb", cfg.ModelDiskQuotaGB,
+		"Disk space in GB all Ollama models may take when pulling, 0 means no limit (env: CROWDLLAMA_MODEL_DISK_QUOTA_GB)")
+	startCmd.Flags().StringSliceVar(&cfg.AllowModels, "allow-model", cfg.AllowModels,
+		"Model name patterns to serve, e.g. llama3*, repeatable or comma-separated (worker mode only; env: CROWDLLAMA_ALLOW_MODELS)")
+	startCmd.Flags().StringSliceVar(&cfg.DenyModels, "deny-model", cfg.DenyModels,
+		"Model name patterns to refuse, repeatable or comma-separated (worker mode only; env: CROWDLLAMA_DENY_MODELS)")
+	startCmd.Flags().IntVar(&cfg.MaxModelSizeGB, "max-model-size-gb", cfg.MaxModelSizeGB,
+		"Largest model to serve or pull in GB, 0 means no limit (worker mode only; env: CROWDLLAMA_MAX_MODEL_SIZE_GB)")
+	startCmd.Flags().IntVar(&cfg.MaxContext, "max-context", cfg.MaxContext,
+		"Largest num_ctx a request may ask for, 0 means no limit (worker mode only; env: CROWDLLAMA_MAX_CONTEXT)")
+	startCmd.Flags().IntVar(&cfg.MaxPredict, "max-predict", cfg.MaxPredict,
+		"Largest num_predict a request may ask for, also applied when unset (worker mode only; env: CROWDLLAMA_MAX_PREDICT)")
+	startCmd.Flags().StringVar(&cfg.Schedule, "schedule", cfg.Schedule,
+		"Weekly windows to serve in, e.g. \"mon-fri 22:00-07:00; sat,sun\", empty serves always (worker mode only; env: CROWDLLAMA_SCHEDULE)")
+	startCmd.Flags().DurationVar(&cfg.DrainTimeout, "drain-timeout", cfg.DrainTimeout,
+		"How long to wait for in-flight requests on SIGTERM, 0 uses the default of 1m (worker mode only; env: CROWDLLAMA_DRAIN_TIMEOUT)")
+	startCmd.Flags().StringVar(&cfg.APIKeysFile, "api-keys-file", cfg.APIKeysFile,
+		"JSON file of API keys clients must send as Bearer tokens (consumer mode only; env: CROWDLLAMA_API_KEYS_FILE)")
+	startCmd.Flags().StringSliceVar(&cfg.APIKeys, "api-key", cfg.APIKeys,
+		"Gateway API key as name=key, repeatable or comma-separated (consumer mode only; env: CROWDLLAMA_API_KEYS)")
+	startCmd.Flags().IntVar(&cfg.RequestsPerMinute, "requests-per-minute", cfg.RequestsPerMinute,
+		"Requests per minute allowed per API key or client IP (consumer mode only; env: CROWDLLAMA_REQUESTS_PER_MINUTE)")
+	startCmd.Flags().IntVar(&cfg.MaxConcurrentRequests, "max-concurrent-requests", cfg.MaxConcurrentRequests,
+		"Requests in flight allowed per API key or client IP (consumer mode only; env: CROWDLLAMA_MAX_CONCURRENT_REQUESTS)")
+	startCmd.Flags().Int64Var(&cfg.DailyTokenQuota, "daily-token-quota", cfg.DailyTokenQuota,
+		"Tokens per API key or client IP and UTC day (consumer mode only; env: CROWDLLAMA_DAILY_TOKEN_QUOTA)")
+	startCmd.Flags().StringVar(&cfg.AccessToken, "access-token", cfg.AccessToken,
+		"Access token presented to workers that restrict inference (consumer mode only; env: CROWDLLAMA_ACCESS_TOKEN)")
+	startCmd.Flags().StringSliceVar(&cfg.PrepaidCredits, "prepaid-credit", cfg.PrepaidCredits,
+		"Tokens paid to a worker in advance as peer=tokens, repeatable (consumer mode with --ledger-file; env: CROWDLLAMA_PREPAID_CREDITS)")
+	startCmd.Flags().StringSliceVar(&cfg.AllowPeers, "allow-peer", cfg.AllowPeers,
+		"Consumer peer IDs allowed to use the worker, repeatable or comma-separated (worker mode only; env: CROWDLLAMA_ALLOW_PEERS)")
+	startCmd.Flags().StringSliceVar(&cfg.TrustedIssuers, "trusted-issuer", cfg.TrustedIssuers,
+		"Peer IDs whose access tokens the worker honours (worker mode only; env: CROWDLLAMA_TRUSTED_ISSUERS)")
+	startCmd.Flags().BoolVar(&cfg.YieldToLocalUse, "yield-to-local-use", cfg.YieldToLocalUse,
+		"Pause while other processes use the CPU or GPU (worker mode only; env: CROWDLLAMA_YIELD_TO_LOCAL_USE)")
+	startCmd.Flags().IntVar(&cfg.LocalUseThreshold, "local-use-threshold", cfg.LocalUseThreshold,
+		"Percentage of CPU or GPU used by other processes that pauses the worker, 0 uses 50 (env: CROWDLLAMA_LOCAL_USE_THRESHOLD)")
+	startCmd.Flags().StringSliceVar(&cfg.BackendProcesses, "backend-process", cfg.BackendProcesses,
+		"Process names whose usage is the worker's own, repeatable or comma-separated (env: CROWDLLAMA_BACKEND_PROCESSES)")
+	startCmd.Flags().IntVar(&cfg.PeerRequestsPerMinute, "peer-requests-per-minute", cfg.PeerRequestsPerMinute,
+		"Inference requests per minute per consumer peer, 0 uses 120 (worker mode only; env: CROWDLLAMA_PEER_REQUESTS_PER_MINUTE)")
+	startCmd.Flags().IntVar(&cfg.PeerMaxConcurrent, "peer-max-concurrent", cfg.PeerMaxConcurrent,
+		"Inference requests in flight per consumer peer, 0 uses 8 (worker mode only; env: CROWDLLAMA_PEER_MAX_CONCURRENT)")
+	startCmd.Flags().DurationVar(&cfg.PeerBanDuration, "peer-ban-duration", cfg.PeerBanDuration,
+		"How long to refuse peers sending malformed messages, 0 uses 15m (worker mode only; env: CROWDLLAMA_PEER_BAN_DURATION)")
 
 	// Hack: Rename existing start command to start_ollama and store reference
 	for _, command := range ollamaCmd.Commands() {
@@ -103,6 +198,32 @@ var versionCmd = &cobra.Command{
 	},
 }
 
+var pauseCmd = &cobra.Command{
+	Use:   "pause",
+	Short: "Pause a running worker",
+	Long:  `Stop a running worker from accepting new requests and advertising itself, and wait for its in-flight requests to finish.`,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return runAvailabilityCommand(cmd, ipc.MessageTypePause)
+	},
+}
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume",
+	Short: "Resume a paused worker",
+	Long:  `Let a paused worker accept requests again. A worker outside its schedule stays paused until the schedule opens.`,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return runAvailabilityCommand(cmd, ipc.MessageTypeResume)
+	},
+}
+
+var issueTokenCmd = &cobra.Command{
+	Use:   "issue-token",
+	Short: "Issue an access token for a consumer peer",
+	Long: `Sign a token that lets a gateway or consumer use workers trusting the issuer key. The token is printed to
+standard output; the issuer's peer ID, which workers pass to --trusted-issuer, is logged.`,
+	RunE: runIssueToken,
+}
+
 var startCmd = &cobra.Command{
 	Use:   "start",
 	Short: "Start the CrowdLlama platform",
@@ -128,19 +249,24 @@ func setupLogging() {
 	if cfg.IsVerbose() {
 		logger.Info("Verbose mode enabled")
 	}
+}
 
-	// Read IPC socket from environment and start IPC server if configured
-	if socketPath := os.Getenv("CROWDLLAMA_SOCKET"); socketPath != "" {
-		logger.Info("IPC socket configured", zap.String("socket", socketPath))
-		ipcServer = ipc.NewServer(socketPath, logger)
-		go func() {
-			if err := ipcServer.Start(); err != nil {
-				logger.Error("Failed to start IPC server", zap.Error(err))
-			}
-		}()
-	} else {
+// startIPCServer starts the IPC server if a socket is configured. Only a running peer serves IPC; other
+// commands such as pause connect to its socket as clients.
+func startIPCServer() {
+	socketPath := os.Getenv("CROWDLLAMA_SOCKET")
+	if socketPath == "" {
 		logger.Info("No IPC socket configured, skipping IPC server")
+		return
 	}
+
+	logger.Info("IPC socket configured", zap.String("socket", socketPath))
+	ipcServer = ipc.NewServer(socketPath, logger)
+	go func() {
+		if err := ipcServer.Start(); err != nil {
+			logger.Error("Failed to start IPC server", zap.Error(err))
+		}
+	}()
 }
 
 func runVersion() {
@@ -156,8 +282,86 @@ func runNetworkStatus() {
 	logger.Info("Network status check completed", zap.String("status", "placeholder"))
 }
 
+// runAvailabilityCommand sends a pause or resume message to the worker listening on the IPC socket and prints
+// the worker's availability
+func runAvailabilityCommand(cobraCmd *cobra.Command, messageType string) error {
+	socketPath, _ := cobraCmd.Flags().GetString("socket")
+	if socketPath == "" {
+		return fmt.Errorf("no IPC socket given, pass --socket or set CROWDLLAMA_SOCKET")
+	}
+
+	ctx, cancel := context.WithTimeout(cobraCmd.Context(), availabilityCommandTimeout)
+	defer cancel()
+	response, err := ipc.SendCommand(ctx, socketPath, messageType)
+	if err != nil {
+		return fmt.Errorf("%s worker: %w", messageType, err)
+	}
+	if !response.Success {
+		return fmt.Errorf("%s worker: %s", messageType, response.Error)
+	}
+
+	status, err := json.Marshal(response.Payload)
+	if err != nil {
+		return fmt.Errorf("encode worker status: %w", err)
+	}
+	fmt.Println(string(status))
+	return nil
+}
+
+func runIssueToken(cobraCmd *cobra.Command, _ []string) error {
+	subject, _ := cobraCmd.Flags().GetString("peer")
+	subjectID, err := peerID(subject)
+	if err != nil {
+		return err
+	}
+	ttl, _ := cobraCmd.Flags().GetDuration("ttl")
+	if ttl <= 0 {
+		return fmt.Errorf("token lifetime must be positive, got %s", ttl)
+	}
+
+	keyPath, _ := cobraCmd.Flags().GetString("issuer-key")
+	if keyPath == "" {
+		if keyPath, err = keys.GetDefaultKeyPath("issuer"); err != nil {
+			return fmt.Errorf("get default issuer key path: %w", err)
+		}
+	}
+	issuerKey, err := keys.NewKeyManager(keyPath, logger).GetOrCreatePrivateKey()
+	if err != nil {
+		return fmt.Errorf("load issuer key: %w", err)
+	}
+
+	expires := time.Now().Add(ttl)
+	token, err := crowdllama.IssueAccessToken(issuerKey, subjectID, expires)
+	if err != nil {
+		return fmt.Errorf("issue access token: %w", err)
+	}
+	issuerID, err := libp2ppeer.IDFromPrivateKey(issuerKey)
+	if err != nil {
+		return fmt.Errorf("derive issuer peer ID: %w", err)
+	}
+	logger.Info("Issued access token",
+		zap.String("issuer", issuerID.String()),
+		zap.String("peer", subject),
+		zap.Time("expires", expires))
+	fmt.Println(token)
+	return nil
+}
+
+// peerID parses the peer ID passed to a command
+func peerID(s string) (libp2ppeer.ID, error) {
+	if s == "" {
+		return "", fmt.Errorf("no peer ID given, pass --peer")
+	}
+	id, err := libp2ppeer.Decode(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid peer ID %q: %w", s, err)
+	}
+	return id, nil
+}
+
 func runStart(cobraCmd *cobra.Command, _ []string) {
 	logger.Info("Starting CrowdLlama platform")
+	startIPCServer()
 
 	// Get flags from Cobra command
 	workerMode, _ = cobraCmd.Flags().GetBool("worker-mode")
@@ -181,6 +385,12 @@ func runStart(cobraCmd *cobra.Command, _ []string) {
 		logger.Info("Using custom Ollama URL", zap.String("ollama_url", ollamaURL))
 	}
 
+	if len(cfg.BootstrapPeers) > 0 || cfg.BootstrapFile != "" {
+		logger.Info("Using custom bootstrap peers",
+			zap.Strings("bootstrap_peers", cfg.BootstrapPeers),
+			zap.String("bootstrap_file", cfg.BootstrapFile))
+	}
+
 	if workerMode {
 		logger.Info("Starting in WORKER mode")
 		runWorkerMode()
@@ -237,19 +447,19 @@ func runWorkerMode() {
 	startPeerStatsLogging(ctx, p, logger)
 	startPeerDiscovery(ctx, p, logger)
 	waitForShutdownSignal(p, logger)
+	if err := p.Close(); err != nil {
+		logger.Warn("Failed to close peer", zap.Error(err))
+	}
 }
 
 func setupWorkerPeer(ctx context.Context, p *peer.Peer) {
 	// Start the peer manager
 	p.PeerManager.Start()
 
-	// Set the unified API handler for PB-based inference
-	p.APIHandler = crowdllama.WorkerAPIHandler(cfg.GetOllamaBaseURL())
-
-	// Set the peer instance in IPC server if available
+	// Set the peer instance in IPC server if available, sharing the peer's backend for PB-based inference
 	if ipcServer != nil {
 		ipcServer.SetWorkerInstance(p)
-		ipcServer.SetAPIHandler(crowdllama.WorkerAPIHandler(cfg.GetOllamaBaseURL()))
+		ipcServer.SetAPIHandler(p.APIHandler)
 	}
 
 	logger.Info("Peer initialized in worker mode", zap.String("peer_id", p.Host.ID().String()))
@@ -304,6 +514,21 @@ func runConsumerMode() {
 		return
 	}
 
+	apiKeys, err := gateway.LoadAPIKeys(cfg.APIKeysFile, cfg.APIKeys)
+	if err != nil {
+		logger.Error("Failed to load API keys", zap.Error(err))
+		return
+	}
+	prepaidCredits, err := gateway.ParsePrepaidCredits(cfg.PrepaidCredits)
+	if err != nil {
+		logger.Error("Failed to parse prepaid credits", zap.Error(err))
+		return
+	}
+	if len(prepaidCredits) > 0 && cfg.LedgerFile == "" {
+		logger.Error("Prepaid credits need a ledger file to account for the tokens workers serve")
+		return
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -314,7 +539,7 @@ func runConsumerMode() {
 		return
 	}
 
-	g := setupConsumerPeer(ctx, p)
+	g := setupConsumerPeer(ctx, p, apiKeys, prepaidCredits)
 	startConsumerServices(ctx, p, g)
 	startPeerStatsLogging(ctx, p, logger)
 	startPeerDiscovery(ctx, p, logger)
@@ -327,9 +552,17 @@ func runConsumerMode() {
 	if err := g.StopHTTPServer(ctx); err != nil {
 		logger.Error("Failed to stop HTTP server", zap.Error(err))
 	}
+	if err := p.Close(); err != nil {
+		logger.Warn("Failed to close peer", zap.Error(err))
+	}
 }
 
-func setupConsumerPeer(ctx context.Context, p *peer.Peer) *gateway.Gateway {
+func setupConsumerPeer(
+	ctx context.Context,
+	p *peer.Peer,
+	apiKeys *gateway.APIKeys,
+	prepaidCredits map[libp2ppeer.ID]int64,
+) *gateway.Gateway {
 	// Start the peer manager
 	p.PeerManager.Start()
 
@@ -342,6 +575,17 @@ func setupConsumerPeer(ctx context.Context, p *peer.Peer) *gateway.Gateway {
 
 	// Set the unified API handler for PB-based inference
 	g.SetAPIHandler(crowdllama.DefaultAPIHandler)
+	g.SetAPIKeys(apiKeys)
+	g.SetAccessToken(cfg.AccessToken)
+	g.SetPrepaidCredits(prepaidCredits)
+	g.SetLimits(gateway.Limits{
+		RequestsPerMinute: cfg.RequestsPerMinute,
+		MaxConcurrent:     cfg.MaxConcurrentRequests,
+		DailyTokens:       cfg.DailyTokenQuota,
+	})
+	if apiKeys != nil {
+		logger.Info("Gateway requires API keys", zap.Int("keys", apiKeys.Len()))
+	}
 
 	// Set the peer instance in IPC server if available
 	if ipcServer != nil {
@@ -455,6 +699,39 @@ func waitForShutdownSignal(p *peer.Peer, logger *zap.Logger) {
 	<-sigCh
 
 	logger.Info("Shutdown signal received, stopping peer...")
+	if p.WorkerMode {
+		drainWorker(p, sigCh, logger)
+	}
 	p.StopMetadataUpdates()
+	if err := p.SaveState(context.Background()); err != nil {
+		logger.Warn("Failed to save peer state", zap.Error(err))
+	}
 	logger.Info("Peer stopped")
 }
+
+// drainWorker stops the worker from accepting requests and waits for those in flight, until the drain timeout
+// passes or another signal arrives
+func drainWorker(p *peer.Peer, sigCh <-chan os.Signal, logger *zap.Logger) {
+	timeout := cfg.DrainTimeout
+	if timeout <= 0 {
+		timeout = peer.DefaultDrainTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-sigCh:
+			logger.Warn("Second shutdown signal received, not waiting for in-flight requests")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := p.Drain(ctx); err != nil {
+		logger.Warn("Stopping with requests still in flight",
+			zap.Int("in_flight", p.Availability().InFlight),
+			zap.Duration("drain_timeout", timeout),
+			zap.Error(err))
+	}
+}
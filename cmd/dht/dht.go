@@ -17,6 +17,7 @@ import (
 	"github.com/crowdllama/crowdllama/pkg/config"
 	"github.com/crowdllama/crowdllama/pkg/dht"
 	"github.com/crowdllama/crowdllama/pkg/logutil"
+	"github.com/crowdllama/crowdllama/pkg/peerstate"
 	"github.com/crowdllama/crowdllama/pkg/version"
 )
 
@@ -72,12 +73,31 @@ func runDHTServer() error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	server, err := dht.NewDHTServer(ctx, privKey, logger)
+	serverCfg := &dht.ServerConfig{
+		ListenAddrs:  dht.DefaultListenAddrs,
+		RelayService: cfg.RelayService,
+	}
+	if cfg.StateDir != "" {
+		stateStore, err := peerstate.Open(cfg.StateDir, "dht", logger)
+		if err != nil {
+			return fmt.Errorf("failed to open peer state: %w", err)
+		}
+		serverCfg.StateStore = stateStore
+	}
+
+	server, err := dht.NewDHTServerWithConfig(ctx, privKey, logger, serverCfg)
 	if err != nil {
 		return fmt.Errorf("failed to create DHT server: %w", err)
 	}
 
 	printHostInfo(server, logger)
+	if cfg.RelayService {
+		logger.Info("Circuit relay service enabled for peers behind NAT")
+	}
+
+	if err := server.AddSiblings(cfg.Siblings); err != nil {
+		return fmt.Errorf("failed to configure sibling DHT servers: %w", err)
+	}
 
 	primaryAddr, err := server.Start()
 	if err != nil {
@@ -85,6 +105,19 @@ func runDHTServer() error {
 	}
 
 	logger.Info("DHT server started successfully", zap.String("primary_addr", primaryAddr))
+
+	bootstrapPeers, err := cfg.GetBootstrapPeers()
+	if err != nil {
+		return fmt.Errorf("failed to load bootstrap peers: %w", err)
+	}
+	if len(bootstrapPeers) > 0 {
+		// Failing to reach other DHT nodes is not fatal: this node can still act as a bootstrap node itself
+		if err := server.BootstrapWithPeers(bootstrapPeers); err != nil {
+			logger.Warn("Failed to join existing DHT network", zap.Error(err))
+		} else {
+			logger.Info("Joined existing DHT network", zap.Int("bootstrap_peers", len(bootstrapPeers)))
+		}
+	}
 	logger.Info("DHT server running. Press Ctrl+C to exit.")
 
 	waitForShutdown(logger, server)
@@ -93,7 +126,8 @@ func runDHTServer() error {
 
 func parseDHTConfig(startCmd *flag.FlagSet) (*config.Configuration, error) {
 	cfg := config.NewConfiguration()
-	cfg.ParseFlags(startCmd)
+	cfg.LoadFromEnvironment()
+	cfg.ParseDHTFlags(startCmd)
 	if err := startCmd.Parse(os.Args[2:]); err != nil {
 		return nil, fmt.Errorf("failed to parse args: %w", err)
 	}
@@ -3,9 +3,11 @@ package discovery
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -13,10 +15,12 @@ import (
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
 	"github.com/multiformats/go-multihash"
 	"go.uber.org/zap"
 
@@ -44,30 +48,38 @@ const (
 	defaultBootstrapPeerAddr = "/ip4/127.0.0.1/tcp/9000/p2p/12D3KooWLLUBEZhkEq6NtTLD99RRpEYdcbe8uzx3L56UgF5VK4bw"
 )
 
+// HostOptions configure the host and DHT created by NewHostAndDHT. The zero value creates a host without
+// relays and a DHT in server mode.
+type HostOptions struct {
+	// Static circuit relays used when AutoNAT finds the host is not publicly reachable, as p2p multiaddrs or
+	// /dnsaddr/ names
+	Relays []string
+	Libp2p []libp2p.Option // Further host options, such as a resource manager
+	DHT    []dht.Option    // Further DHT options, such as a persistent datastore
+}
+
 // NewHostAndDHT creates a libp2p host with DHT
-func NewHostAndDHT(ctx context.Context, privKey crypto.PrivKey, _ *zap.Logger) (host.Host, *dht.IpfsDHT, error) {
+func NewHostAndDHT(ctx context.Context, privKey crypto.PrivKey, logger *zap.Logger, opts HostOptions) (host.Host, *dht.IpfsDHT, error) {
 	libp2pOpts := []libp2p.Option{
 		libp2p.ListenAddrStrings(defaultListenAddrs...),
 		libp2p.Identity(privKey),
 	}
+	libp2pOpts = append(libp2pOpts, opts.Libp2p...)
 	if os.Getenv("CROWDLLAMA_TEST_MODE") != "1" {
-		// Use static relays for auto-relay functionality
-		staticRelays := []peer.AddrInfo{
-			// Add some well-known libp2p relays here
-			// For now, we'll disable auto-relay to avoid the error
-			// TODO: Add proper static relays when needed
-		}
-
 		libp2pOpts = append(libp2pOpts,
 			libp2p.EnableHolePunching(),
+			libp2p.NATPortMap(),
 		)
+	}
 
-		// Only enable auto-relay if we have static relays
-		if len(staticRelays) > 0 {
-			libp2pOpts = append(libp2pOpts,
-				libp2p.EnableAutoRelayWithStaticRelays(staticRelays),
-			)
-		}
+	staticRelays := ResolveBootstrapPeers(ctx, opts.Relays, logger)
+	if len(opts.Relays) > 0 && len(staticRelays) == 0 {
+		return nil, nil, fmt.Errorf("none of the configured relays could be parsed or resolved: %v", opts.Relays)
+	}
+	if len(staticRelays) > 0 {
+		// Relay reservations are only made while AutoNAT reports the host as private
+		libp2pOpts = append(libp2pOpts, libp2p.EnableAutoRelayWithStaticRelays(staticRelays))
+		logger.Info("Static relays configured", zap.Strings("relays", bootstrapPeerIDs(staticRelays)))
 	}
 
 	h, err := libp2p.New(libp2pOpts...)
@@ -75,71 +87,170 @@ func NewHostAndDHT(ctx context.Context, privKey crypto.PrivKey, _ *zap.Logger) (
 		return nil, nil, fmt.Errorf("create libp2p host: %w", err)
 	}
 
-	kadDHT, err := dht.New(ctx, h, dht.Mode(dht.ModeServer))
+	kadDHT, err := dht.New(ctx, h, append([]dht.Option{dht.Mode(dht.ModeServer)}, opts.DHT...)...)
 	if err != nil {
+		if closeErr := h.Close(); closeErr != nil {
+			logger.Debug("Failed to close host", zap.Error(closeErr))
+		}
 		return nil, nil, fmt.Errorf("create DHT instance: %w", err)
 	}
 
 	return h, kadDHT, nil
 }
 
+// WatchReachability calls onChange whenever AutoNAT updates the host's reachability, until ctx is done
+func WatchReachability(ctx context.Context, h host.Host, logger *zap.Logger, onChange func(network.Reachability)) error {
+	sub, err := h.EventBus().Subscribe(new(event.EvtLocalReachabilityChanged))
+	if err != nil {
+		return fmt.Errorf("subscribe to reachability events: %w", err)
+	}
+
+	go func() {
+		defer func() {
+			if closeErr := sub.Close(); closeErr != nil {
+				logger.Debug("Failed to close reachability subscription", zap.Error(closeErr))
+			}
+		}()
+
+		for {
+			select {
+			case evt, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				reachability := evt.(event.EvtLocalReachabilityChanged).Reachability
+				logger.Info("Reachability changed", zap.String("reachability", reachability.String()))
+				onChange(reachability)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// ErrNoBootstrapPeersReachable is returned when none of the configured bootstrap peers could be connected to
+var ErrNoBootstrapPeersReachable = errors.New("no bootstrap peers reachable")
+
 // BootstrapDHT connects to bootstrap peers. If customPeers is nil, use a local bootstrap address for fast local discovery.
 func BootstrapDHT(ctx context.Context, h host.Host, kadDHT *dht.IpfsDHT, logger *zap.Logger) error {
 	return BootstrapDHTWithPeers(ctx, h, kadDHT, nil, logger)
 }
 
 // BootstrapDHTWithPeers connects to custom bootstrap peers. If customPeers is nil or empty, use defaults.
+// Entries may be regular p2p multiaddrs or /dnsaddr/ names whose TXT records list the actual peers.
 func BootstrapDHTWithPeers(ctx context.Context, h host.Host, kadDHT *dht.IpfsDHT, customPeers []string, logger *zap.Logger) error {
 	var bootstrapPeers []peer.AddrInfo
 
 	if len(customPeers) > 0 {
-		// Use custom bootstrap peers
-		for _, peerAddr := range customPeers {
-			addr, err := multiaddr.NewMultiaddr(peerAddr)
-			if err != nil {
-				logger.Debug("Failed to parse custom bootstrap peer address", zap.String("peer_addr", peerAddr), zap.Error(err))
-				continue
-			}
-			peerInfo, err := peer.AddrInfoFromP2pAddr(addr)
-			if err != nil {
-				logger.Debug("Failed to parse custom bootstrap peer info", zap.String("peer_addr", peerAddr), zap.Error(err))
-				continue
-			}
-			bootstrapPeers = append(bootstrapPeers, *peerInfo)
-		}
-	}
-
-	// If no custom peers or all failed to parse, fallback to default
-	if len(bootstrapPeers) == 0 {
-		addr, err := multiaddr.NewMultiaddr(defaultBootstrapPeerAddr)
-		if err == nil {
-			peerInfo, err := peer.AddrInfoFromP2pAddr(addr)
-			if err != nil {
-				logger.Debug("Failed to parse bootstrap peer info", zap.Error(err))
-				// fallback to default public bootstrap peers
-				bootstrapPeers = dht.GetDefaultBootstrapPeerAddrInfos()
-			} else {
-				bootstrapPeers = []peer.AddrInfo{*peerInfo}
-			}
-		} else {
-			// fallback to default public bootstrap peers
-			bootstrapPeers = dht.GetDefaultBootstrapPeerAddrInfos()
+		bootstrapPeers = ResolveBootstrapPeers(ctx, customPeers, logger)
+		if len(bootstrapPeers) == 0 {
+			return fmt.Errorf("none of the configured bootstrap peers could be parsed or resolved: %v", customPeers)
 		}
+	} else {
+		bootstrapPeers = defaultBootstrapPeers(logger)
 	}
 
+	connected := 0
 	for _, peerInfo := range bootstrapPeers {
 		if err := h.Connect(ctx, peerInfo); err != nil {
 			logger.Debug("Failed to connect to bootstrap", zap.String("peer_id", peerInfo.ID.String()), zap.Error(err))
 		} else {
+			connected++
 			logger.Debug("Connected to bootstrap", zap.String("peer_id", peerInfo.ID.String()))
 		}
 	}
+	if connected == 0 {
+		return fmt.Errorf("%w: tried %d peer(s): %v", ErrNoBootstrapPeersReachable, len(bootstrapPeers), bootstrapPeerIDs(bootstrapPeers))
+	}
+
 	if err := kadDHT.Bootstrap(ctx); err != nil {
 		return fmt.Errorf("bootstrap DHT: %w", err)
 	}
 	return nil
 }
 
+// ResolveBootstrapPeers parses bootstrap multiaddrs into peer infos. /dnsaddr/ entries are expanded
+// using their TXT records, and addresses belonging to the same peer are merged. Entries that cannot
+// be parsed or resolved are logged and skipped.
+func ResolveBootstrapPeers(ctx context.Context, addrs []string, logger *zap.Logger) []peer.AddrInfo {
+	maddrs := make([]multiaddr.Multiaddr, 0, len(addrs))
+	for _, peerAddr := range addrs {
+		addr, err := multiaddr.NewMultiaddr(peerAddr)
+		if err != nil {
+			logger.Warn("Failed to parse bootstrap peer address", zap.String("peer_addr", peerAddr), zap.Error(err))
+			continue
+		}
+
+		if !isDNSAddr(addr) {
+			maddrs = append(maddrs, addr)
+			continue
+		}
+
+		resolved, err := madns.Resolve(ctx, addr)
+		if err != nil {
+			logger.Warn("Failed to resolve dnsaddr bootstrap entry", zap.String("peer_addr", peerAddr), zap.Error(err))
+			continue
+		}
+		logger.Debug("Resolved dnsaddr bootstrap entry",
+			zap.String("peer_addr", peerAddr),
+			zap.Int("resolved_addrs", len(resolved)))
+		maddrs = append(maddrs, resolved...)
+	}
+
+	peerInfos := make([]peer.AddrInfo, 0, len(maddrs))
+	for _, addr := range maddrs {
+		peerInfo, err := peer.AddrInfoFromP2pAddr(addr)
+		if err != nil {
+			logger.Warn("Bootstrap peer address has no peer ID", zap.String("peer_addr", addr.String()), zap.Error(err))
+			continue
+		}
+		peerInfos = mergeAddrInfo(peerInfos, *peerInfo)
+	}
+	return peerInfos
+}
+
+// defaultBootstrapPeers returns the built-in bootstrap peers used when none are configured
+func defaultBootstrapPeers(logger *zap.Logger) []peer.AddrInfo {
+	addr, err := multiaddr.NewMultiaddr(defaultBootstrapPeerAddr)
+	if err != nil {
+		// fallback to default public bootstrap peers
+		return dht.GetDefaultBootstrapPeerAddrInfos()
+	}
+	peerInfo, err := peer.AddrInfoFromP2pAddr(addr)
+	if err != nil {
+		logger.Debug("Failed to parse bootstrap peer info", zap.Error(err))
+		// fallback to default public bootstrap peers
+		return dht.GetDefaultBootstrapPeerAddrInfos()
+	}
+	return []peer.AddrInfo{*peerInfo}
+}
+
+// isDNSAddr reports whether the multiaddr starts with a /dnsaddr/ component
+func isDNSAddr(addr multiaddr.Multiaddr) bool {
+	return len(addr) > 0 && addr[0].Protocol().Code == multiaddr.P_DNSADDR
+}
+
+// mergeAddrInfo appends info to infos, merging its addresses into an existing entry for the same peer
+func mergeAddrInfo(infos []peer.AddrInfo, info peer.AddrInfo) []peer.AddrInfo {
+	for i := range infos {
+		if infos[i].ID == info.ID {
+			infos[i].Addrs = append(infos[i].Addrs, info.Addrs...)
+			return infos
+		}
+	}
+	return append(infos, info)
+}
+
+// bootstrapPeerIDs returns the peer IDs of the given peers for error reporting
+func bootstrapPeerIDs(infos []peer.AddrInfo) []string {
+	ids := make([]string, 0, len(infos))
+	for _, info := range infos {
+		ids = append(ids, info.ID.String())
+	}
+	return ids
+}
+
 // AdvertiseModel periodically announces model availability
 func AdvertiseModel(ctx context.Context, kadDHT *dht.IpfsDHT, namespace string, logger *zap.Logger) {
 	ticker := time.NewTicker(advertiseInterval)
@@ -219,14 +330,17 @@ func readMetadataStream(stream network.Stream, peerID peer.ID, logger *zap.Logge
 	return metadataJSON, nil
 }
 
+// metadataReadTimeout bounds how long a peer may take to answer a metadata request
+const metadataReadTimeout = 5 * time.Second
+
 // RequestPeerMetadata retrieves metadata from a peer using the metadata protocol
 func RequestPeerMetadata(ctx context.Context, h host.Host, peerID peer.ID, logger *zap.Logger) (*crowdllama.Resource, error) {
 	logger.Debug("Opening stream to peer for metadata request",
 		zap.String("peer_id", peerID.String()),
 		zap.String("protocol", crowdllama.MetadataProtocol))
 
-	// Open a stream to the peer
-	stream, err := h.NewStream(ctx, peerID, crowdllama.MetadataProtocol)
+	// Open a stream to the peer. Metadata is small, so relayed connections to peers behind NAT are fine.
+	stream, err := h.NewStream(network.WithAllowLimitedConn(ctx, "metadata"), peerID, crowdllama.MetadataProtocol)
 	if err != nil {
 		logger.Error("Failed to open stream to peer",
 			zap.String("peer_id", peerID.String()),
@@ -239,7 +353,12 @@ func RequestPeerMetadata(ctx context.Context, h host.Host, peerID peer.ID, logge
 		}
 	}()
 
-	if setDeadlineErr := stream.SetReadDeadline(time.Now().Add(5 * time.Second)); setDeadlineErr != nil {
+	// Never wait past the caller's deadline, e.g. the end of a discovery round
+	readDeadline := time.Now().Add(metadataReadTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(readDeadline) {
+		readDeadline = ctxDeadline
+	}
+	if setDeadlineErr := stream.SetReadDeadline(readDeadline); setDeadlineErr != nil {
 		logger.Warn("failed to set read deadline", zap.Error(setDeadlineErr))
 	}
 
@@ -295,9 +414,6 @@ func processProvider(
 		return nil
 	}
 
-	// Give the peer a moment to set up handlers
-	time.Sleep(100 * time.Millisecond)
-
 	// Request metadata from the peer
 	metadata, err := RequestPeerMetadata(ctx, kadDHT.Host(), provider.ID, logger)
 	if err != nil {
@@ -305,8 +421,9 @@ func processProvider(
 			zap.String("peer_id", peerID),
 			zap.Error(err))
 
-		// Mark the peer as recently removed to prevent repeated connection attempts
-		if peerManager != nil {
+		// Mark the peer as recently removed to prevent repeated connection attempts, unless the request only
+		// failed because the discovery round ended
+		if peerManager != nil && ctx.Err() == nil {
 			peerManager.MarkPeerAsRecentlyRemoved(peerID)
 		}
 		return nil
@@ -328,13 +445,67 @@ func processProvider(
 	return metadata
 }
 
+const (
+	// DefaultMaxProviders is the default number of providers requested per discovery round
+	DefaultMaxProviders = 100
+
+	// DefaultDiscoveryConcurrency is the default number of metadata requests in flight during discovery
+	DefaultDiscoveryConcurrency = 16
+
+	// DefaultDiscoveryTimeout is the default deadline for a whole discovery round
+	DefaultDiscoveryTimeout = 10 * time.Second
+)
+
+// DiscoveryOptions controls a discovery round
+type DiscoveryOptions struct {
+	MaxProviders int           // Maximum number of providers to look up
+	Concurrency  int           // Maximum number of metadata requests in flight
+	RoundTimeout time.Duration // Deadline for the whole round, including metadata requests
+	// OnPeer is called, possibly concurrently, as soon as a peer's metadata has been retrieved
+	OnPeer func(*crowdllama.Resource)
+}
+
+// DefaultDiscoveryOptions returns the default discovery options
+func DefaultDiscoveryOptions() DiscoveryOptions {
+	return DiscoveryOptions{
+		MaxProviders: DefaultMaxProviders,
+		Concurrency:  DefaultDiscoveryConcurrency,
+		RoundTimeout: DefaultDiscoveryTimeout,
+	}
+}
+
 // DiscoverPeers finds peers advertising the namespace and retrieves their metadata
 func DiscoverPeers(ctx context.Context, kadDHT *dht.IpfsDHT, logger *zap.Logger, peerManager interface {
 	MarkPeerAsRecentlyRemoved(string)
 	IsPeerUnhealthy(string) bool
 },
 ) ([]*crowdllama.Resource, error) {
-	peers := make([]*crowdllama.Resource, 0, 10) // Preallocate with capacity 10
+	return DiscoverPeersWithOptions(ctx, kadDHT, logger, peerManager, DefaultDiscoveryOptions())
+}
+
+// DiscoverPeersWithOptions finds peers advertising the namespace and fetches their metadata in parallel.
+// Providers are processed as the DHT returns them, with at most opts.Concurrency requests in flight, and
+// the round ends at opts.RoundTimeout even if some peers have not answered yet.
+func DiscoverPeersWithOptions(
+	ctx context.Context,
+	kadDHT *dht.IpfsDHT,
+	logger *zap.Logger,
+	peerManager interface {
+		MarkPeerAsRecentlyRemoved(string)
+		IsPeerUnhealthy(string) bool
+	},
+	opts DiscoveryOptions,
+) ([]*crowdllama.Resource, error) {
+	defaults := DefaultDiscoveryOptions()
+	if opts.MaxProviders <= 0 {
+		opts.MaxProviders = defaults.MaxProviders
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaults.Concurrency
+	}
+	if opts.RoundTimeout <= 0 {
+		opts.RoundTimeout = defaults.RoundTimeout
+	}
 
 	// Get the namespace CID
 	namespaceCID, err := GetPeerNamespaceCID()
@@ -344,23 +515,58 @@ func DiscoverPeers(ctx context.Context, kadDHT *dht.IpfsDHT, logger *zap.Logger,
 
 	logger.Debug("Searching for peers with namespace CID",
 		zap.String("namespace", crowdllama.PeerNamespace),
-		zap.String("cid", namespaceCID.String()))
+		zap.String("cid", namespaceCID.String()),
+		zap.Int("max_providers", opts.MaxProviders))
+
+	roundCtx, cancel := context.WithTimeout(ctx, opts.RoundTimeout)
+	defer cancel()
 
 	// Find providers for the namespace CID
-	providers := kadDHT.FindProvidersAsync(ctx, namespaceCID, 10)
+	providers := kadDHT.FindProvidersAsync(roundCtx, namespaceCID, opts.MaxProviders)
+
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		peers = make([]*crowdllama.Resource, 0, opts.MaxProviders)
+		sem   = make(chan struct{}, opts.Concurrency)
+	)
 
 	providerCount := 0
 	for provider := range providers {
+		if provider.ID == kadDHT.Host().ID() {
+			continue
+		}
 		providerCount++
-		metadata := processProvider(ctx, provider, kadDHT, logger, peerManager)
-		if metadata != nil {
-			peers = append(peers, metadata)
+
+		select {
+		case sem <- struct{}{}:
+		case <-roundCtx.Done():
+			continue // drain the provider channel; it is closed once roundCtx is done
 		}
+
+		wg.Add(1)
+		go func(provider peer.AddrInfo) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			metadata := processProvider(roundCtx, provider, kadDHT, logger, peerManager)
+			if metadata == nil {
+				return
+			}
+			if opts.OnPeer != nil {
+				opts.OnPeer(metadata)
+			}
+			mu.Lock()
+			peers = append(peers, metadata)
+			mu.Unlock()
+		}(provider)
 	}
+	wg.Wait()
 
 	logger.Debug("Discovery complete",
 		zap.Int("providers_found", providerCount),
-		zap.Int("peers_with_metadata", len(peers)))
+		zap.Int("peers_with_metadata", len(peers)),
+		zap.Bool("round_timed_out", errors.Is(roundCtx.Err(), context.DeadlineExceeded)))
 
 	return peers, nil
 }
@@ -0,0 +1,150 @@
+package discovery
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+)
+
+func newTestNode(ctx context.Context, t *testing.T) (host.Host, *dht.IpfsDHT) {
+	t.Helper()
+	privKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	h, kadDHT, err := NewHostAndDHT(ctx, privKey, zap.NewNop(), HostOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create host: %v", err)
+	}
+	t.Cleanup(func() {
+		if closeErr := kadDHT.Close(); closeErr != nil {
+			t.Logf("Failed to close DHT: %v", closeErr)
+		}
+		if closeErr := h.Close(); closeErr != nil {
+			t.Logf("Failed to close host: %v", closeErr)
+		}
+	})
+	return h, kadDHT
+}
+
+// startWorker makes a node serve metadata after delay and provide the peer namespace
+func startWorker(ctx context.Context, t *testing.T, consumer host.Host, delay time.Duration) host.Host {
+	t.Helper()
+	h, kadDHT := newTestNode(ctx, t)
+
+	h.SetStreamHandler(crowdllama.MetadataProtocol, func(s network.Stream) {
+		// The handler may outlive the test, so it must not use t
+		defer func() { _ = s.Close() }()
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		resource := crowdllama.NewCrowdLlamaResource(h.ID().String())
+		resource.WorkerMode = true
+		if data, err := resource.ToJSON(); err == nil {
+			_, _ = s.Write(data)
+		}
+	})
+
+	if err := h.Connect(ctx, peer.AddrInfo{ID: consumer.ID(), Addrs: consumer.Addrs()}); err != nil {
+		t.Fatalf("Failed to connect worker to consumer: %v", err)
+	}
+	for kadDHT.RoutingTable().Size() == 0 {
+		if ctx.Err() != nil {
+			t.Fatal("Timed out waiting for the consumer to join the routing table")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	namespaceCID, err := GetPeerNamespaceCID()
+	if err != nil {
+		t.Fatalf("Failed to get namespace CID: %v", err)
+	}
+	if err := kadDHT.Provide(ctx, namespaceCID, true); err != nil {
+		t.Fatalf("Failed to provide namespace: %v", err)
+	}
+	return h
+}
+
+// recordingPeerManager records the peers discovery marks as recently removed
+type recordingPeerManager struct {
+	mu      sync.Mutex
+	removed map[string]bool
+}
+
+func (m *recordingPeerManager) MarkPeerAsRecentlyRemoved(peerID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removed[peerID] = true
+}
+
+func (m *recordingPeerManager) IsPeerUnhealthy(string) bool {
+	return false
+}
+
+func TestDiscoverPeersWithOptions(t *testing.T) {
+	t.Setenv("CROWDLLAMA_TEST_MODE", "1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	consumer, consumerDHT := newTestNode(ctx, t)
+	fastWorkers := []host.Host{
+		startWorker(ctx, t, consumer, 0),
+		startWorker(ctx, t, consumer, 0),
+		startWorker(ctx, t, consumer, 0),
+	}
+	slowWorker := startWorker(ctx, t, consumer, time.Minute)
+
+	var mu sync.Mutex
+	streamed := make(map[string]bool)
+	opts := DiscoveryOptions{
+		MaxProviders: 10,
+		Concurrency:  2,
+		RoundTimeout: 2 * time.Second,
+		OnPeer: func(resource *crowdllama.Resource) {
+			mu.Lock()
+			defer mu.Unlock()
+			streamed[resource.PeerID] = true
+		},
+	}
+
+	start := time.Now()
+	manager := &recordingPeerManager{removed: make(map[string]bool)}
+	peers, err := DiscoverPeersWithOptions(ctx, consumerDHT, zap.NewNop(), manager, opts)
+	if err != nil {
+		t.Fatalf("Discovery failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Discovery round took %v, expected it to end near the 2s round deadline", elapsed)
+	}
+
+	if len(peers) != len(fastWorkers) {
+		t.Errorf("Expected %d peers, got %d", len(fastWorkers), len(peers))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, worker := range fastWorkers {
+		if !streamed[worker.ID().String()] {
+			t.Errorf("Expected worker %s to be reported through OnPeer", worker.ID())
+		}
+	}
+	if streamed[slowWorker.ID().String()] {
+		t.Error("Expected unresponsive worker to be dropped at the round deadline")
+	}
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	if manager.removed[slowWorker.ID().String()] {
+		t.Error("Expected a worker cut off by the round deadline not to be marked as recently removed")
+	}
+}
@@ -4,7 +4,10 @@ package config
 import (
 	"flag"
 	"fmt"
+	"os"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
@@ -13,12 +16,55 @@ import (
 
 // WorkerCfg contains worker-specific configuration
 type WorkerCfg struct {
-	OllamaBaseURL string // Base URL for Ollama API endpoint (e.g., "http://localhost:11434")
+	OllamaBaseURL string   // Base URL for Ollama API endpoint (e.g., "http://localhost:11434")
+	VisionModels  []string // Served models that accept image input, e.g. llava
+	Backend       string   // Inference backend: ollama (default), openai or echo
+	BackendURL    string   // Base URL of the inference backend; the Ollama URL is used for ollama when empty
+	BackendAPIKey string   // Bearer token for OpenAI compatible backends that require one
+	// Further backends as <type>=<url>; requests are routed to the backend listing the requested model
+	BackendEndpoints []string
+	Models           []string // Models to advertise besides those the backends list, served by the first backend
+	PullModels       bool     // Pull models through Ollama once requests for them are observed
+	PullThreshold    int      // Peers asking for a missing model that trigger a pull; 0 uses the default of 3
+	ModelDiskQuotaGB int      // Disk space all Ollama models may take together when pulling; 0 means no limit
+	AllowModels      []string // Model name patterns the worker serves, e.g. llama3*; empty allows all
+	DenyModels       []string // Model name patterns the worker refuses even if allowed
+	MaxModelSizeGB   int      // Largest model the worker serves or pulls, in GB; 0 means no limit
+	MaxContext       int      // Largest num_ctx a request may ask for; 0 means no limit
+	MaxPredict       int      // Largest num_predict a request may ask for, also applied when it sets none; 0 means no limit
+	Schedule         string   // Weekly windows in which the worker serves, e.g. "mon-fri 22:00-07:00; sat,sun"; empty serves always
+	// How long a worker shutting down waits for in-flight requests; 0 uses the default of one minute
+	DrainTimeout time.Duration
+	// Pause and lower the advertised capacity while other processes use the CPU or GPU
+	YieldToLocalUse   bool
+	LocalUseThreshold int      // Percentage of CPU or GPU used by other processes that pauses the worker; 0 uses 50
+	BackendProcesses  []string // Process names whose usage is the worker's own; empty uses Ollama, llama.cpp and vLLM
+	// Consumers the worker serves: peer IDs allowed outright and peer IDs of issuers whose access tokens it honours.
+	// Both empty serves every peer.
+	AllowPeers     []string
+	TrustedIssuers []string
+	// Limits per consumer peer; 0 uses the defaults of 120 requests per minute and 8 at once, negative means no limit
+	PeerRequestsPerMinute int
+	PeerMaxConcurrent     int
+	PeerBanDuration       time.Duration // How long peers sending malformed messages are refused; 0 uses 15m
+}
+
+// DHTCfg contains DHT server-specific configuration
+type DHTCfg struct {
+	Siblings     []string // Multiaddrs of sibling DHT servers to keep permanent connections to
+	RelayService bool     // Act as a circuit v2 relay for peers behind NAT
 }
 
 // ConsumerCfg contains consumer-specific configuration
 type ConsumerCfg struct {
-	// Consumer-specific fields can be added here in the future
+	APIKeysFile string   // JSON file of API keys clients must send to the gateway; see gateway.LoadAPIKeys
+	APIKeys     []string // Further API keys as name=key with all scopes; no keys at all disables authentication
+	// Limits per API key, or per IP address without keys; 0 means no limit. Keys in the keys file may override them.
+	RequestsPerMinute     int
+	MaxConcurrentRequests int
+	DailyTokenQuota       int64    // Prompt and generated tokens per UTC day
+	AccessToken           string   // Token presented to workers that only serve consumers holding one
+	PrepaidCredits        []string // Tokens paid to workers in advance as peer=tokens; needs LedgerFile
 }
 
 // Configuration is the main configuration structure that embeds worker and consumer configs
@@ -27,9 +73,18 @@ type Configuration struct {
 	KeyPath        string // Path to the private key file
 	Logger         *zap.Logger
 	OllamaBaseURL  string
-	BootstrapPeers []string
+	BootstrapPeers []string // Bootstrap peer multiaddrs, including /dnsaddr/ entries
+	BootstrapFile  string   // Path to a file listing one bootstrap multiaddr per line
+	StateDir       string   // Directory for persisted peer state; empty disables persistence
+	LedgerFile     string   // File of the receipts exchanged with other peers; empty disables credit accounting
+	Relays         []string // Static circuit relay multiaddrs used when this peer is not publicly reachable
+	MaxProviders   int      // Maximum providers looked up per discovery round; 0 uses the default
+	// Maximum inference message size in MB, applied to both sending and receiving; 0 uses the default of 10MB
+	MaxMessageSizeMB   int
+	DisableCompression bool // Never compress outgoing inference messages, e.g. to save CPU on fast links
 	WorkerCfg
 	ConsumerCfg
+	DHTCfg
 }
 
 // NewConfiguration creates a new configuration with default values
@@ -42,7 +97,7 @@ func NewConfiguration() *Configuration {
 	}
 }
 
-// ParseFlags parses command line flags and updates the configuration
+// ParseFlags registers the command line flags every binary shares on flagSet
 func (cfg *Configuration) ParseFlags(flagSet *flag.FlagSet) {
 	flagSet.BoolVar(&cfg.Verbose, "verbose", cfg.Verbose, "Enable verbose logging")
 	flagSet.StringVar(&cfg.KeyPath, "key", cfg.KeyPath, "Path to private key file (default: ~/.crowdllama/<component>.key)")
@@ -52,6 +107,24 @@ func (cfg *Configuration) ParseFlags(flagSet *flag.FlagSet) {
 		cfg.OllamaBaseURL,
 		"Base URL for Ollama API endpoint (e.g., http://localhost:11434)",
 	)
+	flagSet.Func("bootstrap", "Bootstrap peer multiaddr, may be repeated or comma-separated (supports /dnsaddr/)", func(value string) error {
+		cfg.BootstrapPeers = append(cfg.BootstrapPeers, SplitList(value)...)
+		return nil
+	})
+	flagSet.StringVar(&cfg.BootstrapFile, "bootstrap-file", cfg.BootstrapFile, "Path to a file with one bootstrap multiaddr per line")
+	flagSet.StringVar(&cfg.StateDir, "state-dir", cfg.StateDir,
+		"Directory to persist peers and DHT records across restarts (e.g. ~/.crowdllama/state; default: disabled)")
+}
+
+// ParseDHTFlags registers the command line flags of the DHT server on flagSet. Worker and consumer flags are
+// defined by the crowdllama command.
+func (cfg *Configuration) ParseDHTFlags(flagSet *flag.FlagSet) {
+	cfg.ParseFlags(flagSet)
+	flagSet.BoolVar(&cfg.RelayService, "relay-service", cfg.RelayService, "Act as a circuit v2 relay for peers behind NAT")
+	flagSet.Func("sibling", "Sibling DHT server multiaddr to stay connected to, may be repeated or comma-separated", func(value string) error {
+		cfg.Siblings = append(cfg.Siblings, SplitList(value)...)
+		return nil
+	})
 }
 
 // LoadFromEnvironment loads configuration from environment variables
@@ -76,6 +149,223 @@ func (cfg *Configuration) LoadFromEnvironment() {
 	if viper.IsSet("OLLAMA_URL") {
 		cfg.OllamaBaseURL = viper.GetString("OLLAMA_URL")
 	}
+
+	if viper.IsSet("BOOTSTRAP_PEERS") {
+		cfg.BootstrapPeers = SplitList(viper.GetString("BOOTSTRAP_PEERS"))
+	}
+
+	if viper.IsSet("BOOTSTRAP_FILE") {
+		cfg.BootstrapFile = viper.GetString("BOOTSTRAP_FILE")
+	}
+
+	if viper.IsSet("STATE_DIR") {
+		cfg.StateDir = viper.GetString("STATE_DIR")
+	}
+
+	if viper.IsSet("LEDGER_FILE") {
+		cfg.LedgerFile = viper.GetString("LEDGER_FILE")
+	}
+
+	if viper.IsSet("RELAYS") {
+		cfg.Relays = SplitList(viper.GetString("RELAYS"))
+	}
+
+	if viper.IsSet("MAX_PROVIDERS") {
+		cfg.MaxProviders = viper.GetInt("MAX_PROVIDERS")
+	}
+
+	if viper.IsSet("MAX_MESSAGE_SIZE_MB") {
+		cfg.MaxMessageSizeMB = viper.GetInt("MAX_MESSAGE_SIZE_MB")
+	}
+
+	if viper.IsSet("DISABLE_COMPRESSION") {
+		cfg.DisableCompression = viper.GetBool("DISABLE_COMPRESSION")
+	}
+
+	if viper.IsSet("VISION_MODELS") {
+		cfg.VisionModels = SplitList(viper.GetString("VISION_MODELS"))
+	}
+
+	if viper.IsSet("BACKEND") {
+		cfg.Backend = viper.GetString("BACKEND")
+	}
+
+	if viper.IsSet("BACKEND_URL") {
+		cfg.BackendURL = viper.GetString("BACKEND_URL")
+	}
+
+	if viper.IsSet("BACKEND_API_KEY") {
+		cfg.BackendAPIKey = viper.GetString("BACKEND_API_KEY")
+	}
+
+	if viper.IsSet("BACKEND_ENDPOINTS") {
+		cfg.BackendEndpoints = SplitList(viper.GetString("BACKEND_ENDPOINTS"))
+	}
+
+	if viper.IsSet("MODELS") {
+		cfg.Models = SplitList(viper.GetString("MODELS"))
+	}
+
+	if viper.IsSet("PULL_MODELS") {
+		cfg.PullModels = viper.GetBool("PULL_MODELS")
+	}
+
+	if viper.IsSet("PULL_THRESHOLD") {
+		cfg.PullThreshold = viper.GetInt("PULL_THRESHOLD")
+	}
+
+	if viper.IsSet("MODEL_DISK_QUOTA_GB") {
+		cfg.ModelDiskQuotaGB = viper.GetInt("MODEL_DISK_QUOTA_GB")
+	}
+
+	if viper.IsSet("ALLOW_MODELS") {
+		cfg.AllowModels = SplitList(viper.GetString("ALLOW_MODELS"))
+	}
+
+	if viper.IsSet("DENY_MODELS") {
+		cfg.DenyModels = SplitList(viper.GetString("DENY_MODELS"))
+	}
+
+	if viper.IsSet("MAX_MODEL_SIZE_GB") {
+		cfg.MaxModelSizeGB = viper.GetInt("MAX_MODEL_SIZE_GB")
+	}
+
+	if viper.IsSet("MAX_CONTEXT") {
+		cfg.MaxContext = viper.GetInt("MAX_CONTEXT")
+	}
+
+	if viper.IsSet("MAX_PREDICT") {
+		cfg.MaxPredict = viper.GetInt("MAX_PREDICT")
+	}
+
+	if viper.IsSet("SCHEDULE") {
+		cfg.Schedule = viper.GetString("SCHEDULE")
+	}
+
+	if viper.IsSet("DRAIN_TIMEOUT") {
+		cfg.DrainTimeout = viper.GetDuration("DRAIN_TIMEOUT")
+	}
+
+	if viper.IsSet("API_KEYS_FILE") {
+		cfg.APIKeysFile = viper.GetString("API_KEYS_FILE")
+	}
+
+	if viper.IsSet("API_KEYS") {
+		cfg.APIKeys = SplitList(viper.GetString("API_KEYS"))
+	}
+
+	if viper.IsSet("REQUESTS_PER_MINUTE") {
+		cfg.RequestsPerMinute = viper.GetInt("REQUESTS_PER_MINUTE")
+	}
+
+	if viper.IsSet("MAX_CONCURRENT_REQUESTS") {
+		cfg.MaxConcurrentRequests = viper.GetInt("MAX_CONCURRENT_REQUESTS")
+	}
+
+	if viper.IsSet("DAILY_TOKEN_QUOTA") {
+		cfg.DailyTokenQuota = viper.GetInt64("DAILY_TOKEN_QUOTA")
+	}
+
+	if viper.IsSet("ACCESS_TOKEN") {
+		cfg.AccessToken = viper.GetString("ACCESS_TOKEN")
+	}
+
+	if viper.IsSet("PREPAID_CREDITS") {
+		cfg.PrepaidCredits = SplitList(viper.GetString("PREPAID_CREDITS"))
+	}
+
+	if viper.IsSet("ALLOW_PEERS") {
+		cfg.AllowPeers = SplitList(viper.GetString("ALLOW_PEERS"))
+	}
+
+	if viper.IsSet("TRUSTED_ISSUERS") {
+		cfg.TrustedIssuers = SplitList(viper.GetString("TRUSTED_ISSUERS"))
+	}
+
+	if viper.IsSet("YIELD_TO_LOCAL_USE") {
+		cfg.YieldToLocalUse = viper.GetBool("YIELD_TO_LOCAL_USE")
+	}
+
+	if viper.IsSet("LOCAL_USE_THRESHOLD") {
+		cfg.LocalUseThreshold = viper.GetInt("LOCAL_USE_THRESHOLD")
+	}
+
+	if viper.IsSet("PEER_REQUESTS_PER_MINUTE") {
+		cfg.PeerRequestsPerMinute = viper.GetInt("PEER_REQUESTS_PER_MINUTE")
+	}
+
+	if viper.IsSet("PEER_MAX_CONCURRENT") {
+		cfg.PeerMaxConcurrent = viper.GetInt("PEER_MAX_CONCURRENT")
+	}
+
+	if viper.IsSet("PEER_BAN_DURATION") {
+		cfg.PeerBanDuration = viper.GetDuration("PEER_BAN_DURATION")
+	}
+
+	if viper.IsSet("BACKEND_PROCESSES") {
+		cfg.BackendProcesses = SplitList(viper.GetString("BACKEND_PROCESSES"))
+	}
+
+	if viper.IsSet("DHT_SIBLINGS") {
+		cfg.Siblings = SplitList(viper.GetString("DHT_SIBLINGS"))
+	}
+
+	if viper.IsSet("DHT_RELAY_SERVICE") {
+		cfg.RelayService = viper.GetBool("DHT_RELAY_SERVICE")
+	}
+}
+
+// GetBootstrapPeers returns the configured bootstrap peers, including the entries of the bootstrap file if one is set
+func (cfg *Configuration) GetBootstrapPeers() ([]string, error) {
+	peers := make([]string, 0, len(cfg.BootstrapPeers))
+	seen := make(map[string]bool)
+	add := func(addr string) {
+		if !seen[addr] {
+			seen[addr] = true
+			peers = append(peers, addr)
+		}
+	}
+
+	for _, addr := range cfg.BootstrapPeers {
+		add(addr)
+	}
+
+	if cfg.BootstrapFile != "" {
+		filePeers, err := ReadBootstrapFile(cfg.BootstrapFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range filePeers {
+			add(addr)
+		}
+	}
+
+	return peers, nil
+}
+
+// ReadBootstrapFile reads bootstrap multiaddrs from a file. Blank lines and lines starting with '#' are ignored.
+func ReadBootstrapFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read bootstrap file %s: %w", path, err)
+	}
+
+	var peers []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		peers = append(peers, SplitList(line)...)
+	}
+	return peers, nil
+}
+
+// SplitList splits a comma or whitespace separated list, e.g. of multiaddrs, peer IDs or model names
+func SplitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
 }
 
 // IsVerbose returns true if verbose logging is enabled
@@ -88,6 +378,14 @@ func (cfg *Configuration) GetOllamaBaseURL() string {
 	return cfg.OllamaBaseURL
 }
 
+// GetBackendURL returns the base URL of the inference backend, defaulting to the Ollama URL for Ollama
+func (cfg *Configuration) GetBackendURL() string {
+	if cfg.BackendURL == "" && (cfg.Backend == "" || cfg.Backend == "ollama") {
+		return cfg.GetOllamaBaseURL()
+	}
+	return cfg.BackendURL
+}
+
 // SetupLogger initializes the zap logger based on configuration
 func (cfg *Configuration) SetupLogger() error {
 	var logger *zap.Logger
@@ -2,7 +2,9 @@ package config
 
 import (
 	"flag"
+	"io"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -155,3 +157,108 @@ func TestLoadFromEnvironment(t *testing.T) {
 		t.Errorf("Expected OllamaBaseURL to be custom, got %s", cfg2.GetOllamaBaseURL())
 	}
 }
+
+func TestParseFlagsBootstrap(t *testing.T) {
+	config := NewConfiguration()
+	flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+	config.ParseFlags(flagSet)
+
+	args := []string{
+		"-bootstrap", "/ip4/10.0.0.1/tcp/9000/p2p/12D3KooWLLUBEZhkEq6NtTLD99RRpEYdcbe8uzx3L56UgF5VK4bw",
+		"-bootstrap", "/dnsaddr/bootstrap.example.org,/ip4/10.0.0.2/tcp/9000/p2p/12D3KooWGtAsTBuXFJrywcneqUYsGLD6ym9en2uqc56g4fMySVcK",
+		"-bootstrap-file", "/etc/crowdllama/bootstrap.txt",
+	}
+	if err := flagSet.Parse(args); err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+
+	if len(config.BootstrapPeers) != 3 {
+		t.Fatalf("Expected 3 bootstrap peers, got %d: %v", len(config.BootstrapPeers), config.BootstrapPeers)
+	}
+	if config.BootstrapPeers[1] != "/dnsaddr/bootstrap.example.org" {
+		t.Errorf("Expected dnsaddr entry to be kept as-is, got %s", config.BootstrapPeers[1])
+	}
+	if config.BootstrapFile != "/etc/crowdllama/bootstrap.txt" {
+		t.Errorf("Expected BootstrapFile to be set, got %s", config.BootstrapFile)
+	}
+}
+
+func TestLoadBootstrapPeersFromEnvironment(t *testing.T) {
+	t.Setenv("CROWDLLAMA_BOOTSTRAP_PEERS", "/dnsaddr/a.example.org, /dnsaddr/b.example.org")
+	t.Setenv("CROWDLLAMA_BOOTSTRAP_FILE", "/tmp/bootstrap.txt")
+
+	cfg := NewConfiguration()
+	cfg.LoadFromEnvironment()
+
+	if len(cfg.BootstrapPeers) != 2 {
+		t.Fatalf("Expected 2 bootstrap peers, got %d: %v", len(cfg.BootstrapPeers), cfg.BootstrapPeers)
+	}
+	if cfg.BootstrapPeers[0] != "/dnsaddr/a.example.org" || cfg.BootstrapPeers[1] != "/dnsaddr/b.example.org" {
+		t.Errorf("Unexpected bootstrap peers: %v", cfg.BootstrapPeers)
+	}
+	if cfg.BootstrapFile != "/tmp/bootstrap.txt" {
+		t.Errorf("Expected BootstrapFile to be /tmp/bootstrap.txt, got %s", cfg.BootstrapFile)
+	}
+}
+
+func TestGetBootstrapPeersWithFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bootstrap.txt")
+	content := "# CrowdLlama bootstrap nodes\n" +
+		"/dnsaddr/bootstrap.example.org\n" +
+		"\n" +
+		"/ip4/10.0.0.1/tcp/9000/p2p/12D3KooWLLUBEZhkEq6NtTLD99RRpEYdcbe8uzx3L56UgF5VK4bw\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write bootstrap file: %v", err)
+	}
+
+	cfg := NewConfiguration()
+	cfg.BootstrapPeers = []string{"/dnsaddr/bootstrap.example.org"}
+	cfg.BootstrapFile = path
+
+	peers, err := cfg.GetBootstrapPeers()
+	if err != nil {
+		t.Fatalf("GetBootstrapPeers failed: %v", err)
+	}
+
+	// The duplicate dnsaddr entry from the file must be dropped
+	if len(peers) != 2 {
+		t.Fatalf("Expected 2 bootstrap peers, got %d: %v", len(peers), peers)
+	}
+
+	cfg.BootstrapFile = filepath.Join(t.TempDir(), "missing.txt")
+	if _, err := cfg.GetBootstrapPeers(); err == nil {
+		t.Error("Expected an error for a missing bootstrap file")
+	}
+}
+
+func TestLoadRelayConfigFromEnvironment(t *testing.T) {
+	t.Setenv("CROWDLLAMA_RELAYS", "/dnsaddr/relay.example.org")
+	t.Setenv("CROWDLLAMA_DHT_RELAY_SERVICE", "true")
+
+	cfg := NewConfiguration()
+	cfg.LoadFromEnvironment()
+
+	if len(cfg.Relays) != 1 || cfg.Relays[0] != "/dnsaddr/relay.example.org" {
+		t.Errorf("Unexpected relays: %v", cfg.Relays)
+	}
+	if !cfg.RelayService {
+		t.Error("Expected RelayService to be enabled")
+	}
+}
+
+func TestParseDHTFlags(t *testing.T) {
+	config := NewConfiguration()
+	flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+	flagSet.SetOutput(io.Discard)
+	config.ParseDHTFlags(flagSet)
+
+	if err := flagSet.Parse([]string{"-relay-service", "-sibling", "/ip4/10.0.0.3/tcp/9000,/ip4/10.0.0.4/tcp/9000"}); err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+	if !config.RelayService || len(config.Siblings) != 2 {
+		t.Errorf("Expected the relay service and 2 siblings, got %v and %v", config.RelayService, config.Siblings)
+	}
+	if err := flagSet.Parse([]string{"-model", "llama3.2"}); err == nil {
+		t.Error("Expected worker flags not to be registered on the DHT server")
+	}
+}
@@ -0,0 +1,87 @@
+package crowdllama
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// accessTokenDomain is prepended to the signed claims so that access token signatures cannot be mistaken
+// for signatures the issuer's key makes for other purposes
+const accessTokenDomain = "crowdllama-access-token:"
+
+// MaxAccessTokenSize bounds the size of an access token sent over AccessProtocol
+const MaxAccessTokenSize = 4096
+
+// AccessToken grants a consumer peer inference on workers that trust the token's issuer. Tokens are
+// encoded as the base64 claims and the base64 signature of the issuer's key, joined by a dot.
+type AccessToken struct {
+	Issuer  string    `json:"iss"` // peer ID of the issuer's key
+	Subject string    `json:"sub"` // peer ID of the consumer the token grants access to
+	Expires time.Time `json:"exp"`
+}
+
+// AccessGrant is a worker's answer to an access token sent over AccessProtocol
+type AccessGrant struct {
+	Expires time.Time `json:"expires,omitzero"` // when the worker stops honouring the token
+	Error   string    `json:"error,omitempty"`  // why the token was refused
+}
+
+// IssueAccessToken signs a token granting subject access until expires
+func IssueAccessToken(issuerKey crypto.PrivKey, subject peer.ID, expires time.Time) (string, error) {
+	issuer, err := peer.IDFromPrivateKey(issuerKey)
+	if err != nil {
+		return "", fmt.Errorf("derive issuer peer ID: %w", err)
+	}
+	claims, err := json.Marshal(AccessToken{Issuer: issuer.String(), Subject: subject.String(), Expires: expires.UTC()})
+	if err != nil {
+		return "", fmt.Errorf("encode access token: %w", err)
+	}
+	signature, err := issuerKey.Sign(append([]byte(accessTokenDomain), claims...))
+	if err != nil {
+		return "", fmt.Errorf("sign access token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(claims) + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// ParseAccessToken decodes a token and verifies its signature with the key embedded in the issuer's peer ID.
+// It does not check whether the issuer is trusted or the token has expired.
+func ParseAccessToken(token string) (*AccessToken, error) {
+	encodedClaims, encodedSignature, ok := strings.Cut(strings.TrimSpace(token), ".")
+	if !ok {
+		return nil, errors.New("malformed access token")
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(encodedClaims)
+	if err != nil {
+		return nil, fmt.Errorf("malformed access token: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, fmt.Errorf("malformed access token: %w", err)
+	}
+
+	var parsed AccessToken
+	if err := json.Unmarshal(claims, &parsed); err != nil {
+		return nil, fmt.Errorf("malformed access token: %w", err)
+	}
+	issuer, err := peer.Decode(parsed.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access token issuer: %w", err)
+	}
+	// Ed25519 peer IDs embed the public key; issuers with other key types cannot be verified
+	key, err := issuer.ExtractPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("access token issuer %s has no embedded public key: %w", issuer, err)
+	}
+	valid, err := key.Verify(append([]byte(accessTokenDomain), claims...), signature)
+	if err != nil || !valid {
+		return nil, errors.New("invalid access token signature")
+	}
+	return &parsed, nil
+}
@@ -0,0 +1,44 @@
+package crowdllama
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crowdllama/crowdllama/pkg/testhelpers/testkeys"
+)
+
+func TestAccessTokenRoundTrip(t *testing.T) {
+	issuerKey, issuer := testkeys.NewKey(t)
+	_, subject := testkeys.NewKey(t)
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	token, err := IssueAccessToken(issuerKey, subject, expires)
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+	parsed, err := ParseAccessToken(token)
+	if err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+	if parsed.Issuer != issuer.String() || parsed.Subject != subject.String() || !parsed.Expires.Equal(expires) {
+		t.Errorf("Unexpected claims %+v", parsed)
+	}
+
+	// The signature of one token does not vouch for the claims of another
+	otherKey, _ := testkeys.NewKey(t)
+	other, err := IssueAccessToken(otherKey, subject, expires)
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+	claims, _, _ := strings.Cut(other, ".")
+	_, signature, _ := strings.Cut(token, ".")
+	if _, err := ParseAccessToken(claims + "." + signature); err == nil {
+		t.Error("Expected a token with swapped claims to be rejected")
+	}
+	for _, malformed := range []string{"", "abc", "abc.def", token + "x"} {
+		if _, err := ParseAccessToken(malformed); err == nil {
+			t.Errorf("Expected %q to be rejected", malformed)
+		}
+	}
+}
@@ -1,12 +1,10 @@
 package crowdllama
 
 import (
-	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"time"
 
 	"go.uber.org/zap"
@@ -20,153 +18,98 @@ type UnifiedAPIHandler func(ctx context.Context, req *llamav1.BaseMessage) (*lla
 
 // OllamaRequest represents the request structure for Ollama API
 type OllamaRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
-	Stream   bool      `json:"stream"`
+	Model    string          `json:"model"`
+	Messages []Message       `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Tools    json.RawMessage `json:"tools,omitempty"`   // tool definitions, passed through unchanged
+	Options  map[string]any  `json:"options,omitempty"` // model options such as num_ctx and num_predict
 }
 
 // Message represents a message in the Ollama API
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	Images    []string   `json:"images,omitempty"`     // base64 encoded images for vision models
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"` // tools the assistant asked to call
+	ToolName  string     `json:"tool_name,omitempty"`  // name of the tool whose result a tool message carries
+}
+
+// ToolCall is a tool invocation requested by the model
+type ToolCall struct {
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction names the called tool and its arguments as a JSON object
+type ToolCallFunction struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// ChatMessage is a conversation message carried in the inference protocol, with images as raw bytes
+type ChatMessage struct {
+	Role      string
+	Content   string
+	Images    [][]byte
+	ToolCalls []ToolCall
+	ToolName  string
 }
 
 // OllamaResponse represents the response structure from Ollama API
 type OllamaResponse struct {
-	Model      string    `json:"model"`
-	CreatedAt  time.Time `json:"created_at"`
-	Message    Message   `json:"message"`
-	Stream     bool      `json:"stream"`
-	DoneReason string    `json:"done_reason"`
-	Done       bool      `json:"done"`
+	Model              string    `json:"model"`
+	CreatedAt          time.Time `json:"created_at"`
+	Message            Message   `json:"message"`
+	Stream             bool      `json:"stream"`
+	DoneReason         string    `json:"done_reason"`
+	Done               bool      `json:"done"`
+	TotalDuration      int64     `json:"total_duration"`       // nanoseconds
+	LoadDuration       int64     `json:"load_duration"`        // nanoseconds
+	PromptEvalCount    int32     `json:"prompt_eval_count"`    // prompt tokens
+	PromptEvalDuration int64     `json:"prompt_eval_duration"` // nanoseconds
+	EvalCount          int32     `json:"eval_count"`           // generated tokens
+	EvalDuration       int64     `json:"eval_duration"`        // nanoseconds
 }
 
 // WorkerAPIHandler provides a worker implementation that calls Ollama API
 func WorkerAPIHandler(ollamaBaseURL string) UnifiedAPIHandler {
-	return func(ctx context.Context, req *llamav1.BaseMessage) (*llamav1.BaseMessage, error) {
-		// Check if this is a GenerateRequest
-		generateReq := req.GetGenerateRequest()
-		if generateReq == nil {
-			return nil, fmt.Errorf("expected GenerateRequest, got different message type")
-		}
-
-		// Get logger from context if available
-		logger := getLoggerFromContext(ctx)
-		if logger != nil {
-			logger.Debug("Worker calling Ollama API",
-				zap.String("model", generateReq.Model),
-				zap.String("ollama_url", ollamaBaseURL))
-		}
-
-		// Call Ollama API
-		ollamaResp, err := callOllamaAPI(ctx, generateReq, ollamaBaseURL)
-		if err != nil {
-			if logger != nil {
-				logger.Error("Failed to call Ollama API", zap.Error(err))
-			}
-			return nil, fmt.Errorf("failed to call Ollama API: %w", err)
-		}
-
-		if logger != nil {
-			logger.Debug("Worker received Ollama response",
-				zap.String("model", ollamaResp.Model),
-				zap.Bool("done", ollamaResp.Done))
-		}
-
-		// Convert Ollama response to protobuf
-		response := &llamav1.GenerateResponse{
-			Model:         ollamaResp.Model,
-			CreatedAt:     timestamppb.New(ollamaResp.CreatedAt),
-			Response:      ollamaResp.Message.Content,
-			Done:          ollamaResp.Done,
-			DoneReason:    ollamaResp.DoneReason,
-			WorkerId:      "worker", // TODO: Get actual worker ID
-			TotalDuration: time.Now().UnixNano(),
-		}
-
-		// Wrap in BaseMessage
-		baseResponse := &llamav1.BaseMessage{
-			Message: &llamav1.BaseMessage_GenerateResponse{
-				GenerateResponse: response,
-			},
-		}
-
-		return baseResponse, nil
-	}
+	return BackendAPIHandler(NewOllamaBackend(ollamaBaseURL))
 }
 
 // getLoggerFromContext extracts logger from context if available
 func getLoggerFromContext(ctx context.Context) *zap.Logger {
-	type loggerKey struct{}
 	if logger, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
 		return logger
 	}
 	return nil
 }
 
-// callOllamaAPI calls the Ollama API with the given request
-func callOllamaAPI(ctx context.Context, req *llamav1.GenerateRequest, baseURL string) (*OllamaResponse, error) {
-	ollamaReq := OllamaRequest{
-		Model: req.Model,
-		Messages: []Message{
-			{
-				Role:    "user",
-				Content: req.Prompt,
-			},
-		},
-		Stream: req.Stream,
-	}
-
-	reqBody, err := json.Marshal(ollamaReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal Ollama request: %w", err)
+// encodeImages base64 encodes images for the Ollama API
+func encodeImages(images [][]byte) []string {
+	if len(images) == 0 {
+		return nil
 	}
-
-	if baseURL == "" {
-		baseURL = "http://localhost:11434"
-	}
-
-	fullURL := baseURL + "/api/chat"
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", fullURL, bytes.NewBuffer(reqBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	encoded := make([]string, 0, len(images))
+	for _, image := range images {
+		encoded = append(encoded, base64.StdEncoding.EncodeToString(image))
 	}
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	resp, err := http.DefaultClient.Do(httpReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to make HTTP request to Ollama: %w", err)
-	}
-	defer func() {
-		if closeErr := resp.Body.Close(); closeErr != nil {
-			// Log the error but don't fail the request
-			if logger := getLoggerFromContext(ctx); logger != nil {
-				logger.Warn("Failed to close response body", zap.Error(closeErr))
-			}
-		}
-	}()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read Ollama response: %w", err)
-	}
-
-	var ollamaResp OllamaResponse
-	if err := json.Unmarshal(respBody, &ollamaResp); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal Ollama response: %w", err)
-	}
-
-	return &ollamaResp, nil
+	return encoded
 }
 
 // DefaultAPIHandler provides a basic implementation that processes GenerateRequest
-func DefaultAPIHandler(_ context.Context, req *llamav1.BaseMessage) (*llamav1.BaseMessage, error) {
+func DefaultAPIHandler(ctx context.Context, req *llamav1.BaseMessage) (*llamav1.BaseMessage, error) {
+	start := time.Now()
+
 	// Check if this is a GenerateRequest
 	generateReq := req.GetGenerateRequest()
 	if generateReq == nil {
 		return nil, fmt.Errorf("expected GenerateRequest, got different message type")
 	}
 
+	workerID := WorkerIDFromContext(ctx)
+	if workerID == "" {
+		workerID = "default-worker"
+	}
+
 	// Create a response
 	response := &llamav1.GenerateResponse{
 		Model:         generateReq.Model,
@@ -174,8 +117,8 @@ func DefaultAPIHandler(_ context.Context, req *llamav1.BaseMessage) (*llamav1.Ba
 		Response:      fmt.Sprintf("Generated response for model %s with prompt: %s", generateReq.Model, generateReq.Prompt),
 		Done:          true,
 		DoneReason:    "stop",
-		WorkerId:      "default-worker",
-		TotalDuration: time.Now().UnixNano(),
+		WorkerId:      workerID,
+		TotalDuration: time.Since(start).Nanoseconds(),
 	}
 
 	// Wrap in BaseMessage
@@ -203,6 +146,15 @@ func CreateGenerateRequest(model, prompt string, stream bool) *llamav1.BaseMessa
 	}
 }
 
+// CreateGenerateRequestWithImages creates a BaseMessage containing a GenerateRequest with attached images
+func CreateGenerateRequestWithImages(model, prompt string, images [][]byte, stream bool) *llamav1.BaseMessage {
+	msg := CreateGenerateRequest(model, prompt, stream)
+	if len(images) > 0 {
+		SetRequestImages(msg.GetGenerateRequest(), images)
+	}
+	return msg
+}
+
 // ExtractGenerateRequest safely extracts a GenerateRequest from a BaseMessage
 func ExtractGenerateRequest(msg *llamav1.BaseMessage) (*llamav1.GenerateRequest, error) {
 	req := msg.GetGenerateRequest()
@@ -0,0 +1,73 @@
+package crowdllama
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWorkerAPIHandlerToolCalls(t *testing.T) {
+	var received OllamaRequest
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"model":"llama3.1","message":{"role":"assistant","content":"",
+			"tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Paris"}}}]},"done":true,"done_reason":"stop",
+			"total_duration":5000000,"load_duration":1000000,"prompt_eval_count":12,"prompt_eval_duration":2000000,
+			"eval_count":7,"eval_duration":1500000}`))
+	}))
+	defer ollama.Close()
+
+	req := CreateGenerateRequest("llama3.1", "What is the weather in Paris?", true)
+	tools := json.RawMessage(`[{"type":"function","function":{"name":"get_weather"}}]`)
+	SetRequestTools(req.GetGenerateRequest(), tools)
+	if err := SetRequestMessages(req.GetGenerateRequest(), []ChatMessage{
+		{Role: "system", Content: "You are a weather bot"},
+		{Role: "user", Content: "What is the weather in Paris?"},
+	}); err != nil {
+		t.Fatalf("Failed to set messages: %v", err)
+	}
+
+	ctx := WithWorkerID(context.Background(), "12D3KooWorker")
+	resp, err := WorkerAPIHandler(ollama.URL)(ctx, req)
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	if received.Stream {
+		t.Error("Expected Ollama to be called without streaming")
+	}
+	if string(received.Tools) != string(tools) {
+		t.Errorf("Expected tools %s to be passed through, got %s", tools, received.Tools)
+	}
+	if len(received.Messages) != 2 || received.Messages[0].Role != "system" {
+		t.Errorf("Expected the conversation to be passed through, got %+v", received.Messages)
+	}
+
+	generateResp := resp.GetGenerateResponse()
+	if generateResp.GetWorkerId() != "12D3KooWorker" {
+		t.Errorf("Expected the worker ID from the context, got %q", generateResp.GetWorkerId())
+	}
+	if generateResp.GetPromptEvalCount() != 12 || generateResp.GetEvalCount() != 7 {
+		t.Errorf("Expected token counts 12/7, got %d/%d", generateResp.GetPromptEvalCount(), generateResp.GetEvalCount())
+	}
+	if generateResp.GetLoadDuration() != 1000000 || generateResp.GetEvalDuration() != 1500000 {
+		t.Errorf("Expected Ollama durations to be passed through, got %+v", generateResp)
+	}
+	if d := generateResp.GetTotalDuration(); d < 5000000 || d > int64(time.Minute) {
+		t.Errorf("Expected total duration to be a duration of at least Ollama's, got %d", d)
+	}
+
+	toolCalls, err := GetResponseToolCalls(generateResp)
+	if err != nil {
+		t.Fatalf("Failed to read tool calls: %v", err)
+	}
+	if len(toolCalls) != 1 || toolCalls[0].Function.Name != "get_weather" || string(toolCalls[0].Function.Arguments) != `{"city":"Paris"}` {
+		t.Errorf("Unexpected tool calls: %+v", toolCalls)
+	}
+}
@@ -0,0 +1,308 @@
+package crowdllama
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	llamav1 "github.com/crowdllama/crowdllama-pb/llama/v1"
+)
+
+// Inference backends a worker can be configured with
+const (
+	BackendOllama = "ollama" // Ollama's native /api/chat endpoint
+	BackendOpenAI = "openai" // any OpenAI compatible /v1/chat/completions server, e.g. vLLM or llama.cpp server
+	BackendEcho   = "echo"   // deterministic backend answering with the last user message, for tests and demos
+)
+
+// maxErrorBodySize bounds how much of an error response from a backend is included in the returned error
+const maxErrorBodySize = 1024
+
+// Backend runs inference for a worker
+type Backend interface {
+	// Name identifies the backend in logs and errors
+	Name() string
+
+	// Chat answers a conversation with a single, complete assistant message
+	Chat(ctx context.Context, req *ChatRequest) (*ChatResponse, error)
+
+	// ListModels returns the models the backend serves, or nil if it cannot tell
+	ListModels(ctx context.Context) ([]string, error)
+}
+
+// ChatRequest is a conversation handed to a backend
+type ChatRequest struct {
+	Model    string
+	Messages []ChatMessage
+	Tools    json.RawMessage // tool definitions in OpenAI/Ollama format, passed through unchanged
+	Options  map[string]any  // model options in Ollama's format, e.g. num_ctx and num_predict
+}
+
+// ChatResponse is the assistant message produced by a backend together with its statistics. Durations are
+// in nanoseconds; backends that do not report a value leave it at zero.
+type ChatResponse struct {
+	Model              string
+	CreatedAt          time.Time
+	Content            string
+	ToolCalls          []ToolCall
+	DoneReason         string
+	TotalDuration      int64
+	LoadDuration       int64
+	PromptEvalCount    int32
+	PromptEvalDuration int64
+	EvalCount          int32
+	EvalDuration       int64
+}
+
+// BackendConfig selects and configures the backend of a worker
+type BackendConfig struct {
+	Type   string // one of the Backend* constants; empty selects Ollama
+	URL    string // base URL of the inference server
+	APIKey string // bearer token sent to OpenAI compatible servers, if any
+}
+
+// NewBackend creates the backend described by cfg
+func NewBackend(cfg BackendConfig) (Backend, error) {
+	switch cfg.Type {
+	case "", BackendOllama:
+		return NewOllamaBackend(cfg.URL), nil
+	case BackendOpenAI:
+		if cfg.URL == "" {
+			return nil, errors.New("the openai backend requires a URL")
+		}
+		return NewOpenAIBackend(cfg.URL, cfg.APIKey), nil
+	case BackendEcho:
+		return NewEchoBackend(), nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q", cfg.Type)
+	}
+}
+
+// BackendAPIHandler returns an API handler that answers generate requests with the given backend
+func BackendAPIHandler(backend Backend) UnifiedAPIHandler {
+	return func(ctx context.Context, req *llamav1.BaseMessage) (*llamav1.BaseMessage, error) {
+		start := time.Now()
+
+		generateReq := req.GetGenerateRequest()
+		if generateReq == nil {
+			return nil, fmt.Errorf("expected GenerateRequest, got different message type")
+		}
+
+		logger := getLoggerFromContext(ctx)
+		if logger != nil {
+			logger.Debug("Worker calling inference backend",
+				zap.String("model", generateReq.Model),
+				zap.String("backend", backend.Name()))
+		}
+
+		chatReq, err := ChatRequestFromPB(generateReq)
+		if err != nil {
+			return nil, err
+		}
+		chatResp, err := backend.Chat(ctx, chatReq)
+		if err != nil {
+			if logger != nil {
+				logger.Error("Inference backend failed", zap.String("backend", backend.Name()), zap.Error(err))
+			}
+			return nil, fmt.Errorf("inference backend: %w", err)
+		}
+
+		if logger != nil {
+			logger.Debug("Worker received backend response",
+				zap.String("model", chatResp.Model),
+				zap.String("done_reason", chatResp.DoneReason))
+		}
+
+		// The total duration covers the whole request on the worker, including the round trip to the backend
+		response := &llamav1.GenerateResponse{
+			Model:              chatResp.Model,
+			CreatedAt:          timestamppb.New(chatResp.CreatedAt),
+			Response:           chatResp.Content,
+			Done:               true,
+			DoneReason:         chatResp.DoneReason,
+			WorkerId:           WorkerIDFromContext(ctx),
+			TotalDuration:      max(time.Since(start).Nanoseconds(), chatResp.TotalDuration),
+			LoadDuration:       chatResp.LoadDuration,
+			PromptEvalCount:    chatResp.PromptEvalCount,
+			PromptEvalDuration: chatResp.PromptEvalDuration,
+			EvalCount:          chatResp.EvalCount,
+			EvalDuration:       chatResp.EvalDuration,
+		}
+		if response.Model == "" {
+			response.Model = generateReq.Model
+		}
+		if err := SetResponseToolCalls(response, chatResp.ToolCalls); err != nil {
+			return nil, err
+		}
+
+		return &llamav1.BaseMessage{
+			Message: &llamav1.BaseMessage_GenerateResponse{
+				GenerateResponse: response,
+			},
+		}, nil
+	}
+}
+
+// ChatRequestFromPB returns the conversation of a generate request. Requests without a conversation history
+// carry a single user message in the prompt.
+func ChatRequestFromPB(req *llamav1.GenerateRequest) (*ChatRequest, error) {
+	options, err := GetRequestOptions(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	messages := GetRequestMessages(req)
+	if len(messages) == 0 {
+		messages = []ChatMessage{{Role: "user", Content: req.Prompt, Images: GetRequestImages(req)}}
+	}
+	return &ChatRequest{
+		Model:    req.Model,
+		Messages: messages,
+		Tools:    GetRequestTools(req),
+		Options:  options,
+	}, nil
+}
+
+// ErrModelNotFound is returned by backends asked for a model they do not have
+var ErrModelNotFound = errors.New("model not found")
+
+// ErrInvalidRequest is returned for requests a worker cannot make sense of
+var ErrInvalidRequest = errors.New("invalid request")
+
+// ErrPolicyViolation is returned for requests the worker operator's model policy refuses
+var ErrPolicyViolation = errors.New("request refused by worker policy")
+
+// ErrWorkerUnavailable is returned when a worker is paused and does not accept new requests
+var ErrWorkerUnavailable = errors.New("worker is not accepting requests")
+
+// ErrAccessDenied is returned when a worker only serves certain consumers and the requesting peer is not one
+var ErrAccessDenied = errors.New("access denied")
+
+// ErrRateLimited is returned when a peer sends a worker more requests than it accepts from a single peer
+var ErrRateLimited = errors.New("too many requests from this peer")
+
+// InferenceError is a failed request as reported to the consumer. Code is an HTTP status code.
+type InferenceError struct {
+	Code    int
+	Message string
+}
+
+// Error returns the message
+func (e *InferenceError) Error() string {
+	return e.Message
+}
+
+// HTTPStatus returns Code if it is an HTTP error status, and 500 otherwise
+func (e *InferenceError) HTTPStatus() int {
+	if e.Code < 400 || e.Code > 599 {
+		return http.StatusInternalServerError
+	}
+	return e.Code
+}
+
+// NewInferenceError converts an error from an API handler to the error reported to the consumer
+func NewInferenceError(err error) *InferenceError {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrInvalidRequest), errors.Is(err, ErrPolicyViolation):
+		code = http.StatusBadRequest
+	case errors.Is(err, ErrModelNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, ErrWorkerUnavailable):
+		code = http.StatusServiceUnavailable
+	case errors.Is(err, ErrAccessDenied):
+		code = http.StatusForbidden
+	case errors.Is(err, ErrRateLimited):
+		code = http.StatusTooManyRequests
+	}
+	return &InferenceError{Code: code, Message: err.Error()}
+}
+
+// httpStatusError is returned for non-2xx responses from a backend
+type httpStatusError struct {
+	url    string
+	status string
+	code   int
+	body   []byte
+}
+
+// Error includes the start of the response body, which usually explains the failure
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("%s returned %s: %s", e.url, e.status, e.body)
+}
+
+// modelError marks a 404 response to a chat request as ErrModelNotFound
+func modelError(model string, err error) error {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) && statusErr.code == http.StatusNotFound {
+		return fmt.Errorf("%w: %s: %w", ErrModelNotFound, model, err)
+	}
+	return err
+}
+
+// sendRequest sends an HTTP request with body, if not nil, encoded as JSON. Non-2xx responses are returned as
+// *httpStatusError; otherwise the caller must close the response body.
+func sendRequest(ctx context.Context, client *http.Client, method, url, apiKey string, body any) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make HTTP request: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer closeBody(ctx, resp)
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		return nil, &httpStatusError{url: url, status: resp.Status, code: resp.StatusCode, body: bytes.TrimSpace(msg)}
+	}
+	return resp, nil
+}
+
+// doJSON sends an HTTP request with body, if not nil, encoded as JSON and decodes the JSON response into out
+func doJSON(ctx context.Context, client *http.Client, method, url, apiKey string, body, out any) error {
+	resp, err := sendRequest(ctx, client, method, url, apiKey, body)
+	if err != nil {
+		return err
+	}
+	defer closeBody(ctx, resp)
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// closeBody closes a response body, logging failures
+func closeBody(ctx context.Context, resp *http.Response) {
+	if err := resp.Body.Close(); err != nil {
+		// Log the error but don't fail the request
+		if logger := getLoggerFromContext(ctx); logger != nil {
+			logger.Warn("Failed to close response body", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package crowdllama
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// EchoBackend is a deterministic backend that answers with the content of the last user message. It needs
+// no inference server, which makes it useful for tests, demos and checking network connectivity.
+type EchoBackend struct{}
+
+// NewEchoBackend creates an echo backend
+func NewEchoBackend() *EchoBackend {
+	return &EchoBackend{}
+}
+
+// Name returns BackendEcho
+func (b *EchoBackend) Name() string {
+	return BackendEcho
+}
+
+// Chat answers with the last user message. Token counts are the number of words, and a positive num_predict
+// option truncates the answer to that many words.
+func (b *EchoBackend) Chat(_ context.Context, req *ChatRequest) (*ChatResponse, error) {
+	var content string
+	promptTokens := 0
+	for _, msg := range req.Messages {
+		promptTokens += len(strings.Fields(msg.Content))
+		if msg.Role == "user" {
+			content = msg.Content
+		}
+	}
+
+	doneReason := "stop"
+	words := strings.Fields(content)
+	if limit, ok := IntOption(req.Options, "num_predict"); ok && limit > 0 && limit < len(words) {
+		words = words[:limit]
+		content = strings.Join(words, " ")
+		doneReason = "length"
+	}
+
+	return &ChatResponse{
+		Model:           req.Model,
+		CreatedAt:       time.Now(),
+		Content:         content,
+		DoneReason:      doneReason,
+		PromptEvalCount: int32(promptTokens),
+		EvalCount:       int32(len(words)),
+	}, nil
+}
+
+// ListModels returns nil: the echo backend answers for any model
+func (b *EchoBackend) ListModels(context.Context) ([]string, error) {
+	return nil, nil
+}
@@ -0,0 +1,136 @@
+package crowdllama
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// MultiBackend routes each request to the backend that serves the requested model. Model ownership is learned
+// from ListModels; when several backends list a model, the first one configured wins. Requests for models no
+// backend listed go to the first backend, which keeps a single backend setup working for any model.
+type MultiBackend struct {
+	backends []Backend
+
+	mu     sync.RWMutex
+	models [][]string         // last successful model list of each backend
+	owners map[string]Backend // model name to the backend serving it
+}
+
+// NewMultiBackend creates a backend routing between the given backends, of which there must be at least one
+func NewMultiBackend(backends ...Backend) (*MultiBackend, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("at least one backend is required")
+	}
+	return &MultiBackend{
+		backends: backends,
+		models:   make([][]string, len(backends)),
+		owners:   make(map[string]Backend),
+	}, nil
+}
+
+// Name returns the names of the backends joined by "+"
+func (m *MultiBackend) Name() string {
+	names := make([]string, 0, len(m.backends))
+	for _, backend := range m.backends {
+		names = append(names, backend.Name())
+	}
+	return strings.Join(names, "+")
+}
+
+// Chat sends the request to the backend serving the requested model
+func (m *MultiBackend) Chat(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	backend := m.BackendFor(req.Model)
+	resp, err := backend.Chat(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", backend.Name(), err)
+	}
+	return resp, nil
+}
+
+// ListModels queries every backend and returns the union of their models. A backend that cannot be reached
+// keeps the models it listed last, so a short outage does not withdraw them; its error is returned along with
+// the models.
+func (m *MultiBackend) ListModels(ctx context.Context) ([]string, error) {
+	var errs []error
+	lists := make([][]string, len(m.backends))
+	failed := make([]bool, len(m.backends))
+	for i, backend := range m.backends {
+		models, err := backend.ListModels(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("list %s models: %w", backend.Name(), err))
+			failed[i] = true
+		}
+		lists[i] = models
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var all []string
+	owners := make(map[string]Backend)
+	for i, backend := range m.backends {
+		if !failed[i] {
+			m.models[i] = lists[i]
+		}
+		for _, model := range m.models[i] {
+			if _, ok := owners[model]; !ok {
+				owners[model] = backend
+				all = append(all, model)
+			}
+		}
+	}
+	m.owners = owners
+	return all, errors.Join(errs...)
+}
+
+// ModelSizes returns the sizes reported by the backends that know them. A model listed by several backends
+// gets the size reported by its owner.
+func (m *MultiBackend) ModelSizes(ctx context.Context) (map[string]int64, error) {
+	var errs []error
+	sizes := make(map[string]int64)
+	for _, backend := range m.backends {
+		sizer, ok := backend.(ModelSizer)
+		if !ok {
+			continue
+		}
+		backendSizes, err := sizer.ModelSizes(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s model sizes: %w", backend.Name(), err))
+			continue
+		}
+		for model, size := range backendSizes {
+			if m.BackendFor(model) == backend {
+				sizes[model] = size
+			}
+		}
+	}
+	return sizes, errors.Join(errs...)
+}
+
+// BackendFor returns the backend serving model
+func (m *MultiBackend) BackendFor(model string) Backend {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if backend, ok := m.owners[model]; ok {
+		return backend
+	}
+	return m.backends[0]
+}
+
+// Backends returns the backends in configuration order
+func (m *MultiBackend) Backends() []Backend {
+	return slices.Clone(m.backends)
+}
+
+// ParseBackendEndpoint parses a backend endpoint given as <type>=<url>, e.g. openai=http://localhost:8000/v1
+func ParseBackendEndpoint(spec string) (BackendConfig, error) {
+	typ, url, ok := strings.Cut(spec, "=")
+	if !ok || typ == "" {
+		return BackendConfig{}, fmt.Errorf("invalid backend endpoint %q, expected <type>=<url>", spec)
+	}
+	return BackendConfig{Type: typ, URL: url}, nil
+}
@@ -0,0 +1,183 @@
+package crowdllama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultOllamaURL is the address Ollama listens on by default
+const DefaultOllamaURL = "http://localhost:11434"
+
+// OllamaBackend runs inference through Ollama's /api/chat endpoint
+type OllamaBackend struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewOllamaBackend creates a backend for the Ollama server at baseURL, or at DefaultOllamaURL if it is empty
+func NewOllamaBackend(baseURL string) *OllamaBackend {
+	if baseURL == "" {
+		baseURL = DefaultOllamaURL
+	}
+	return &OllamaBackend{baseURL: strings.TrimSuffix(baseURL, "/"), client: http.DefaultClient}
+}
+
+// Name returns BackendOllama
+func (b *OllamaBackend) Name() string {
+	return BackendOllama
+}
+
+// Chat sends the conversation to Ollama
+func (b *OllamaBackend) Chat(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	// The inference protocol returns a single response, so Ollama is never asked to stream
+	ollamaReq := OllamaRequest{
+		Model:    req.Model,
+		Messages: ollamaMessages(req.Messages),
+		Stream:   false,
+		Tools:    req.Tools,
+		Options:  req.Options,
+	}
+
+	var ollamaResp OllamaResponse
+	if err := doJSON(ctx, b.client, http.MethodPost, b.baseURL+"/api/chat", "", ollamaReq, &ollamaResp); err != nil {
+		return nil, modelError(req.Model, err)
+	}
+
+	return &ChatResponse{
+		Model:              ollamaResp.Model,
+		CreatedAt:          ollamaResp.CreatedAt,
+		Content:            ollamaResp.Message.Content,
+		ToolCalls:          ollamaResp.Message.ToolCalls,
+		DoneReason:         ollamaResp.DoneReason,
+		TotalDuration:      ollamaResp.TotalDuration,
+		LoadDuration:       ollamaResp.LoadDuration,
+		PromptEvalCount:    ollamaResp.PromptEvalCount,
+		PromptEvalDuration: ollamaResp.PromptEvalDuration,
+		EvalCount:          ollamaResp.EvalCount,
+		EvalDuration:       ollamaResp.EvalDuration,
+	}, nil
+}
+
+// ListModels returns the models pulled into Ollama. Models tagged latest are also listed without the tag,
+// since Ollama accepts both names.
+func (b *OllamaBackend) ListModels(ctx context.Context) ([]string, error) {
+	tags, err := b.tags(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]string, 0, len(tags))
+	for _, model := range tags {
+		models = append(models, model.Name)
+		if name, ok := strings.CutSuffix(model.Name, ":latest"); ok {
+			models = append(models, name)
+		}
+	}
+	return models, nil
+}
+
+// ModelDiskUsage returns the total size of the models stored by Ollama, in bytes
+func (b *OllamaBackend) ModelDiskUsage(ctx context.Context) (int64, error) {
+	tags, err := b.tags(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, model := range tags {
+		total += model.Size
+	}
+	return total, nil
+}
+
+// ModelSizes returns the size in bytes of every model stored by Ollama, under the same names as ListModels
+func (b *OllamaBackend) ModelSizes(ctx context.Context) (map[string]int64, error) {
+	tags, err := b.tags(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[string]int64, len(tags))
+	for _, model := range tags {
+		sizes[model.Name] = model.Size
+		if name, ok := strings.CutSuffix(model.Name, ":latest"); ok {
+			sizes[name] = model.Size
+		}
+	}
+	return sizes, nil
+}
+
+// PullProgress is a progress update of a model pull. Ollama downloads a model layer by layer: Total and
+// Completed are the size and downloaded bytes of the layer named by Digest.
+type PullProgress struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest,omitempty"`
+	Total     int64  `json:"total,omitempty"`
+	Completed int64  `json:"completed,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// PullModel downloads a model into Ollama through /api/pull, calling progress with every update
+func (b *OllamaBackend) PullModel(ctx context.Context, model string, progress func(PullProgress)) error {
+	body := map[string]any{"model": model, "stream": true}
+	resp, err := sendRequest(ctx, b.client, http.MethodPost, b.baseURL+"/api/pull", "", body)
+	if err != nil {
+		return err
+	}
+	defer closeBody(ctx, resp)
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var update PullProgress
+		if err := decoder.Decode(&update); err != nil {
+			if errors.Is(err, io.EOF) {
+				return errors.New("pull ended before the model was complete")
+			}
+			return fmt.Errorf("failed to decode pull progress: %w", err)
+		}
+		if update.Error != "" {
+			return fmt.Errorf("pull %s: %s", model, update.Error)
+		}
+		progress(update)
+		if update.Status == "success" {
+			return nil
+		}
+	}
+}
+
+// ollamaModel is a model stored by Ollama as listed by /api/tags
+type ollamaModel struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// tags returns the models stored by Ollama
+func (b *OllamaBackend) tags(ctx context.Context) ([]ollamaModel, error) {
+	var tags struct {
+		Models []ollamaModel `json:"models"`
+	}
+	if err := doJSON(ctx, b.client, http.MethodGet, b.baseURL+"/api/tags", "", nil, &tags); err != nil {
+		return nil, err
+	}
+	return tags.Models, nil
+}
+
+// ollamaMessages converts a conversation to Ollama format
+func ollamaMessages(history []ChatMessage) []Message {
+	messages := make([]Message, 0, len(history))
+	for _, msg := range history {
+		messages = append(messages, Message{
+			Role:      msg.Role,
+			Content:   msg.Content,
+			Images:    encodeImages(msg.Images),
+			ToolCalls: msg.ToolCalls,
+			ToolName:  msg.ToolName,
+		})
+	}
+	return messages
+}
@@ -0,0 +1,225 @@
+package crowdllama
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenAIBackend runs inference through an OpenAI compatible /v1/chat/completions endpoint, as served by vLLM,
+// llama.cpp server, LM Studio and others
+type OpenAIBackend struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+// openAIRequest is the request body of /v1/chat/completions
+type openAIRequest struct {
+	Model       string          `json:"model"`
+	Messages    []openAIMessage `json:"messages"`
+	Stream      bool            `json:"stream"`
+	Tools       json.RawMessage `json:"tools,omitempty"`
+	MaxTokens   int             `json:"max_tokens,omitempty"`
+	Temperature any             `json:"temperature,omitempty"`
+	TopP        any             `json:"top_p,omitempty"`
+	Seed        any             `json:"seed,omitempty"`
+	Stop        any             `json:"stop,omitempty"`
+}
+
+// openAIMessage is a conversation message in OpenAI format. Content is a string, or an array of parts when
+// the message carries images.
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    any              `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+// openAIContentPart is a text or image part of a message
+type openAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+// openAIImageURL references an image, which this backend always sends inline as a data URL
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+// openAIToolCall is a tool call whose arguments are a JSON encoded string
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// openAIResponse is the response body of /v1/chat/completions
+type openAIResponse struct {
+	Model   string `json:"model"`
+	Created int64  `json:"created"`
+	Choices []struct {
+		Message struct {
+			Content   *string          `json:"content"`
+			ToolCalls []openAIToolCall `json:"tool_calls"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int32 `json:"prompt_tokens"`
+		CompletionTokens int32 `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+// NewOpenAIBackend creates a backend for the OpenAI compatible server at baseURL. The URL may include the
+// /v1 prefix or not; apiKey is sent as a bearer token when set.
+func NewOpenAIBackend(baseURL, apiKey string) *OpenAIBackend {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	baseURL = strings.TrimSuffix(baseURL, "/v1")
+	return &OpenAIBackend{baseURL: baseURL, apiKey: apiKey, client: http.DefaultClient}
+}
+
+// Name returns BackendOpenAI
+func (b *OpenAIBackend) Name() string {
+	return BackendOpenAI
+}
+
+// Chat sends the conversation to the server's chat completions endpoint
+func (b *OpenAIBackend) Chat(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	// Ollama options without an OpenAI equivalent, such as num_ctx, are dropped
+	openAIReq := openAIRequest{
+		Model:       req.Model,
+		Messages:    openAIMessages(req.Messages),
+		Stream:      false,
+		Tools:       req.Tools,
+		Temperature: req.Options["temperature"],
+		TopP:        req.Options["top_p"],
+		Seed:        req.Options["seed"],
+		Stop:        req.Options["stop"],
+	}
+	// Ollama uses negative num_predict values for "no limit", which OpenAI servers refuse
+	if maxTokens, ok := IntOption(req.Options, "num_predict"); ok && maxTokens > 0 {
+		openAIReq.MaxTokens = maxTokens
+	}
+
+	var openAIResp openAIResponse
+	if err := doJSON(ctx, b.client, http.MethodPost, b.baseURL+"/v1/chat/completions", b.apiKey, openAIReq, &openAIResp); err != nil {
+		return nil, modelError(req.Model, err)
+	}
+	if len(openAIResp.Choices) == 0 {
+		return nil, errors.New("response has no choices")
+	}
+
+	choice := openAIResp.Choices[0]
+	resp := &ChatResponse{
+		Model:           openAIResp.Model,
+		CreatedAt:       time.Unix(openAIResp.Created, 0),
+		DoneReason:      "stop",
+		PromptEvalCount: openAIResp.Usage.PromptTokens,
+		EvalCount:       openAIResp.Usage.CompletionTokens,
+	}
+	if openAIResp.Created == 0 {
+		resp.CreatedAt = time.Now()
+	}
+	if choice.FinishReason == "length" {
+		resp.DoneReason = "length"
+	}
+	if choice.Message.Content != nil {
+		resp.Content = *choice.Message.Content
+	}
+	for _, call := range choice.Message.ToolCalls {
+		resp.ToolCalls = append(resp.ToolCalls, ToolCall{Function: ToolCallFunction{
+			Name:      call.Function.Name,
+			Arguments: toolArguments(call.Function.Arguments),
+		}})
+	}
+	return resp, nil
+}
+
+// ListModels returns the models listed by the server's /v1/models endpoint
+func (b *OpenAIBackend) ListModels(ctx context.Context) ([]string, error) {
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := doJSON(ctx, b.client, http.MethodGet, b.baseURL+"/v1/models", b.apiKey, nil, &list); err != nil {
+		return nil, err
+	}
+
+	models := make([]string, 0, len(list.Data))
+	for _, model := range list.Data {
+		models = append(models, model.ID)
+	}
+	return models, nil
+}
+
+// openAIMessages converts a conversation to OpenAI format. OpenAI links tool results to tool calls by ID
+// rather than by name, so calls get sequential IDs and each tool result refers to the latest call of its tool.
+func openAIMessages(history []ChatMessage) []openAIMessage {
+	messages := make([]openAIMessage, 0, len(history))
+	callIDs := make(map[string]string)
+	nextCall := 0
+	for _, msg := range history {
+		out := openAIMessage{Role: msg.Role, Content: openAIContent(msg)}
+		for _, call := range msg.ToolCalls {
+			var tc openAIToolCall
+			tc.ID = fmt.Sprintf("call_%d", nextCall)
+			tc.Type = "function"
+			tc.Function.Name = call.Function.Name
+			tc.Function.Arguments = string(call.Function.Arguments)
+			if tc.Function.Arguments == "" {
+				tc.Function.Arguments = "{}"
+			}
+			nextCall++
+			callIDs[call.Function.Name] = tc.ID
+			out.ToolCalls = append(out.ToolCalls, tc)
+		}
+		if msg.Role == "tool" {
+			out.ToolCallID = callIDs[msg.ToolName]
+		}
+		messages = append(messages, out)
+	}
+	return messages
+}
+
+// openAIContent returns the content of a message as a string, or as text and image parts if it has images
+func openAIContent(msg ChatMessage) any {
+	if len(msg.Images) == 0 {
+		return msg.Content
+	}
+	parts := make([]openAIContentPart, 0, len(msg.Images)+1)
+	if msg.Content != "" {
+		parts = append(parts, openAIContentPart{Type: "text", Text: msg.Content})
+	}
+	for _, image := range msg.Images {
+		url := "data:" + http.DetectContentType(image) + ";base64," + base64.StdEncoding.EncodeToString(image)
+		parts = append(parts, openAIContentPart{Type: "image_url", ImageURL: &openAIImageURL{URL: url}})
+	}
+	return parts
+}
+
+// toolArguments converts OpenAI's JSON encoded argument string to a JSON object. Arguments that are not
+// valid JSON are kept as a JSON string so no information is lost.
+func toolArguments(args string) json.RawMessage {
+	if args == "" {
+		return json.RawMessage("{}")
+	}
+	if json.Valid([]byte(args)) {
+		return json.RawMessage(args)
+	}
+	encoded, err := json.Marshal(args)
+	if err != nil {
+		return json.RawMessage("{}")
+	}
+	return encoded
+}
@@ -0,0 +1,196 @@
+package crowdllama
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"path"
+	"sync"
+)
+
+// ModelPolicy is a worker operator's limits on the models the worker serves and the requests it accepts
+type ModelPolicy struct {
+	Allow        []string // model name patterns in path.Match syntax, e.g. "llama3*"; empty allows every model
+	Deny         []string // model name patterns refused even if allowed
+	MaxModelSize int64    // largest model in bytes, for backends that report model sizes; 0 means no limit
+	MaxContext   int      // largest num_ctx option; 0 means no limit
+	MaxPredict   int      // largest num_predict option, also applied to requests that set none; 0 means no limit
+}
+
+// IsZero returns true if the policy does not restrict anything
+func (p *ModelPolicy) IsZero() bool {
+	return len(p.Allow) == 0 && len(p.Deny) == 0 && p.MaxModelSize == 0 && p.MaxContext == 0 && p.MaxPredict == 0
+}
+
+// AllowsModel returns true if the model's name matches the allowlist, if any, and not the denylist
+func (p *ModelPolicy) AllowsModel(model string) bool {
+	if matchesAny(p.Deny, model) {
+		return false
+	}
+	return len(p.Allow) == 0 || matchesAny(p.Allow, model)
+}
+
+// AllowsSize returns true if a model of the given size in bytes is within the size limit
+func (p *ModelPolicy) AllowsSize(size int64) bool {
+	return p.MaxModelSize <= 0 || size <= p.MaxModelSize
+}
+
+// matchesAny returns true if name matches one of the patterns. A model name without a tag also matches
+// patterns written for its latest tag, and the other way round.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok || pattern == name {
+			return true
+		}
+		if ok, _ := path.Match(pattern, name+":latest"); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ModelSizer is implemented by backends that know the size of the models they store
+type ModelSizer interface {
+	// ModelSizes returns the size in bytes of every stored model
+	ModelSizes(ctx context.Context) (map[string]int64, error)
+}
+
+// PolicyBackend enforces a ModelPolicy in front of another backend. Refused requests fail with
+// ErrPolicyViolation, and models the policy refuses are left out of ListModels so they are not advertised.
+type PolicyBackend struct {
+	backend Backend
+	policy  ModelPolicy
+
+	mu    sync.RWMutex
+	sizes map[string]int64 // model sizes as of the last ListModels
+}
+
+// NewPolicyBackend wraps backend with policy
+func NewPolicyBackend(backend Backend, policy ModelPolicy) *PolicyBackend {
+	return &PolicyBackend{backend: backend, policy: policy}
+}
+
+// Name returns the name of the wrapped backend
+func (b *PolicyBackend) Name() string {
+	return b.backend.Name()
+}
+
+// Unwrap returns the wrapped backend
+func (b *PolicyBackend) Unwrap() Backend {
+	return b.backend
+}
+
+// Chat checks the request against the policy, capping num_predict if the request leaves it open, and passes
+// it on to the wrapped backend
+func (b *PolicyBackend) Chat(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	if !b.policy.AllowsModel(req.Model) {
+		return nil, fmt.Errorf("%w: model %s is not served by this worker", ErrPolicyViolation, req.Model)
+	}
+	if size, ok := b.modelSize(req.Model); ok && !b.policy.AllowsSize(size) {
+		return nil, fmt.Errorf("%w: model %s is %d bytes, the limit is %d", ErrPolicyViolation, req.Model, size, b.policy.MaxModelSize)
+	}
+
+	options, err := b.limitOptions(req.Options)
+	if err != nil {
+		return nil, err
+	}
+	limited := *req
+	limited.Options = options
+
+	resp, err := b.backend.Chat(ctx, &limited)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.backend.Name(), err)
+	}
+	return resp, nil
+}
+
+// ListModels returns the models of the wrapped backend the policy allows
+func (b *PolicyBackend) ListModels(ctx context.Context) ([]string, error) {
+	models, err := b.backend.ListModels(ctx)
+	if sizer, ok := b.backend.(ModelSizer); ok && b.policy.MaxModelSize > 0 {
+		if sizes, sizeErr := sizer.ModelSizes(ctx); sizeErr == nil {
+			b.mu.Lock()
+			b.sizes = sizes
+			b.mu.Unlock()
+		}
+	}
+
+	allowed := make([]string, 0, len(models))
+	for _, model := range models {
+		if !b.policy.AllowsModel(model) {
+			continue
+		}
+		if size, ok := b.modelSize(model); ok && !b.policy.AllowsSize(size) {
+			continue
+		}
+		allowed = append(allowed, model)
+	}
+	if err != nil {
+		return allowed, fmt.Errorf("%s: %w", b.backend.Name(), err)
+	}
+	return allowed, nil
+}
+
+// modelSize returns the size of a model as of the last ListModels, if known. Like matchesAny it treats a
+// name without a tag as the latest tag, which is how Ollama lists it.
+func (b *PolicyBackend) modelSize(model string) (int64, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if size, ok := b.sizes[model]; ok {
+		return size, true
+	}
+	size, ok := b.sizes[model+":latest"]
+	return size, ok
+}
+
+// limitOptions checks num_ctx and num_predict against the policy and returns the options to send, with
+// num_predict set to the limit when the request leaves generation unbounded
+func (b *PolicyBackend) limitOptions(options map[string]any) (map[string]any, error) {
+	if _, set := options["num_ctx"]; set && b.policy.MaxContext > 0 {
+		// A value that cannot be checked, e.g. a fraction, cannot be let through either
+		numCtx, ok := IntOption(options, "num_ctx")
+		if !ok {
+			return nil, fmt.Errorf("%w: num_ctx %v is not a whole number", ErrPolicyViolation, options["num_ctx"])
+		}
+		if numCtx > b.policy.MaxContext {
+			return nil, fmt.Errorf("%w: num_ctx %d exceeds the limit of %d", ErrPolicyViolation, numCtx, b.policy.MaxContext)
+		}
+	}
+	if b.policy.MaxPredict <= 0 {
+		return options, nil
+	}
+
+	numPredict, ok := IntOption(options, "num_predict")
+	if ok && numPredict > b.policy.MaxPredict {
+		return nil, fmt.Errorf("%w: num_predict %d exceeds the limit of %d", ErrPolicyViolation, numPredict, b.policy.MaxPredict)
+	}
+	if ok && numPredict > 0 {
+		return options, nil
+	}
+
+	// Ollama treats a missing or negative num_predict as unbounded
+	limited := maps.Clone(options)
+	if limited == nil {
+		limited = make(map[string]any)
+	}
+	limited["num_predict"] = b.policy.MaxPredict
+	return limited, nil
+}
+
+// IntOption returns a whole number option, as decoded from JSON or set in code
+func IntOption(options map[string]any, name string) (int, bool) {
+	switch v := options[name].(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) > math.MaxInt32 {
+			return 0, false
+		}
+		return int(v), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,349 @@
+package crowdllama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewBackend(t *testing.T) {
+	tests := []struct {
+		cfg      BackendConfig
+		wantName string
+		wantErr  bool
+	}{
+		{cfg: BackendConfig{}, wantName: BackendOllama},
+		{cfg: BackendConfig{Type: BackendOllama, URL: "http://ollama:11434"}, wantName: BackendOllama},
+		{cfg: BackendConfig{Type: BackendOpenAI, URL: "http://vllm:8000/v1"}, wantName: BackendOpenAI},
+		{cfg: BackendConfig{Type: BackendOpenAI}, wantErr: true},
+		{cfg: BackendConfig{Type: BackendEcho}, wantName: BackendEcho},
+		{cfg: BackendConfig{Type: "tgi"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		backend, err := NewBackend(tt.cfg)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Expected an error for %+v", tt.cfg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %+v: %v", tt.cfg, err)
+			continue
+		}
+		if backend.Name() != tt.wantName {
+			t.Errorf("Expected backend %s for %+v, got %s", tt.wantName, tt.cfg, backend.Name())
+		}
+	}
+}
+
+func TestEchoBackend(t *testing.T) {
+	req := CreateGenerateRequest("any-model", "hello there", false)
+	resp, err := BackendAPIHandler(NewEchoBackend())(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	generateResp := resp.GetGenerateResponse()
+	if generateResp.GetResponse() != "hello there" {
+		t.Errorf("Expected the prompt to be echoed, got %q", generateResp.GetResponse())
+	}
+	if generateResp.GetModel() != "any-model" || !generateResp.GetDone() {
+		t.Errorf("Unexpected response: %+v", generateResp)
+	}
+	if generateResp.GetPromptEvalCount() != 2 || generateResp.GetEvalCount() != 2 {
+		t.Errorf("Expected word counts 2/2, got %d/%d", generateResp.GetPromptEvalCount(), generateResp.GetEvalCount())
+	}
+}
+
+func TestOpenAIBackend(t *testing.T) {
+	var received openAIRequest
+	var authorization, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"model":"Qwen/Qwen2.5-7B","created":1700000000,"choices":[{"message":{"role":"assistant",
+			"content":null,"tool_calls":[{"id":"x","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},
+			"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":20,"completion_tokens":9}}`))
+	}))
+	defer server.Close()
+
+	backend := NewOpenAIBackend(server.URL+"/v1/", "secret")
+	resp, err := backend.Chat(context.Background(), &ChatRequest{
+		Model: "Qwen/Qwen2.5-7B",
+		Messages: []ChatMessage{
+			{Role: "user", Content: "Weather in Paris?", Images: [][]byte{[]byte("\x89PNG\r\n\x1a\n")}},
+			{Role: "assistant", ToolCalls: []ToolCall{{Function: ToolCallFunction{Name: "get_weather", Arguments: json.RawMessage(`{}`)}}}},
+			{Role: "tool", Content: "sunny", ToolName: "get_weather"},
+		},
+		Tools: json.RawMessage(`[{"type":"function","function":{"name":"get_weather"}}]`),
+	})
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+
+	if path != "/v1/chat/completions" {
+		t.Errorf("Expected a request to /v1/chat/completions, got %s", path)
+	}
+	if authorization != "Bearer secret" {
+		t.Errorf("Expected the API key as bearer token, got %q", authorization)
+	}
+	if len(received.Messages) != 3 || received.Stream || len(received.Tools) == 0 {
+		t.Fatalf("Unexpected request: %+v", received)
+	}
+	if parts, ok := received.Messages[0].Content.([]any); !ok || len(parts) != 2 {
+		t.Errorf("Expected the image message to be sent as content parts, got %#v", received.Messages[0].Content)
+	}
+	callID := received.Messages[1].ToolCalls[0].ID
+	if callID == "" || received.Messages[2].ToolCallID != callID {
+		t.Errorf("Expected the tool result to refer to call %q, got %q", callID, received.Messages[2].ToolCallID)
+	}
+
+	if resp.PromptEvalCount != 20 || resp.EvalCount != 9 || resp.DoneReason != "stop" {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+	if len(resp.ToolCalls) != 1 || string(resp.ToolCalls[0].Function.Arguments) != `{"city":"Paris"}` {
+		t.Errorf("Expected tool call arguments as a JSON object, got %+v", resp.ToolCalls)
+	}
+}
+
+func TestOpenAIBackendMaxTokens(t *testing.T) {
+	var received map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = nil
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`))
+	}))
+	defer server.Close()
+
+	backend := NewOpenAIBackend(server.URL, "")
+	for numPredict, expected := range map[float64]any{-1: nil, -2: nil, 0: nil, 128: float64(128)} {
+		req := &ChatRequest{Model: "llama3.2", Options: map[string]any{"num_predict": numPredict}}
+		if _, err := backend.Chat(context.Background(), req); err != nil {
+			t.Fatalf("Chat failed: %v", err)
+		}
+		if received["max_tokens"] != expected {
+			t.Errorf("Expected max_tokens %v for num_predict %v, got %v", expected, numPredict, received["max_tokens"])
+		}
+	}
+}
+
+func TestOpenAIBackendError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, `{"error":{"message":"model not found"}}`, http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	_, err := NewOpenAIBackend(server.URL, "").Chat(context.Background(), &ChatRequest{Model: "missing"})
+	if err == nil {
+		t.Fatal("Expected an error for a 404 response")
+	}
+}
+
+// staticBackend is a backend serving a fixed list of models, answering with its own name
+type staticBackend struct {
+	name   string
+	models []string
+	sizes  map[string]int64
+	err    error
+	last   *ChatRequest // the last request passed to Chat
+}
+
+func (b *staticBackend) Name() string { return b.name }
+
+func (b *staticBackend) Chat(_ context.Context, req *ChatRequest) (*ChatResponse, error) {
+	b.last = req
+	return &ChatResponse{Model: req.Model, Content: b.name}, nil
+}
+
+func (b *staticBackend) ModelSizes(context.Context) (map[string]int64, error) {
+	return b.sizes, nil
+}
+
+func (b *staticBackend) ListModels(context.Context) ([]string, error) {
+	return b.models, b.err
+}
+
+func TestMultiBackend(t *testing.T) {
+	ollama := &staticBackend{name: "ollama", models: []string{"llama3.2", "shared"}}
+	vllm := &staticBackend{name: "vllm", models: []string{"Qwen/Qwen2.5-72B", "shared"}}
+	multi, err := NewMultiBackend(ollama, vllm)
+	if err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+
+	models, err := multi.ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to list models: %v", err)
+	}
+	if len(models) != 3 {
+		t.Errorf("Expected the union of both model lists, got %v", models)
+	}
+
+	for model, want := range map[string]string{
+		"llama3.2":         "ollama",
+		"Qwen/Qwen2.5-72B": "vllm",
+		"shared":           "ollama",
+		"unknown":          "ollama",
+	} {
+		resp, err := multi.Chat(context.Background(), &ChatRequest{Model: model})
+		if err != nil {
+			t.Fatalf("Chat failed for %s: %v", model, err)
+		}
+		if resp.Content != want {
+			t.Errorf("Expected %s to be served by %s, got %s", model, want, resp.Content)
+		}
+	}
+
+	// A backend that cannot be reached keeps the models it listed last
+	vllm.models, vllm.err = nil, errors.New("connection refused")
+	models, err = multi.ListModels(context.Background())
+	if err == nil {
+		t.Error("Expected the listing error to be returned")
+	}
+	if len(models) != 3 || multi.BackendFor("Qwen/Qwen2.5-72B") != vllm {
+		t.Errorf("Expected vllm to keep its models, got %v", models)
+	}
+}
+
+func TestParseBackendEndpoint(t *testing.T) {
+	cfg, err := ParseBackendEndpoint("openai=http://gpu-box:8000/v1")
+	if err != nil {
+		t.Fatalf("Failed to parse endpoint: %v", err)
+	}
+	if cfg.Type != BackendOpenAI || cfg.URL != "http://gpu-box:8000/v1" {
+		t.Errorf("Unexpected endpoint: %+v", cfg)
+	}
+
+	for _, spec := range []string{"http://gpu-box:8000", "=http://gpu-box:8000"} {
+		if _, err := ParseBackendEndpoint(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestOllamaBackendPullModel(t *testing.T) {
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/pull":
+			_, _ = w.Write([]byte(`{"status":"pulling manifest"}
+{"status":"pulling abc","digest":"sha256:abc","total":100,"completed":40}
+{"status":"pulling abc","digest":"sha256:abc","total":100,"completed":100}
+{"status":"success"}
+`))
+		case "/api/tags":
+			_, _ = w.Write([]byte(`{"models":[{"name":"llama3.2:latest","size":2000},{"name":"qwen2.5:7b","size":4000}]}`))
+		case "/api/chat":
+			http.Error(w, `{"error":"model \"mistral\" not found, try pulling it first"}`, http.StatusNotFound)
+		}
+	}))
+	defer ollama.Close()
+	backend := NewOllamaBackend(ollama.URL)
+
+	var updates []PullProgress
+	if err := backend.PullModel(context.Background(), "llama3.2", func(p PullProgress) { updates = append(updates, p) }); err != nil {
+		t.Fatalf("Pull failed: %v", err)
+	}
+	if len(updates) != 4 || updates[2].Completed != 100 {
+		t.Errorf("Unexpected progress updates: %+v", updates)
+	}
+
+	usage, err := backend.ModelDiskUsage(context.Background())
+	if err != nil || usage != 6000 {
+		t.Errorf("Expected a disk usage of 6000 bytes, got %d (%v)", usage, err)
+	}
+	models, err := backend.ListModels(context.Background())
+	if err != nil || len(models) != 3 {
+		t.Errorf("Expected llama3.2:latest, llama3.2 and qwen2.5:7b, got %v (%v)", models, err)
+	}
+
+	_, err = backend.Chat(context.Background(), &ChatRequest{Model: "mistral"})
+	if !errors.Is(err, ErrModelNotFound) {
+		t.Errorf("Expected ErrModelNotFound, got %v", err)
+	}
+}
+
+func TestPolicyBackend(t *testing.T) {
+	inner := &staticBackend{
+		name:   "ollama",
+		models: []string{"llama3.2:latest", "llama3.1:70b", "llama3:latest", "mistral", "phi3"},
+		sizes: map[string]int64{
+			"llama3.2:latest": 2 << 30, "llama3.1:70b": 40 << 30, "llama3:latest": 16 << 30, "mistral": 4 << 30, "phi3": 2 << 30,
+		},
+	}
+	backend := NewPolicyBackend(inner, ModelPolicy{
+		Allow:        []string{"llama3*", "mistral"},
+		Deny:         []string{"mistral"},
+		MaxModelSize: 8 << 30,
+		MaxContext:   8192,
+		MaxPredict:   512,
+	})
+
+	models, err := backend.ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to list models: %v", err)
+	}
+	if len(models) != 1 || models[0] != "llama3.2:latest" {
+		t.Errorf("Expected only llama3.2 to be advertised, got %v", models)
+	}
+
+	// llama3 is too large, whether or not the request names its tag
+	for _, model := range []string{"mistral", "phi3", "llama3.1:70b", "llama3", "llama3:latest"} {
+		if _, err := backend.Chat(context.Background(), &ChatRequest{Model: model}); !errors.Is(err, ErrPolicyViolation) {
+			t.Errorf("Expected %s to be refused, got %v", model, err)
+		}
+	}
+
+	// JSON decodes numbers as float64
+	_, err = backend.Chat(context.Background(), &ChatRequest{Model: "llama3.2", Options: map[string]any{"num_ctx": float64(32768)}})
+	if !errors.Is(err, ErrPolicyViolation) {
+		t.Errorf("Expected num_ctx above the limit to be refused, got %v", err)
+	}
+	for _, numCtx := range []any{999999.5, 1e10, "32768"} {
+		_, err = backend.Chat(context.Background(), &ChatRequest{Model: "llama3.2", Options: map[string]any{"num_ctx": numCtx}})
+		if !errors.Is(err, ErrPolicyViolation) {
+			t.Errorf("Expected num_ctx %v that is not a whole number to be refused, got %v", numCtx, err)
+		}
+	}
+	_, err = backend.Chat(context.Background(), &ChatRequest{Model: "llama3.2", Options: map[string]any{"num_predict": float64(4096)}})
+	if !errors.Is(err, ErrPolicyViolation) {
+		t.Errorf("Expected num_predict above the limit to be refused, got %v", err)
+	}
+
+	// Requests that leave generation unbounded are capped
+	options := map[string]any{"num_predict": float64(-1), "temperature": 0.2}
+	if _, err := backend.Chat(context.Background(), &ChatRequest{Model: "llama3.2", Options: options}); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if got, _ := IntOption(inner.last.Options, "num_predict"); got != 512 {
+		t.Errorf("Expected num_predict to be capped at 512, got %v", inner.last.Options["num_predict"])
+	}
+	if options["num_predict"] != float64(-1) {
+		t.Error("Expected the caller's options to be left unchanged")
+	}
+}
+
+func TestNewInferenceError(t *testing.T) {
+	for err, want := range map[error]int{
+		fmt.Errorf("%w: num_ctx too large", ErrPolicyViolation): http.StatusBadRequest,
+		fmt.Errorf("%w: bad options", ErrInvalidRequest):        http.StatusBadRequest,
+		fmt.Errorf("%w: mistral", ErrModelNotFound):             http.StatusNotFound,
+		errors.New("connection refused"):                        http.StatusInternalServerError,
+	} {
+		if got := NewInferenceError(err); got.Code != want || got.Message != err.Error() {
+			t.Errorf("NewInferenceError(%v) = %+v, want code %d", err, got, want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package crowdllama
+
+import (
+	"slices"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+const (
+	// ProtocolVersion is the CrowdLlama protocol version implemented by this build
+	ProtocolVersion = 1
+
+	// MinProtocolVersion is the oldest protocol version this build can talk to
+	MinProtocolVersion = 1
+)
+
+// Features a peer can advertise in its metadata. A gateway only routes a request to workers that
+// advertise every feature the request needs.
+const (
+	// FeatureChat means the worker answers chat requests with a single response
+	FeatureChat = "chat"
+
+	// FeatureStreaming means the worker can stream partial responses. No build advertises it yet: workers
+	// answer with complete responses, which the gateway sends as a single chunk to clients that stream.
+	FeatureStreaming = "streaming"
+
+	// FeatureEmbeddings means the worker can compute embeddings
+	FeatureEmbeddings = "embeddings"
+
+	// FeatureOptions means the worker honours per-request model options
+	FeatureOptions = "options"
+
+	// FeatureTools means the worker passes tool definitions and conversation history to the model and
+	// returns the tool calls it makes
+	FeatureTools = "tools"
+
+	// FeatureVision means the worker accepts image input for the models listed in Resource.VisionModels
+	FeatureVision = "vision"
+
+	// FeaturePull means the worker pulls models it is asked for on demand and accepts ModelHintProtocol hints
+	FeaturePull = "pull"
+)
+
+// legacyFeatures are assumed for peers that predate capability advertisement
+var legacyFeatures = []string{FeatureChat}
+
+// InferenceProtocols lists the supported inference protocol IDs, newest first. Opening a stream with
+// all of them lets libp2p negotiate the newest version both sides understand.
+func InferenceProtocols() []protocol.ID {
+	return []protocol.ID{CompressedInferenceProtocol, InferenceProtocol}
+}
+
+// InferenceSessionProtocols lists the supported inference session protocol IDs, newest first
+func InferenceSessionProtocols() []protocol.ID {
+	return []protocol.ID{CompressedInferenceSessionProtocol, InferenceSessionProtocol}
+}
+
+// SupportsCompression returns true if frames on the given protocol may be compressed
+func SupportsCompression(protocolID string) bool {
+	return protocolID == CompressedInferenceProtocol || protocolID == CompressedInferenceSessionProtocol
+}
+
+// SupportedProtocols returns the protocol IDs advertised in this build's metadata
+func SupportedProtocols() []string {
+	protocols := []string{MetadataProtocol}
+	for _, id := range InferenceSessionProtocols() {
+		protocols = append(protocols, string(id))
+	}
+	for _, id := range InferenceProtocols() {
+		protocols = append(protocols, string(id))
+	}
+	return protocols
+}
+
+// WorkerFeatures returns the features workers of this build support
+func WorkerFeatures() []string {
+	return []string{FeatureChat, FeatureOptions, FeatureTools}
+}
+
+// GetProtocolVersion returns the peer's protocol version, treating peers without one as version 1
+func (r *Resource) GetProtocolVersion() int {
+	if r.ProtocolVersion == 0 {
+		return 1
+	}
+	return r.ProtocolVersion
+}
+
+// GetFeatures returns the features the peer advertises, or the legacy feature set for older peers
+func (r *Resource) GetFeatures() []string {
+	if r.ProtocolVersion == 0 && len(r.Features) == 0 {
+		return legacyFeatures
+	}
+	return r.Features
+}
+
+// IsCompatible returns true if this build can talk to the peer
+func (r *Resource) IsCompatible() bool {
+	return r.GetProtocolVersion() >= MinProtocolVersion
+}
+
+// SupportsProtocol returns true if the peer advertises the given protocol ID
+func (r *Resource) SupportsProtocol(id string) bool {
+	return slices.Contains(r.Protocols, id)
+}
+
+// SupportsVision returns true if the peer accepts image input for the given model
+func (r *Resource) SupportsVision(model string) bool {
+	return slices.Contains(r.GetFeatures(), FeatureVision) && slices.Contains(r.VisionModels, model)
+}
+
+// SupportsModelFeatures returns true if the peer advertises all of the given features and, where a feature
+// is limited to some models, supports it for the given model
+func (r *Resource) SupportsModelFeatures(model string, features ...string) bool {
+	if !r.SupportsFeatures(features...) {
+		return false
+	}
+	return !slices.Contains(features, FeatureVision) || r.SupportsVision(model)
+}
+
+// SupportsFeatures returns true if the peer advertises all of the given features
+func (r *Resource) SupportsFeatures(features ...string) bool {
+	advertised := r.GetFeatures()
+	for _, feature := range features {
+		if !slices.Contains(advertised, feature) {
+			return false
+		}
+	}
+	return true
+}
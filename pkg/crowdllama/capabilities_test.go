@@ -0,0 +1,75 @@
+package crowdllama
+
+import (
+	"testing"
+)
+
+func TestSupportsFeatures(t *testing.T) {
+	worker := NewCrowdLlamaResource("worker")
+	worker.Features = []string{FeatureChat, FeatureStreaming}
+
+	if !worker.SupportsFeatures(FeatureChat, FeatureStreaming) {
+		t.Error("Expected worker to support chat and streaming")
+	}
+	if worker.SupportsFeatures(FeatureChat, FeatureEmbeddings) {
+		t.Error("Expected worker not to support embeddings")
+	}
+	if !worker.SupportsFeatures() {
+		t.Error("Expected a request without required features to be supported")
+	}
+}
+
+func TestSupportsVision(t *testing.T) {
+	worker := NewCrowdLlamaResource("worker")
+	worker.Features = []string{FeatureChat, FeatureVision}
+	worker.VisionModels = []string{"llava"}
+
+	if !worker.SupportsModelFeatures("llava", FeatureChat, FeatureVision) {
+		t.Error("Expected worker to accept images for llava")
+	}
+	if worker.SupportsModelFeatures("tinyllama", FeatureChat, FeatureVision) {
+		t.Error("Expected worker not to accept images for tinyllama")
+	}
+	if !worker.SupportsModelFeatures("tinyllama", FeatureChat) {
+		t.Error("Expected worker to serve text requests for tinyllama")
+	}
+}
+
+func TestLegacyPeerCapabilities(t *testing.T) {
+	// Metadata from a peer that predates capability advertisement
+	legacy, err := FromJSON([]byte(`{"peer_id":"legacy","supported_models":["tinyllama"],"worker_mode":true}`))
+	if err != nil {
+		t.Fatalf("Failed to parse legacy metadata: %v", err)
+	}
+
+	if legacy.GetProtocolVersion() != 1 {
+		t.Errorf("Expected legacy peer to be treated as protocol version 1, got %d", legacy.GetProtocolVersion())
+	}
+	if !legacy.IsCompatible() {
+		t.Error("Expected legacy peer to be compatible")
+	}
+	if !legacy.SupportsFeatures(FeatureChat) {
+		t.Error("Expected legacy peer to support chat")
+	}
+	if legacy.SupportsFeatures(FeatureStreaming) {
+		t.Error("Expected legacy peer not to support streaming")
+	}
+}
+
+func TestSupportedProtocols(t *testing.T) {
+	protocols := SupportedProtocols()
+	if len(protocols) < 2 || protocols[0] != MetadataProtocol {
+		t.Fatalf("Unexpected supported protocols: %v", protocols)
+	}
+	for _, id := range InferenceProtocols() {
+		found := false
+		for _, advertised := range protocols {
+			if advertised == string(id) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected inference protocol %s to be advertised", id)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package crowdllama
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+type (
+	workerIDKey struct{}
+	loggerKey   struct{}
+)
+
+// WithWorkerID returns a context carrying the peer ID of the worker serving a request
+func WithWorkerID(ctx context.Context, workerID string) context.Context {
+	return context.WithValue(ctx, workerIDKey{}, workerID)
+}
+
+// WorkerIDFromContext returns the peer ID of the worker serving a request, or an empty string if unknown
+func WorkerIDFromContext(ctx context.Context) string {
+	workerID, _ := ctx.Value(workerIDKey{}).(string)
+	return workerID
+}
+
+// WithLogger returns a context carrying the logger API handlers should use
+func WithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
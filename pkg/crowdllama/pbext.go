@@ -0,0 +1,322 @@
+package crowdllama
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	llamav1 "github.com/crowdllama/crowdllama-pb/llama/v1"
+)
+
+// Fields added on top of the published llama/v1 schema. They travel as unknown fields with field numbers the
+// schema does not use, so peers built against the plain schema ignore them and keep working. pbext.proto
+// describes them for adoption by crowdllama-pb, which can define them with the same numbers without changing
+// the wire format.
+const (
+	// requestImagesField carries one image per occurrence on a GenerateRequest
+	requestImagesField protowire.Number = 100
+
+	// requestToolsField carries the JSON tool definitions of a GenerateRequest
+	requestToolsField protowire.Number = 101
+
+	// requestMessagesField carries one conversation message per occurrence on a GenerateRequest
+	requestMessagesField protowire.Number = 102
+
+	// requestOptionsField carries the JSON model options of a GenerateRequest, in Ollama's format
+	requestOptionsField protowire.Number = 103
+
+	// responseToolCallsField carries the JSON tool calls of a GenerateResponse
+	responseToolCallsField protowire.Number = 100
+
+	// responseErrorField carries the error of a failed request, see InferenceError
+	responseErrorField protowire.Number = 101
+
+	// responseReceiptField carries the receipt the worker signed for the tokens of a GenerateResponse
+	responseReceiptField protowire.Number = 102
+)
+
+// Fields of an error in responseErrorField
+const (
+	errorCodeField    protowire.Number = 1
+	errorMessageField protowire.Number = 2
+)
+
+// Fields of a conversation message in requestMessagesField
+const (
+	messageRoleField      protowire.Number = 1
+	messageContentField   protowire.Number = 2
+	messageImagesField    protowire.Number = 3
+	messageToolCallsField protowire.Number = 4
+	messageToolNameField  protowire.Number = 5
+)
+
+// SetRequestImages replaces the images attached to a generate request
+func SetRequestImages(req *llamav1.GenerateRequest, images [][]byte) {
+	setBytesField(req.ProtoReflect(), requestImagesField, images)
+}
+
+// GetRequestImages returns the images attached to a generate request
+func GetRequestImages(req *llamav1.GenerateRequest) [][]byte {
+	return getBytesField(req.ProtoReflect().GetUnknown(), requestImagesField)
+}
+
+// SetRequestTools replaces the tool definitions of a generate request
+func SetRequestTools(req *llamav1.GenerateRequest, tools json.RawMessage) {
+	var values [][]byte
+	if len(tools) > 0 {
+		values = [][]byte{tools}
+	}
+	setBytesField(req.ProtoReflect(), requestToolsField, values)
+}
+
+// GetRequestTools returns the tool definitions of a generate request, or nil if it has none
+func GetRequestTools(req *llamav1.GenerateRequest) json.RawMessage {
+	values := getBytesField(req.ProtoReflect().GetUnknown(), requestToolsField)
+	if len(values) == 0 {
+		return nil
+	}
+	return values[len(values)-1]
+}
+
+// SetRequestMessages replaces the conversation carried by a generate request
+func SetRequestMessages(req *llamav1.GenerateRequest, messages []ChatMessage) error {
+	values := make([][]byte, 0, len(messages))
+	for _, msg := range messages {
+		var encoded []byte
+		encoded = appendStringField(encoded, messageRoleField, msg.Role)
+		encoded = appendStringField(encoded, messageContentField, msg.Content)
+		for _, image := range msg.Images {
+			encoded = protowire.AppendTag(encoded, messageImagesField, protowire.BytesType)
+			encoded = protowire.AppendBytes(encoded, image)
+		}
+		if len(msg.ToolCalls) > 0 {
+			toolCalls, err := json.Marshal(msg.ToolCalls)
+			if err != nil {
+				return fmt.Errorf("failed to marshal tool calls: %w", err)
+			}
+			encoded = protowire.AppendTag(encoded, messageToolCallsField, protowire.BytesType)
+			encoded = protowire.AppendBytes(encoded, toolCalls)
+		}
+		encoded = appendStringField(encoded, messageToolNameField, msg.ToolName)
+		values = append(values, encoded)
+	}
+	setBytesField(req.ProtoReflect(), requestMessagesField, values)
+	return nil
+}
+
+// GetRequestMessages returns the conversation carried by a generate request, or nil for single prompt requests
+func GetRequestMessages(req *llamav1.GenerateRequest) []ChatMessage {
+	values := getBytesField(req.ProtoReflect().GetUnknown(), requestMessagesField)
+	if len(values) == 0 {
+		return nil
+	}
+
+	messages := make([]ChatMessage, 0, len(values))
+	for _, value := range values {
+		var msg ChatMessage
+		forEachField(value, func(num protowire.Number, typ protowire.Type, raw []byte) {
+			if typ != protowire.BytesType {
+				return
+			}
+			data, n := protowire.ConsumeBytes(raw)
+			if n < 0 {
+				return
+			}
+			switch num {
+			case messageRoleField:
+				msg.Role = string(data)
+			case messageContentField:
+				msg.Content = string(data)
+			case messageImagesField:
+				msg.Images = append(msg.Images, data)
+			case messageToolCallsField:
+				var toolCalls []ToolCall
+				if err := json.Unmarshal(data, &toolCalls); err == nil {
+					msg.ToolCalls = toolCalls
+				}
+			case messageToolNameField:
+				msg.ToolName = string(data)
+			}
+		})
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// SetRequestOptions replaces the model options of a generate request
+func SetRequestOptions(req *llamav1.GenerateRequest, options map[string]any) error {
+	var values [][]byte
+	if len(options) > 0 {
+		data, err := json.Marshal(options)
+		if err != nil {
+			return fmt.Errorf("failed to marshal options: %w", err)
+		}
+		values = [][]byte{data}
+	}
+	setBytesField(req.ProtoReflect(), requestOptionsField, values)
+	return nil
+}
+
+// GetRequestOptions returns the model options of a generate request, or nil if it has none
+func GetRequestOptions(req *llamav1.GenerateRequest) (map[string]any, error) {
+	values := getBytesField(req.ProtoReflect().GetUnknown(), requestOptionsField)
+	if len(values) == 0 {
+		return nil, nil
+	}
+	var options map[string]any
+	if err := json.Unmarshal(values[len(values)-1], &options); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal options: %w", err)
+	}
+	return options, nil
+}
+
+// SetResponseError records why a request failed on a generate response
+func SetResponseError(resp *llamav1.GenerateResponse, err *InferenceError) {
+	var values [][]byte
+	if err != nil {
+		var encoded []byte
+		encoded = protowire.AppendTag(encoded, errorCodeField, protowire.VarintType)
+		encoded = protowire.AppendVarint(encoded, uint64(err.Code))
+		encoded = appendStringField(encoded, errorMessageField, err.Message)
+		values = [][]byte{encoded}
+	}
+	setBytesField(resp.ProtoReflect(), responseErrorField, values)
+}
+
+// GetResponseError returns the error recorded on a generate response, or nil if the request succeeded
+func GetResponseError(resp *llamav1.GenerateResponse) *InferenceError {
+	values := getBytesField(resp.ProtoReflect().GetUnknown(), responseErrorField)
+	if len(values) == 0 {
+		return nil
+	}
+
+	inferenceErr := &InferenceError{}
+	forEachField(values[len(values)-1], func(num protowire.Number, typ protowire.Type, raw []byte) {
+		switch {
+		case num == errorCodeField && typ == protowire.VarintType:
+			if code, n := protowire.ConsumeVarint(raw); n >= 0 {
+				inferenceErr.Code = int(code)
+			}
+		case num == errorMessageField && typ == protowire.BytesType:
+			if data, n := protowire.ConsumeBytes(raw); n >= 0 {
+				inferenceErr.Message = string(data)
+			}
+		}
+	})
+	return inferenceErr
+}
+
+// SetResponseToolCalls replaces the tool calls of a generate response
+func SetResponseToolCalls(resp *llamav1.GenerateResponse, toolCalls []ToolCall) error {
+	var values [][]byte
+	if len(toolCalls) > 0 {
+		data, err := json.Marshal(toolCalls)
+		if err != nil {
+			return fmt.Errorf("failed to marshal tool calls: %w", err)
+		}
+		values = [][]byte{data}
+	}
+	setBytesField(resp.ProtoReflect(), responseToolCallsField, values)
+	return nil
+}
+
+// GetResponseToolCalls returns the tool calls of a generate response
+func GetResponseToolCalls(resp *llamav1.GenerateResponse) ([]ToolCall, error) {
+	values := getBytesField(resp.ProtoReflect().GetUnknown(), responseToolCallsField)
+	if len(values) == 0 {
+		return nil, nil
+	}
+	var toolCalls []ToolCall
+	if err := json.Unmarshal(values[len(values)-1], &toolCalls); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tool calls: %w", err)
+	}
+	return toolCalls, nil
+}
+
+// SetResponseReceipt replaces the encoded receipt attached to a generate response
+func SetResponseReceipt(resp *llamav1.GenerateResponse, receipt []byte) {
+	var values [][]byte
+	if len(receipt) > 0 {
+		values = [][]byte{receipt}
+	}
+	setBytesField(resp.ProtoReflect(), responseReceiptField, values)
+}
+
+// GetResponseReceipt returns the encoded receipt attached to a generate response, or nil if it has none
+func GetResponseReceipt(resp *llamav1.GenerateResponse) []byte {
+	values := getBytesField(resp.ProtoReflect().GetUnknown(), responseReceiptField)
+	if len(values) == 0 {
+		return nil
+	}
+	return values[len(values)-1]
+}
+
+// setBytesField replaces all occurrences of a bytes field in the unknown fields of a message
+func setBytesField(m protoreflect.Message, field protowire.Number, values [][]byte) {
+	unknown := removeField(m.GetUnknown(), field)
+	for _, value := range values {
+		unknown = protowire.AppendTag(unknown, field, protowire.BytesType)
+		unknown = protowire.AppendBytes(unknown, value)
+	}
+	m.SetUnknown(unknown)
+}
+
+// getBytesField returns all occurrences of a bytes field in raw
+func getBytesField(raw []byte, field protowire.Number) [][]byte {
+	var values [][]byte
+	forEachField(raw, func(num protowire.Number, typ protowire.Type, value []byte) {
+		if num == field && typ == protowire.BytesType {
+			if data, n := protowire.ConsumeBytes(value); n >= 0 {
+				values = append(values, data)
+			}
+		}
+	})
+	return values
+}
+
+// appendStringField appends a string field, omitting empty values
+func appendStringField(b []byte, field protowire.Number, value string) []byte {
+	if value == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, field, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+// forEachField calls fn with the number, type and encoded value of every well-formed field in raw
+func forEachField(raw []byte, fn func(num protowire.Number, typ protowire.Type, value []byte)) {
+	for len(raw) > 0 {
+		num, typ, n := protowire.ConsumeTag(raw)
+		if n < 0 {
+			return
+		}
+		m := protowire.ConsumeFieldValue(num, typ, raw[n:])
+		if m < 0 {
+			return
+		}
+		fn(num, typ, raw[n:n+m])
+		raw = raw[n+m:]
+	}
+}
+
+// removeField returns raw without any occurrence of the given field
+func removeField(raw []byte, field protowire.Number) []byte {
+	var out []byte
+	for len(raw) > 0 {
+		num, typ, n := protowire.ConsumeTag(raw)
+		if n < 0 {
+			break
+		}
+		m := protowire.ConsumeFieldValue(num, typ, raw[n:])
+		if m < 0 {
+			break
+		}
+		if num != field {
+			out = append(out, raw[:n+m]...)
+		}
+		raw = raw[n+m:]
+	}
+	return out
+}
@@ -0,0 +1,123 @@
+package crowdllama
+
+import (
+	"bytes"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	llamav1 "github.com/crowdllama/crowdllama-pb/llama/v1"
+)
+
+func TestRequestImagesRoundTrip(t *testing.T) {
+	images := [][]byte{{0x89, 'P', 'N', 'G'}, {0xff, 0xd8, 0xff}}
+	msg := CreateGenerateRequestWithImages("llava", "describe the image", images, false)
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+	var decoded llamav1.BaseMessage
+	if err := proto.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal request: %v", err)
+	}
+
+	req := decoded.GetGenerateRequest()
+	if req.GetPrompt() != "describe the image" {
+		t.Errorf("Prompt mismatch: got %q", req.GetPrompt())
+	}
+	got := GetRequestImages(req)
+	if len(got) != len(images) {
+		t.Fatalf("Expected %d images, got %d", len(images), len(got))
+	}
+	for i := range images {
+		if !bytes.Equal(got[i], images[i]) {
+			t.Errorf("Image %d mismatch: got %x, want %x", i, got[i], images[i])
+		}
+	}
+
+	// Replacing the images drops the previous ones
+	SetRequestImages(req, nil)
+	if got := GetRequestImages(req); len(got) != 0 {
+		t.Errorf("Expected no images after clearing, got %d", len(got))
+	}
+}
+
+func TestRequestOptionsAndResponseErrorRoundTrip(t *testing.T) {
+	req := CreateGenerateRequest("llama3.2", "hello", false).GetGenerateRequest()
+	if err := SetRequestOptions(req, map[string]any{"num_ctx": 4096, "temperature": 0.5}); err != nil {
+		t.Fatalf("Failed to set options: %v", err)
+	}
+	resp := &llamav1.GenerateResponse{Response: "Error: refused", Done: true}
+	SetResponseError(resp, &InferenceError{Code: 400, Message: "refused"})
+
+	data, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+	var decodedReq llamav1.GenerateRequest
+	if err := proto.Unmarshal(data, &decodedReq); err != nil {
+		t.Fatalf("Failed to unmarshal request: %v", err)
+	}
+	options, err := GetRequestOptions(&decodedReq)
+	if err != nil {
+		t.Fatalf("Failed to get options: %v", err)
+	}
+	if numCtx, ok := IntOption(options, "num_ctx"); !ok || numCtx != 4096 || options["temperature"] != 0.5 {
+		t.Errorf("Options mismatch: got %v", options)
+	}
+
+	data, err = proto.Marshal(resp)
+	if err != nil {
+		t.Fatalf("Failed to marshal response: %v", err)
+	}
+	var decodedResp llamav1.GenerateResponse
+	if err := proto.Unmarshal(data, &decodedResp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if got := GetResponseError(&decodedResp); got == nil || got.Code != 400 || got.Message != "refused" {
+		t.Errorf("Error mismatch: got %+v", got)
+	}
+	if GetResponseError(&llamav1.GenerateResponse{}) != nil {
+		t.Error("Expected no error on a successful response")
+	}
+}
+
+func TestResponseReceiptRoundTrip(t *testing.T) {
+	resp := &llamav1.GenerateResponse{Response: "hi", Done: true, EvalCount: 2}
+	SetResponseReceipt(resp, []byte(`{"id":"abc"}`))
+
+	data, err := proto.Marshal(resp)
+	if err != nil {
+		t.Fatalf("Failed to marshal response: %v", err)
+	}
+	var decoded llamav1.GenerateResponse
+	if err := proto.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if got := string(GetResponseReceipt(&decoded)); got != `{"id":"abc"}` || decoded.GetEvalCount() != 2 {
+		t.Errorf("Receipt mismatch: got %q", got)
+	}
+
+	SetResponseReceipt(&decoded, nil)
+	if got := GetResponseReceipt(&decoded); got != nil {
+		t.Errorf("Expected no receipt after clearing, got %q", got)
+	}
+}
+
+func TestExtensionFieldsUnusedBySchema(t *testing.T) {
+	// A field the published schema defines would be parsed as a known field and never reach the accessors
+	request := (&llamav1.GenerateRequest{}).ProtoReflect().Descriptor().Fields()
+	for _, num := range []protowire.Number{requestImagesField, requestToolsField, requestMessagesField, requestOptionsField} {
+		if field := request.ByNumber(num); field != nil {
+			t.Errorf("Field %d of GenerateRequest is defined by the schema as %s", num, field.Name())
+		}
+	}
+	response := (&llamav1.GenerateResponse{}).ProtoReflect().Descriptor().Fields()
+	for _, num := range []protowire.Number{responseToolCallsField, responseErrorField, responseReceiptField} {
+		if field := response.ByNumber(num); field != nil {
+			t.Errorf("Field %d of GenerateResponse is defined by the schema as %s", num, field.Name())
+		}
+	}
+}
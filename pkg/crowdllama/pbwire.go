@@ -2,28 +2,149 @@ package crowdllama
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"sync"
 
+	"github.com/klauspost/compress/zstd"
 	"google.golang.org/protobuf/proto"
 
 	llamav1 "github.com/crowdllama/crowdllama-pb/llama/v1"
 )
 
+const (
+	// DefaultMaxMessageSize is the default limit for a protobuf message on any protocol
+	DefaultMaxMessageSize = 10 * 1024 * 1024
+
+	// singleFrameLimit is the largest message written as one frame. Older peers only understand single
+	// frames and reject anything above 10MB, so only messages they could never read are chunked.
+	singleFrameLimit = 10 * 1024 * 1024
+
+	// chunkSize is the payload size of each frame of a chunked message
+	chunkSize = 1024 * 1024
+
+	// moreChunksFlag is set in a frame's length prefix when further chunks of the same message follow
+	moreChunksFlag = 1 << 31
+
+	// compressedFlag is set in the length prefix of every frame of a zstd compressed message. It is only
+	// written on protocols that negotiate compression, so older peers never see it.
+	compressedFlag = 1 << 30
+
+	// compressionThreshold is the smallest message worth compressing
+	compressionThreshold = 1024
+
+	// compressionWindow is the zstd window used for encoding and the largest window accepted when decoding
+	compressionWindow = 8 << 20
+)
+
+// ErrMessageTooLarge is returned when a message exceeds the size limit of its protocol
+var ErrMessageTooLarge = errors.New("message too large")
+
+// ErrMalformedMessage is returned when a frame cannot be decoded, e.g. because it is not valid protobuf
+var ErrMalformedMessage = errors.New("malformed message")
+
+// FrameOptions controls how messages are framed on a stream
+type FrameOptions struct {
+	MaxSize  int  // Largest uncompressed message accepted, 0 uses DefaultMaxMessageSize
+	Compress bool // Compress outgoing messages; compressed incoming messages are always accepted
+}
+
+// ForProtocol returns the options to use on a negotiated protocol, only compressing if the protocol supports it
+func (o FrameOptions) ForProtocol(protocolID string) FrameOptions {
+	o.Compress = o.Compress && SupportsCompression(protocolID)
+	return o
+}
+
+// Limit returns the effective message size limit
+func (o FrameOptions) Limit() int {
+	if o.MaxSize <= 0 {
+		return DefaultMaxMessageSize
+	}
+	return o.MaxSize
+}
+
+// zstdEncoder is shared by all writers; EncodeAll is safe for concurrent use
+var zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+	return zstd.NewWriter(nil, zstd.WithWindowSize(compressionWindow), zstd.WithEncoderConcurrency(1))
+})
+
+// zstdStreamEncoders holds encoders for messages compressed while they are written, which each need their own
+var zstdStreamEncoders = sync.Pool{New: func() any {
+	encoder, err := zstd.NewWriter(nil, zstd.WithWindowSize(compressionWindow), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil
+	}
+	return encoder
+}}
+
 // WriteLengthPrefixedPB writes a protobuf message with a 4-byte length prefix
 func WriteLengthPrefixedPB(w io.Writer, msg *llamav1.BaseMessage) error {
+	return WriteLengthPrefixedPBWithLimit(w, msg, DefaultMaxMessageSize)
+}
+
+// WriteLengthPrefixedPBWithLimit writes a protobuf message with a 4-byte length prefix, refusing messages
+// larger than maxSize. Messages above the single frame limit are written as 1MB chunks.
+func WriteLengthPrefixedPBWithLimit(w io.Writer, msg *llamav1.BaseMessage, maxSize int) error {
+	return WriteLengthPrefixedPBWithOptions(w, msg, FrameOptions{MaxSize: maxSize})
+}
+
+// WriteLengthPrefixedPBWithOptions writes a protobuf message with a 4-byte length prefix, compressing it
+// when the options allow. Messages up to a chunk are only compressed if that makes them smaller; larger ones
+// are compressed chunk by chunk as they are written, so no compressed copy of the whole message is made.
+func WriteLengthPrefixedPBWithOptions(w io.Writer, msg *llamav1.BaseMessage, opts FrameOptions) error {
 	data, err := proto.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("failed to marshal protobuf message: %w", err)
 	}
 
+	maxSize := opts.Limit()
+	if len(data) > maxSize {
+		return fmt.Errorf("%w: %d bytes exceeds the limit of %d bytes", ErrMessageTooLarge, len(data), maxSize)
+	}
+
+	if opts.Compress && len(data) > chunkSize {
+		return writeCompressedChunks(w, data)
+	}
+
+	var flags uint32
+	if opts.Compress && len(data) >= compressionThreshold {
+		compressed, err := compress(data)
+		if err != nil {
+			return err
+		}
+		if len(compressed) < len(data) {
+			data = compressed
+			flags = compressedFlag
+		}
+	}
+
+	if len(data) <= singleFrameLimit {
+		return writeFrame(w, data, flags)
+	}
+
+	for len(data) > 0 {
+		n := min(len(data), chunkSize)
+		frameFlags := flags
+		if n < len(data) {
+			frameFlags |= moreChunksFlag
+		}
+		if err := writeFrame(w, data[:n], frameFlags); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// writeFrame writes a single length-prefixed frame with the given header flags
+func writeFrame(w io.Writer, data []byte, flags uint32) error {
 	// Write 4-byte length prefix (big-endian)
-	const maxMessageSize = 0xffffffff
-	msgLen := len(data)
-	if msgLen > maxMessageSize {
-		return fmt.Errorf("message too large: %d bytes", msgLen)
+	frameLen := len(data)
+	if frameLen > singleFrameLimit {
+		return fmt.Errorf("frame too large: %d bytes", frameLen)
 	}
-	length := uint32(msgLen)
+	length := uint32(frameLen) | flags
 	lengthBytes := make([]byte, 4)
 	binary.BigEndian.PutUint32(lengthBytes, length)
 
@@ -40,31 +161,213 @@ func WriteLengthPrefixedPB(w io.Writer, msg *llamav1.BaseMessage) error {
 	return nil
 }
 
+// writeCompressedChunks compresses a marshalled message into compressed chunks as the encoder produces output.
+// Only protocols that support compression get here, and their peers also understand chunks.
+func writeCompressedChunks(w io.Writer, data []byte) error {
+	encoder, ok := zstdStreamEncoders.Get().(*zstd.Encoder)
+	if !ok {
+		return errors.New("failed to create zstd encoder")
+	}
+	defer func() {
+		// Drop the reference to the chunk buffer before the encoder is reused
+		encoder.Reset(nil)
+		zstdStreamEncoders.Put(encoder)
+	}()
+
+	chunks := &chunkWriter{w: w, buf: make([]byte, 0, chunkSize)}
+	encoder.ResetContentSize(chunks, int64(len(data)))
+	if _, err := encoder.Write(data); err != nil {
+		return fmt.Errorf("failed to write compressed protobuf data: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("failed to write compressed protobuf data: %w", err)
+	}
+	return chunks.flush()
+}
+
+// chunkWriter writes compressed output as frames of chunkSize. It holds back one full chunk until more output
+// arrives, so the last frame is the one written without moreChunksFlag.
+type chunkWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+// Write buffers p, writing every chunk that is known not to be the last one
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(c.buf) == chunkSize {
+			if err := writeFrame(c.w, c.buf, compressedFlag|moreChunksFlag); err != nil {
+				return written, err
+			}
+			c.buf = c.buf[:0]
+		}
+		n := min(len(p), chunkSize-len(c.buf))
+		c.buf = append(c.buf, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// flush writes the last chunk
+func (c *chunkWriter) flush() error {
+	return writeFrame(c.w, c.buf, compressedFlag)
+}
+
 // ReadLengthPrefixedPB reads a length-prefixed protobuf message
 func ReadLengthPrefixedPB(r io.Reader) (*llamav1.BaseMessage, error) {
-	// Read 4-byte length prefix
-	lengthBytes := make([]byte, 4)
-	if _, err := io.ReadFull(r, lengthBytes); err != nil {
-		return nil, fmt.Errorf("failed to read length prefix: %w", err)
-	}
+	return ReadLengthPrefixedPBWithLimit(r, DefaultMaxMessageSize)
+}
 
-	// Parse length (big-endian)
-	length := binary.BigEndian.Uint32(lengthBytes)
-	if length > 10*1024*1024 { // 10MB limit
-		return nil, fmt.Errorf("message too large: %d bytes", length)
+// ReadLengthPrefixedPBWithLimit reads a length-prefixed protobuf message, possibly sent in chunks, and fails
+// with ErrMessageTooLarge as soon as the message grows beyond maxSize
+func ReadLengthPrefixedPBWithLimit(r io.Reader, maxSize int) (*llamav1.BaseMessage, error) {
+	return ReadLengthPrefixedPBWithOptions(r, FrameOptions{MaxSize: maxSize})
+}
+
+// ReadLengthPrefixedPBWithOptions reads a length-prefixed protobuf message, decompressing it if the sender
+// compressed it. The size limit applies to the decompressed message. Compressed chunks are decompressed as
+// they arrive, so the compressed message is never held in full.
+func ReadLengthPrefixedPBWithOptions(r io.Reader, opts FrameOptions) (*llamav1.BaseMessage, error) {
+	frames := &frameReader{r: r, maxSize: opts.Limit()}
+	if err := frames.next(); err != nil {
+		return nil, err
 	}
 
-	// Read protobuf data
-	data := make([]byte, length)
-	if _, err := io.ReadFull(r, data); err != nil {
-		return nil, fmt.Errorf("failed to read protobuf data: %w", err)
+	var data []byte
+	var err error
+	if frames.compressed {
+		data, err = decompress(frames, frames.maxSize)
+	} else {
+		data, err = frames.readAll()
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	// Unmarshal protobuf message
 	var msg llamav1.BaseMessage
 	if err := proto.Unmarshal(data, &msg); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal protobuf message: %w", err)
+		return nil, fmt.Errorf("%w: failed to unmarshal protobuf message: %w", ErrMalformedMessage, err)
 	}
 
 	return &msg, nil
 }
+
+// frameReader reads the payload of the frames of one message in order, refusing messages whose frames add up
+// to more than maxSize
+type frameReader struct {
+	r          io.Reader
+	maxSize    int
+	remaining  int  // payload bytes left in the current frame
+	more       bool // whether further chunks follow the current frame
+	compressed bool
+	total      int   // payload bytes of the frames so far
+	err        error // the first error reading the frames, as opposed to decoding them
+}
+
+// next reads the length prefix of the next frame
+func (f *frameReader) next() error {
+	lengthBytes := make([]byte, 4)
+	if _, err := io.ReadFull(f.r, lengthBytes); err != nil {
+		return f.fail(fmt.Errorf("failed to read length prefix: %w", err))
+	}
+
+	// Parse length (big-endian)
+	header := binary.BigEndian.Uint32(lengthBytes)
+	f.more = header&moreChunksFlag != 0
+	f.compressed = f.compressed || header&compressedFlag != 0
+	f.remaining = int(header &^ (moreChunksFlag | compressedFlag))
+	f.total += f.remaining
+	if f.total > f.maxSize {
+		return f.fail(fmt.Errorf("%w: at least %d bytes, the limit is %d bytes", ErrMessageTooLarge, f.total, f.maxSize))
+	}
+	return nil
+}
+
+// Read reads the payload of the frames as one stream, which ends after the last chunk
+func (f *frameReader) Read(p []byte) (int, error) {
+	for f.remaining == 0 {
+		if !f.more {
+			return 0, io.EOF
+		}
+		if err := f.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.r.Read(p[:min(len(p), f.remaining)])
+	f.remaining -= n
+	if errors.Is(err, io.EOF) {
+		if f.remaining == 0 {
+			return n, nil
+		}
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return n, f.fail(fmt.Errorf("failed to read protobuf data: %w", err))
+	}
+	return n, nil
+}
+
+// readAll reads the payload of all frames into one buffer
+func (f *frameReader) readAll() ([]byte, error) {
+	data := make([]byte, 0, f.remaining)
+	for {
+		start := len(data)
+		data = append(data, make([]byte, f.remaining)...)
+		if _, err := io.ReadFull(f.r, data[start:]); err != nil {
+			return nil, fmt.Errorf("failed to read protobuf data: %w", err)
+		}
+		f.remaining = 0
+
+		if !f.more {
+			return data, nil
+		}
+		if err := f.next(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fail records the first error reading the frames and returns it
+func (f *frameReader) fail(err error) error {
+	if f.err == nil {
+		f.err = err
+	}
+	return err
+}
+
+// compress zstd compresses a marshalled message
+func compress(data []byte) ([]byte, error) {
+	encoder, err := zstdEncoder()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+	}
+	return encoder.EncodeAll(data, make([]byte, 0, len(data))), nil
+}
+
+// decompress expands a zstd compressed message as it is read, stopping as soon as it grows beyond maxSize
+func decompress(frames *frameReader, maxSize int) ([]byte, error) {
+	decoder, err := zstd.NewReader(frames,
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderMaxWindow(compressionWindow))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd decoder: %w", err)
+	}
+	defer decoder.Close()
+
+	out, err := io.ReadAll(io.LimitReader(decoder, int64(maxSize)+1))
+	if frames.err != nil {
+		// The stream failed or the compressed message is too large; the data itself may be fine
+		return nil, frames.err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decompress protobuf data: %w", ErrMalformedMessage, err)
+	}
+	if len(out) > maxSize {
+		return nil, fmt.Errorf("%w: decompresses to more than %d bytes", ErrMessageTooLarge, maxSize)
+	}
+	return out, nil
+}
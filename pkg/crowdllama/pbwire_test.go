@@ -2,6 +2,11 @@ package crowdllama
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
 	"testing"
 
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -90,3 +95,160 @@ func TestWriteReadLengthPrefixedPBResponse(t *testing.T) {
 			originalMsg.GetGenerateResponse().Response)
 	}
 }
+
+func TestWriteReadChunkedPB(t *testing.T) {
+	// Larger than a single frame, so the message is sent in chunks
+	prompt := strings.Repeat("a", singleFrameLimit+chunkSize/2)
+	originalMsg := CreateGenerateRequest("test-model", prompt, false)
+
+	var buf bytes.Buffer
+	if err := WriteLengthPrefixedPBWithLimit(&buf, originalMsg, 2*singleFrameLimit); err != nil {
+		t.Fatalf("Failed to write chunked PB: %v", err)
+	}
+	if header := binary.BigEndian.Uint32(buf.Bytes()[:4]); header&moreChunksFlag == 0 || int(header&^moreChunksFlag) != chunkSize {
+		t.Errorf("Expected first frame to be a %d byte chunk, got header %x", chunkSize, header)
+	}
+
+	readMsg, err := ReadLengthPrefixedPBWithLimit(&buf, 2*singleFrameLimit)
+	if err != nil {
+		t.Fatalf("Failed to read chunked PB: %v", err)
+	}
+	if readMsg.GetGenerateRequest().GetPrompt() != prompt {
+		t.Error("Prompt mismatch after chunked transfer")
+	}
+}
+
+func TestMessageSizeLimits(t *testing.T) {
+	msg := CreateGenerateRequest("test-model", strings.Repeat("a", 2048), false)
+
+	var buf bytes.Buffer
+	if err := WriteLengthPrefixedPBWithLimit(&buf, msg, 1024); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Expected ErrMessageTooLarge on write, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing to be written for an oversized message, got %d bytes", buf.Len())
+	}
+
+	if err := WriteLengthPrefixedPB(&buf, msg); err != nil {
+		t.Fatalf("Failed to write PB: %v", err)
+	}
+	if _, err := ReadLengthPrefixedPBWithLimit(&buf, 1024); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Expected ErrMessageTooLarge on read, got %v", err)
+	}
+
+	if got := (FrameOptions{MaxSize: 1024}).Limit(); got != 1024 {
+		t.Errorf("Expected limit of 1024, got %d", got)
+	}
+	if got := (FrameOptions{}).Limit(); got != DefaultMaxMessageSize {
+		t.Errorf("Expected default limit without a configured one, got %d", got)
+	}
+}
+
+func TestWriteReadCompressedPB(t *testing.T) {
+	prompt := strings.Repeat("compress me ", 1024)
+	originalMsg := CreateGenerateRequest("test-model", prompt, false)
+
+	var buf bytes.Buffer
+	if err := WriteLengthPrefixedPBWithOptions(&buf, originalMsg, FrameOptions{Compress: true}); err != nil {
+		t.Fatalf("Failed to write compressed PB: %v", err)
+	}
+	header := binary.BigEndian.Uint32(buf.Bytes()[:4])
+	if header&compressedFlag == 0 {
+		t.Fatalf("Expected compressed flag in header %x", header)
+	}
+	if length := int(header &^ compressedFlag); length >= len(prompt) {
+		t.Errorf("Expected compressed frame to be smaller than the prompt, got %d bytes", length)
+	}
+
+	readMsg, err := ReadLengthPrefixedPB(&buf)
+	if err != nil {
+		t.Fatalf("Failed to read compressed PB: %v", err)
+	}
+	if readMsg.GetGenerateRequest().GetPrompt() != prompt {
+		t.Error("Prompt mismatch after compressed transfer")
+	}
+
+	// Small messages and peers without compression get plain frames
+	buf.Reset()
+	smallMsg := CreateGenerateRequest("test-model", "hi", false)
+	if err := WriteLengthPrefixedPBWithOptions(&buf, smallMsg, FrameOptions{Compress: true}); err != nil {
+		t.Fatalf("Failed to write PB: %v", err)
+	}
+	if header := binary.BigEndian.Uint32(buf.Bytes()[:4]); header&compressedFlag != 0 {
+		t.Errorf("Expected small message to be sent uncompressed, got header %x", header)
+	}
+	if opts := (FrameOptions{Compress: true}).ForProtocol(InferenceProtocol); opts.Compress {
+		t.Error("Expected no compression on the 1.0.0 inference protocol")
+	}
+	if opts := (FrameOptions{Compress: true}).ForProtocol(CompressedInferenceSessionProtocol); !opts.Compress {
+		t.Error("Expected compression on the 1.1.0 session protocol")
+	}
+	if opts := (FrameOptions{}).ForProtocol(CompressedInferenceSessionProtocol); opts.Compress {
+		t.Error("Expected no compression when it is disabled")
+	}
+}
+
+func TestCompressedMessageSizeLimit(t *testing.T) {
+	// Highly compressible, so the frame is small but the message exceeds the reader's limit
+	msg := CreateGenerateRequest("test-model", strings.Repeat("a", 64*1024), false)
+
+	var buf bytes.Buffer
+	if err := WriteLengthPrefixedPBWithOptions(&buf, msg, FrameOptions{Compress: true}); err != nil {
+		t.Fatalf("Failed to write compressed PB: %v", err)
+	}
+	if buf.Len() > 4096 {
+		t.Fatalf("Expected a small compressed frame, got %d bytes", buf.Len())
+	}
+	if _, err := ReadLengthPrefixedPBWithLimit(&buf, 4096); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Expected ErrMessageTooLarge for an oversized decompressed message, got %v", err)
+	}
+}
+
+func TestWriteReadCompressedChunkedPB(t *testing.T) {
+	// Random data does not compress, so the compressed message spans several chunks
+	raw := make([]byte, 3*chunkSize)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatalf("Failed to generate prompt: %v", err)
+	}
+	prompt := hex.EncodeToString(raw)
+	originalMsg := CreateGenerateRequest("test-model", prompt, false)
+	opts := FrameOptions{MaxSize: 2 * singleFrameLimit, Compress: true}
+
+	var buf bytes.Buffer
+	if err := WriteLengthPrefixedPBWithOptions(&buf, originalMsg, opts); err != nil {
+		t.Fatalf("Failed to write compressed chunked PB: %v", err)
+	}
+	header := binary.BigEndian.Uint32(buf.Bytes()[:4])
+	if header&moreChunksFlag == 0 || header&compressedFlag == 0 || int(header&^(moreChunksFlag|compressedFlag)) != chunkSize {
+		t.Errorf("Expected first frame to be a compressed %d byte chunk, got header %x", chunkSize, header)
+	}
+
+	readMsg, err := ReadLengthPrefixedPBWithOptions(&buf, opts)
+	if err != nil {
+		t.Fatalf("Failed to read compressed chunked PB: %v", err)
+	}
+	if readMsg.GetGenerateRequest().GetPrompt() != prompt {
+		t.Error("Prompt mismatch after compressed chunked transfer")
+	}
+}
+
+func TestReadTruncatedCompressedPB(t *testing.T) {
+	raw := make([]byte, 2*chunkSize)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatalf("Failed to generate prompt: %v", err)
+	}
+	msg := CreateGenerateRequest("test-model", hex.EncodeToString(raw), false)
+	opts := FrameOptions{Compress: true}
+
+	var buf bytes.Buffer
+	if err := WriteLengthPrefixedPBWithOptions(&buf, msg, opts); err != nil {
+		t.Fatalf("Failed to write compressed PB: %v", err)
+	}
+
+	// A stream that ends early is a transport failure, not a malformed message from the peer
+	truncated := bytes.NewReader(buf.Bytes()[:buf.Len()/2])
+	_, err := ReadLengthPrefixedPBWithOptions(truncated, opts)
+	if err == nil || errors.Is(err, ErrMalformedMessage) {
+		t.Errorf("Expected a read error that is not ErrMalformedMessage, got %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package crowdllama
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/proto"
+
+	llamav1 "github.com/crowdllama/crowdllama-pb/llama/v1"
+)
+
+// InferenceSessionProtocol is the protocol identifier for long-lived inference sessions. A session carries
+// many concurrent requests over one stream; every frame is tagged with the request ID it belongs to.
+const InferenceSessionProtocol = "/crowdllama/inference-session/1.0.0"
+
+// CompressedInferenceSessionProtocol is version 1.1 of the session protocol, whose frames may be zstd compressed
+const CompressedInferenceSessionProtocol = "/crowdllama/inference-session/1.1.0"
+
+// WriteSessionFrame writes a request ID followed by a length-prefixed protobuf message. Callers sharing a
+// stream must serialize writes.
+func WriteSessionFrame(w io.Writer, opts FrameOptions, requestID uint64, msg *llamav1.BaseMessage) error {
+	// Check the size first so an oversized message never leaves a partial frame on the stream
+	maxSize := opts.Limit()
+	if size := proto.Size(msg); size > maxSize {
+		return fmt.Errorf("%w: %d bytes exceeds the limit of %d bytes", ErrMessageTooLarge, size, maxSize)
+	}
+
+	idBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(idBytes, requestID)
+	if _, err := w.Write(idBytes); err != nil {
+		return fmt.Errorf("failed to write request ID: %w", err)
+	}
+	return WriteLengthPrefixedPBWithOptions(w, msg, opts)
+}
+
+// ReadSessionFrame reads a frame written by WriteSessionFrame
+func ReadSessionFrame(r io.Reader, opts FrameOptions) (uint64, *llamav1.BaseMessage, error) {
+	idBytes := make([]byte, 8)
+	if _, err := io.ReadFull(r, idBytes); err != nil {
+		return 0, nil, fmt.Errorf("failed to read request ID: %w", err)
+	}
+
+	msg, err := ReadLengthPrefixedPBWithOptions(r, opts)
+	if err != nil {
+		return 0, nil, err
+	}
+	return binary.BigEndian.Uint64(idBytes), msg, nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/protocol"
 )
 
@@ -19,6 +20,18 @@ const (
 	// InferenceProtocol is the protocol identifier for inference requests
 	InferenceProtocol = "/crowdllama/inference/1.0.0"
 
+	// CompressedInferenceProtocol is version 1.1 of the inference protocol, whose frames may be zstd compressed
+	CompressedInferenceProtocol = "/crowdllama/inference/1.1.0"
+
+	// ModelHintProtocol is the protocol gateways use to tell a worker about demand for a model nobody serves
+	ModelHintProtocol = "/crowdllama/model-hint/1.0.0"
+
+	// AccessProtocol is the protocol consumers use to present an access token to a worker that restricts inference
+	AccessProtocol = "/crowdllama/access/1.0.0"
+
+	// ReceiptProtocol is the protocol consumers use to return the receipts they countersigned to the worker
+	ReceiptProtocol = "/crowdllama/receipt/1.0.0"
+
 	// PeerMetadataPrefix is the DHT key prefix for peer metadata
 	PeerMetadataPrefix = "/crowdllama/peer/"
 
@@ -26,17 +39,59 @@ const (
 	PeerNamespace = "crowdllama-ns"
 )
 
+const (
+	// ReachabilityUnknown means AutoNAT has not determined whether the peer is reachable yet
+	ReachabilityUnknown = "unknown"
+
+	// ReachabilityPublic means the peer accepts direct inbound connections
+	ReachabilityPublic = "public"
+
+	// ReachabilityPrivate means the peer is behind NAT and reachable only via hole punching or relays
+	ReachabilityPrivate = "private"
+)
+
 // Resource represents a CrowdLlama resource (peer metadata)
 type Resource struct {
-	PeerID           string    `json:"peer_id"`
-	SupportedModels  []string  `json:"supported_models"`
-	TokensThroughput float64   `json:"tokens_throughput"` // tokens/sec
-	VRAMGB           int       `json:"vram_gb"`
-	Load             float64   `json:"load"` // current load (0.0 to 1.0)
-	GPUModel         string    `json:"gpu_model"`
-	LastUpdated      time.Time `json:"last_updated"`
-	Version          string    `json:"version"`     // CrowdLlama version (git commit hash)
-	WorkerMode       bool      `json:"worker_mode"` // true if this peer is in worker mode
+	PeerID           string      `json:"peer_id"`
+	SupportedModels  []string    `json:"supported_models"`
+	TokensThroughput float64     `json:"tokens_throughput"` // tokens/sec
+	VRAMGB           int         `json:"vram_gb"`
+	Load             float64     `json:"load"` // current load (0.0 to 1.0)
+	GPUModel         string      `json:"gpu_model"`
+	LastUpdated      time.Time   `json:"last_updated"`
+	Version          string      `json:"version"`                    // CrowdLlama version (git commit hash)
+	WorkerMode       bool        `json:"worker_mode"`                // true if this peer is in worker mode
+	Reachability     string      `json:"reachability,omitempty"`     // AutoNAT reachability: public, private or unknown
+	ProtocolVersion  int         `json:"protocol_version,omitempty"` // CrowdLlama protocol version, 0 for older peers
+	Protocols        []string    `json:"protocols,omitempty"`        // supported libp2p protocol IDs
+	Features         []string    `json:"features,omitempty"`         // supported request features, see FeatureChat
+	MaxMessageSize   int         `json:"max_message_size,omitempty"` // largest inference message accepted, in bytes
+	VisionModels     []string    `json:"vision_models,omitempty"`    // supported models that accept image input
+	ModelPulls       []ModelPull `json:"model_pulls,omitempty"`      // models the worker is downloading or failed to download
+	Paused           bool        `json:"paused,omitempty"`           // true if the worker does not accept new requests
+	PauseReason      string      `json:"pause_reason,omitempty"`     // why the worker is paused, see PauseReasonOperator
+}
+
+// Reasons a worker reports for being paused
+const (
+	PauseReasonOperator = "operator"  // paused through the CLI or IPC
+	PauseReasonSchedule = "schedule"  // outside the worker's availability schedule
+	PauseReasonLocalUse = "local_use" // the owner is using the machine's CPU or GPU
+	PauseReasonShutdown = "shutdown"  // draining in-flight requests before shutting down
+)
+
+// ModelPull reports the progress of a model a worker pulls on demand
+type ModelPull struct {
+	Model     string `json:"model"`
+	Status    string `json:"status"`          // pulling or failed
+	Completed int64  `json:"completed"`       // bytes downloaded so far
+	Total     int64  `json:"total"`           // bytes to download, as far as known
+	Error     string `json:"error,omitempty"` // why a failed pull failed
+}
+
+// ModelHint is sent over ModelHintProtocol to report a request for a model no worker serves
+type ModelHint struct {
+	Model string `json:"model"`
 }
 
 // NewCrowdLlamaResource creates a new resource with the given peer ID
@@ -51,9 +106,27 @@ func NewCrowdLlamaResource(peerID string) *Resource {
 		LastUpdated:      time.Now(),
 		Version:          "unknown", // Will be set during metadata update
 		WorkerMode:       false,     // Default to consumer mode
+		ProtocolVersion:  ProtocolVersion,
 	}
 }
 
+// ReachabilityFromNetwork converts a libp2p reachability value to its Resource representation
+func ReachabilityFromNetwork(reachability network.Reachability) string {
+	switch reachability {
+	case network.ReachabilityPublic:
+		return ReachabilityPublic
+	case network.ReachabilityPrivate:
+		return ReachabilityPrivate
+	default:
+		return ReachabilityUnknown
+	}
+}
+
+// IsPrivate returns true if the peer reported that it is behind NAT
+func (r *Resource) IsPrivate() bool {
+	return r.Reachability == ReachabilityPrivate
+}
+
 // ToJSON serializes the resource to JSON
 func (r *Resource) ToJSON() ([]byte, error) {
 	data, err := json.Marshal(r)
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/protocol"
 )
 
@@ -142,3 +143,22 @@ func TestGetProtocol(t *testing.T) {
 		t.Errorf("Expected protocol %s, got %s", expectedProtocol, protocolID)
 	}
 }
+
+func TestReachabilityFromNetwork(t *testing.T) {
+	tests := map[network.Reachability]string{
+		network.ReachabilityPublic:  ReachabilityPublic,
+		network.ReachabilityPrivate: ReachabilityPrivate,
+		network.ReachabilityUnknown: ReachabilityUnknown,
+	}
+	for reachability, expected := range tests {
+		if got := ReachabilityFromNetwork(reachability); got != expected {
+			t.Errorf("Expected %s for %v, got %s", expected, reachability, got)
+		}
+	}
+
+	resource := NewCrowdLlamaResource("test-peer")
+	resource.Reachability = ReachabilityFromNetwork(network.ReachabilityPrivate)
+	if !resource.IsPrivate() {
+		t.Error("Expected resource behind NAT to be private")
+	}
+}
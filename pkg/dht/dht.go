@@ -18,7 +18,10 @@ import (
 	"github.com/multiformats/go-multiaddr"
 	"go.uber.org/zap"
 
+	"github.com/crowdllama/crowdllama/internal/discovery"
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
 	"github.com/crowdllama/crowdllama/pkg/peermanager"
+	"github.com/crowdllama/crowdllama/pkg/peerstate"
 )
 
 // DefaultListenAddrs is the default listen addresses for the DHT server
@@ -36,6 +39,17 @@ type Server struct {
 	cancel      context.CancelFunc
 	peerManager *peermanager.Manager
 	peerAddrs   []string
+	siblings    *siblingSet
+	stateStore  *peerstate.Store
+}
+
+// ServerConfig holds optional settings for a DHT server
+type ServerConfig struct {
+	ListenAddrs []string
+	// StateStore persists the peerstore and DHT records across restarts; nil disables persistence
+	StateStore *peerstate.Store
+	// RelayService makes the server a circuit v2 relay for peers behind NAT
+	RelayService bool
 }
 
 // NewDHTServer creates a new DHT server instance
@@ -45,20 +59,33 @@ func NewDHTServer(ctx context.Context, privKey crypto.PrivKey, logger *zap.Logge
 
 // NewDHTServerWithAddrs creates a new DHT server instance with custom listen addresses
 func NewDHTServerWithAddrs(ctx context.Context, privKey crypto.PrivKey, logger *zap.Logger, listenAddrs []string) (*Server, error) {
+	return NewDHTServerWithConfig(ctx, privKey, logger, &ServerConfig{ListenAddrs: listenAddrs})
+}
+
+// NewDHTServerWithConfig creates a new DHT server instance from a server configuration
+func NewDHTServerWithConfig(ctx context.Context, privKey crypto.PrivKey, logger *zap.Logger, serverCfg *ServerConfig) (*Server, error) {
 	ctx, cancel := context.WithCancel(ctx)
 
+	listenAddrs := serverCfg.ListenAddrs
+
 	// Use default addresses if none provided
 	if len(listenAddrs) == 0 {
 		listenAddrs = DefaultListenAddrs
 	}
 
-	h, err := createLibp2pHost(ctx, privKey, listenAddrs)
+	h, err := createLibp2pHost(ctx, privKey, listenAddrs, serverCfg.RelayService)
 	if err != nil {
 		cancel()
 		return nil, fmt.Errorf("create libp2p host: %w", err)
 	}
 
-	kadDHT, err := createDHT(ctx, h)
+	var dhtOpts []dht.Option
+	if serverCfg.StateStore != nil {
+		dhtOpts = append(dhtOpts, serverCfg.StateStore.DHTOption())
+		serverCfg.StateStore.RestorePeerstore(h)
+	}
+
+	kadDHT, err := createDHT(ctx, h, dhtOpts...)
 	if err != nil {
 		cancel()
 		return nil, fmt.Errorf("create DHT: %w", err)
@@ -72,8 +99,10 @@ func NewDHTServerWithAddrs(ctx context.Context, privKey crypto.PrivKey, logger *
 		ctx:         ctx,
 		cancel:      cancel,
 		peerManager: peermanager.NewManager(ctx, h, kadDHT, logger, peerConfig),
+		stateStore:  serverCfg.StateStore,
 	}
 
+	server.siblings = newSiblingSet(server)
 	server.peerAddrs = generatePeerAddrs(h)
 	if len(server.peerAddrs) == 0 {
 		logger.Warn("No peer addresses generated, this may indicate a configuration issue")
@@ -87,7 +116,7 @@ func NewDHTServerWithAddrs(ctx context.Context, privKey crypto.PrivKey, logger *
 	return server, nil
 }
 
-func createLibp2pHost(_ context.Context, privKey crypto.PrivKey, listenAddrs []string) (host.Host, error) {
+func createLibp2pHost(_ context.Context, privKey crypto.PrivKey, listenAddrs []string, relayService bool) (host.Host, error) {
 	libp2pOpts := []libp2p.Option{
 		libp2p.Identity(privKey),
 		libp2p.ListenAddrStrings(listenAddrs...),
@@ -95,6 +124,16 @@ func createLibp2pHost(_ context.Context, privKey crypto.PrivKey, listenAddrs []s
 		libp2p.DefaultMuxers,
 		libp2p.DefaultSecurity,
 		libp2p.NATPortMap(),
+		// Answer AutoNAT dial-back requests so workers learn whether they are behind NAT
+		libp2p.EnableNATService(),
+	}
+	if relayService {
+		// The relay service only runs while the host considers itself public. DHT servers are deployed on
+		// public addresses, so skip waiting for AutoNAT to confirm it.
+		libp2pOpts = append(libp2pOpts,
+			libp2p.EnableRelayService(),
+			libp2p.ForceReachabilityPublic(),
+		)
 	}
 	h, err := libp2p.New(libp2pOpts...)
 	if err != nil {
@@ -103,8 +142,8 @@ func createLibp2pHost(_ context.Context, privKey crypto.PrivKey, listenAddrs []s
 	return h, nil
 }
 
-func createDHT(ctx context.Context, h host.Host) (*dht.IpfsDHT, error) {
-	dhtInstance, err := dht.New(ctx, h, dht.Mode(dht.ModeServer))
+func createDHT(ctx context.Context, h host.Host, opts ...dht.Option) (*dht.IpfsDHT, error) {
+	dhtInstance, err := dht.New(ctx, h, append([]dht.Option{dht.Mode(dht.ModeServer)}, opts...)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create DHT: %w", err)
 	}
@@ -190,9 +229,20 @@ func (s *Server) Start() (string, error) {
 	// Start the peer manager
 	s.peerManager.Start()
 
+	// Start maintaining connections to sibling DHT servers
+	if err := s.siblings.peering.Start(); err != nil {
+		return "", fmt.Errorf("failed to start sibling peering: %w", err)
+	}
+
 	// Start periodic logging
 	go s.startPeriodicLogging()
 
+	if s.stateStore != nil {
+		s.peerManager.RestorePeers(s.stateStore.KnownPeers())
+		go s.stateStore.ConnectSavedPeers(s.ctx, s.Host)
+		go s.startStatePersistence()
+	}
+
 	s.logger.Info("Bootstrapping DHT network")
 	if err := s.DHT.Bootstrap(s.ctx); err != nil {
 		return "", fmt.Errorf("failed to bootstrap DHT: %w", err)
@@ -205,16 +255,76 @@ func (s *Server) Start() (string, error) {
 	return "", fmt.Errorf("no peer addresses available")
 }
 
+// BootstrapWithPeers connects the server to other DHT nodes so it joins an existing network
+// instead of starting an isolated one. Entries may be p2p multiaddrs or /dnsaddr/ names.
+func (s *Server) BootstrapWithPeers(bootstrapPeers []string) error {
+	if len(bootstrapPeers) == 0 {
+		return nil
+	}
+	if err := discovery.BootstrapDHTWithPeers(s.ctx, s.Host, s.DHT, bootstrapPeers, s.logger); err != nil {
+		return fmt.Errorf("bootstrap with peers: %w", err)
+	}
+	return nil
+}
+
 // Stop stops the DHT server
 func (s *Server) Stop() {
 	s.logger.Info("Stopping DHT server...")
+	if err := s.SaveState(s.ctx); err != nil {
+		s.logger.Warn("Failed to save DHT server state", zap.Error(err))
+	}
+	s.siblings.peering.Stop()
 	s.peerManager.Stop()
 	s.cancel()
+	if err := s.DHT.Close(); err != nil {
+		s.logger.Error("Failed to close DHT", zap.Error(err))
+	}
+	// The state store backs the DHT's records, so it is closed after the DHT
+	if s.stateStore != nil {
+		if err := s.stateStore.Close(); err != nil {
+			s.logger.Error("Failed to close peer state", zap.Error(err))
+		}
+	}
 	if err := s.Host.Close(); err != nil {
 		s.logger.Error("Failed to close host", zap.Error(err))
 	}
 }
 
+// SaveState writes the peerstore, DHT records and known peers to disk. It is a no-op when
+// state persistence is disabled.
+func (s *Server) SaveState(ctx context.Context) error {
+	if s.stateStore == nil {
+		return nil
+	}
+
+	knownPeers := make([]*crowdllama.Resource, 0)
+	for _, metadata := range s.peerManager.GetAvailablePeers() {
+		knownPeers = append(knownPeers, metadata)
+	}
+
+	if err := s.stateStore.Save(ctx, s.Host, s.DHT, knownPeers); err != nil {
+		return fmt.Errorf("save DHT server state: %w", err)
+	}
+	return nil
+}
+
+// startStatePersistence periodically writes the server state to disk
+func (s *Server) startStatePersistence() {
+	ticker := time.NewTicker(peerstate.DefaultSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.SaveState(s.ctx); err != nil {
+				s.logger.Warn("Failed to save DHT server state", zap.Error(err))
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
 // GetPeerID returns the DHT server's peer ID
 func (s *Server) GetPeerID() string {
 	return s.Host.ID().String()
@@ -350,6 +460,8 @@ func (s *Server) handlePeerConnected(_ network.Network, conn network.Conn) {
 	direction := conn.Stat().Direction.String()
 	transport := conn.RemoteMultiaddr().Protocols()[0].Name
 
+	s.updateSiblingConnectedness(conn.RemotePeer(), true)
+
 	s.logger.Debug("New peer connected",
 		zap.String("peer_id", peerID),
 		zap.String("remote_addr", remoteAddr),
@@ -371,6 +483,8 @@ func (s *Server) handlePeerDisconnected(_ network.Network, conn network.Conn) {
 	peerID := conn.RemotePeer().String()
 	remoteAddr := conn.RemoteMultiaddr().String()
 
+	s.updateSiblingConnectedness(conn.RemotePeer(), false)
+
 	s.logger.Info("Peer disconnected",
 		zap.String("peer_id", peerID),
 		zap.String("remote_addr", remoteAddr))
@@ -416,6 +530,7 @@ func (s *Server) startPeriodicLogging() {
 			s.LogNATStatus()
 		case <-statsTicker.C:
 			s.LogPeerStats()
+			s.LogSiblingStatus()
 		case <-s.ctx.Done():
 			return
 		}
@@ -335,3 +335,73 @@ func TestDHTServerStart(t *testing.T) {
 
 	t.Logf("DHT server running with peer ID: %s", peerID)
 }
+
+func TestDHTServerSiblings(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	logger, _ := zap.NewDevelopment()
+
+	keyA, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	keyB, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	serverA := createTestDHTServer(ctx, t, keyA, logger, []string{"/ip4/127.0.0.1/tcp/0"})
+	serverB := createTestDHTServer(ctx, t, keyB, logger, []string{"/ip4/127.0.0.1/tcp/0"})
+	defer serverB.Stop()
+
+	// The same sibling list is deployed to both servers; each must ignore its own entry
+	siblings := []string{serverA.GetPrimaryPeerAddr(), serverB.GetPrimaryPeerAddr()}
+	if err := serverA.AddSiblings(siblings); err != nil {
+		t.Fatalf("Failed to add siblings to server A: %v", err)
+	}
+	if err := serverB.AddSiblings(siblings); err != nil {
+		t.Fatalf("Failed to add siblings to server B: %v", err)
+	}
+
+	if len(serverB.GetSiblings()) != 1 {
+		t.Fatalf("Expected server B to have exactly 1 sibling, got %d", len(serverB.GetSiblings()))
+	}
+
+	if _, err := serverA.Start(); err != nil {
+		t.Fatalf("Failed to start server A: %v", err)
+	}
+	if _, err := serverB.Start(); err != nil {
+		t.Fatalf("Failed to start server B: %v", err)
+	}
+
+	waitForSiblings(t, serverB, 1)
+	if connected := serverB.GetConnectedSiblings(); connected[0] != serverA.GetPeerID() {
+		t.Errorf("Expected connected sibling %s, got %v", serverA.GetPeerID(), connected)
+	}
+
+	serverA.Stop()
+	waitForSiblings(t, serverB, 0)
+
+	if status := serverB.GetSiblings()[0]; status.LastDisconnect.IsZero() {
+		t.Error("Expected sibling disconnect time to be recorded")
+	}
+}
+
+func waitForSiblings(t *testing.T, server *Server, expected int) {
+	t.Helper()
+	// Siblings dial each other at the same time on start; if that first attempt fails the peering
+	// service retries after a back-off of 7.5s to 12.5s
+	deadline := time.Now().Add(20 * time.Second)
+	for time.Now().Before(deadline) {
+		if len(server.GetConnectedSiblings()) == expected {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d connected siblings, got %v", expected, server.GetConnectedSiblings())
+}
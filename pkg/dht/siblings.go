@@ -0,0 +1,152 @@
+package dht
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/peering"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+
+	"github.com/crowdllama/crowdllama/internal/discovery"
+)
+
+// SiblingStatus describes the connection state of a sibling DHT server
+type SiblingStatus struct {
+	PeerID         string    `json:"peer_id"`
+	Addrs          []string  `json:"addrs"`
+	Connected      bool      `json:"connected"`
+	LastConnected  time.Time `json:"last_connected"`
+	LastDisconnect time.Time `json:"last_disconnect"`
+}
+
+// siblingSet tracks the sibling DHT servers this server keeps permanent connections to
+type siblingSet struct {
+	peering *peering.PeeringService
+	mu      sync.RWMutex
+	status  map[peer.ID]*SiblingStatus
+}
+
+func newSiblingSet(s *Server) *siblingSet {
+	return &siblingSet{
+		peering: peering.NewPeeringService(s.Host),
+		status:  make(map[peer.ID]*SiblingStatus),
+	}
+}
+
+// AddSiblings registers sibling DHT servers. The server keeps protected connections to every sibling and
+// reconnects with a back-off whenever one drops, so several bootstrap nodes form a single network.
+// Entries may be p2p multiaddrs or /dnsaddr/ names; the server's own peer ID is ignored so the same list
+// can be deployed to every node.
+func (s *Server) AddSiblings(addrs []string) error {
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	infos := discovery.ResolveBootstrapPeers(s.ctx, addrs, s.logger)
+	if len(infos) == 0 {
+		return fmt.Errorf("none of the configured siblings could be parsed or resolved: %v", addrs)
+	}
+
+	for _, info := range infos {
+		if info.ID == s.Host.ID() {
+			continue
+		}
+
+		s.siblings.mu.Lock()
+		status, exists := s.siblings.status[info.ID]
+		if !exists {
+			status = &SiblingStatus{PeerID: info.ID.String()}
+			s.siblings.status[info.ID] = status
+		}
+		status.Addrs = status.Addrs[:0]
+		for _, addr := range info.Addrs {
+			status.Addrs = append(status.Addrs, addr.String())
+		}
+		status.Connected = s.Host.Network().Connectedness(info.ID) == network.Connected
+		s.siblings.mu.Unlock()
+
+		s.siblings.peering.AddPeer(info)
+
+		s.logger.Info("Added sibling DHT server",
+			zap.String("peer_id", info.ID.String()),
+			zap.Int("addrs", len(info.Addrs)))
+	}
+	return nil
+}
+
+// GetSiblings returns the status of all configured sibling DHT servers
+func (s *Server) GetSiblings() []SiblingStatus {
+	s.siblings.mu.RLock()
+	defer s.siblings.mu.RUnlock()
+
+	result := make([]SiblingStatus, 0, len(s.siblings.status))
+	for _, status := range s.siblings.status {
+		entry := *status
+		entry.Addrs = append([]string(nil), status.Addrs...)
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].PeerID < result[j].PeerID
+	})
+	return result
+}
+
+// GetConnectedSiblings returns the peer IDs of the sibling DHT servers that are currently connected
+func (s *Server) GetConnectedSiblings() []string {
+	var connected []string
+	for _, status := range s.GetSiblings() {
+		if status.Connected {
+			connected = append(connected, status.PeerID)
+		}
+	}
+	return connected
+}
+
+// LogSiblingStatus logs how many sibling DHT servers are currently connected
+func (s *Server) LogSiblingStatus() {
+	siblings := s.GetSiblings()
+	if len(siblings) == 0 {
+		return
+	}
+
+	down := make([]string, 0)
+	for _, status := range siblings {
+		if !status.Connected {
+			down = append(down, status.PeerID)
+		}
+	}
+
+	s.logger.Info("Sibling DHT servers",
+		zap.Int("total_siblings", len(siblings)),
+		zap.Int("connected_siblings", len(siblings)-len(down)),
+		zap.Strings("disconnected_siblings", down))
+}
+
+// updateSiblingConnectedness records a connection state change if the peer is a sibling
+func (s *Server) updateSiblingConnectedness(peerID peer.ID, connected bool) {
+	s.siblings.mu.Lock()
+	defer s.siblings.mu.Unlock()
+
+	status, ok := s.siblings.status[peerID]
+	if !ok {
+		return
+	}
+
+	// Another connection to the sibling may still be open
+	if !connected && s.Host.Network().Connectedness(peerID) == network.Connected {
+		return
+	}
+
+	if connected && !status.Connected {
+		status.LastConnected = time.Now()
+		s.logger.Info("Sibling DHT server connected", zap.String("peer_id", status.PeerID))
+	} else if !connected && status.Connected {
+		status.LastDisconnect = time.Now()
+		s.logger.Warn("Sibling DHT server disconnected", zap.String("peer_id", status.PeerID))
+	}
+	status.Connected = connected
+}
@@ -0,0 +1,119 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+)
+
+// accessTimeout bounds how long presenting an access token to a worker may take
+const accessTimeout = 10 * time.Second
+
+// maxAccessGrantSize bounds the size of a worker's answer to an access token
+const maxAccessGrantSize = 4096
+
+// accessGrants remembers the workers that accepted the gateway's access token
+type accessGrants struct {
+	mu      sync.Mutex
+	expires map[peer.ID]time.Time
+}
+
+// valid returns true if worker accepted the token and the grant has not expired at now
+func (a *accessGrants) valid(worker peer.ID, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	expires, ok := a.expires[worker]
+	return ok && now.Before(expires)
+}
+
+// record remembers that worker accepted the token until expires
+func (a *accessGrants) record(worker peer.ID, expires time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.expires == nil {
+		a.expires = make(map[peer.ID]time.Time)
+	}
+	a.expires[worker] = expires
+}
+
+// forget drops the grant of worker, e.g. after it restarted and refused a request
+func (a *accessGrants) forget(worker peer.ID) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.expires, worker)
+}
+
+// SetAccessToken makes the gateway present token to workers that only serve consumers holding one
+func (g *Gateway) SetAccessToken(token string) {
+	g.accessToken = token
+}
+
+// ensureAccess presents the gateway's access token to a worker that accepts tokens, unless it already
+// accepted it
+func (g *Gateway) ensureAccess(ctx context.Context, workerID peer.ID) error {
+	if g.accessToken == "" || g.accessGrants.valid(workerID, time.Now()) {
+		return nil
+	}
+	info, ok := g.peer.PeerManager.GetAllPeers()[workerID.String()]
+	if !ok || info.Metadata == nil || !info.Metadata.SupportsProtocol(crowdllama.AccessProtocol) {
+		return nil
+	}
+
+	grant, err := g.presentAccessToken(ctx, workerID)
+	if err != nil {
+		return err
+	}
+	if grant.Error != "" {
+		return fmt.Errorf("%w: %s", crowdllama.ErrAccessDenied, grant.Error)
+	}
+	g.accessGrants.record(workerID, grant.Expires)
+	g.logger.Debug("Worker accepted access token",
+		zap.String("worker_id", workerID.String()),
+		zap.Time("expires", grant.Expires))
+	return nil
+}
+
+// presentAccessToken sends the access token to a worker and returns its answer
+func (g *Gateway) presentAccessToken(ctx context.Context, workerID peer.ID) (*crowdllama.AccessGrant, error) {
+	ctx, cancel := context.WithTimeout(ctx, accessTimeout)
+	defer cancel()
+	s, err := g.peer.Host.NewStream(network.WithAllowLimitedConn(ctx, "access"), workerID, crowdllama.AccessProtocol)
+	if err != nil {
+		return nil, fmt.Errorf("open access stream: %w", err)
+	}
+	defer func() {
+		if closeErr := s.Close(); closeErr != nil {
+			g.logger.Debug("Failed to close stream", zap.Error(closeErr))
+		}
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := s.SetDeadline(deadline); err != nil {
+			g.logger.Debug("Failed to set stream deadline", zap.Error(err))
+		}
+	}
+
+	if _, err := s.Write([]byte(g.accessToken)); err != nil {
+		return nil, fmt.Errorf("write access token: %w", err)
+	}
+	if err := s.CloseWrite(); err != nil {
+		return nil, fmt.Errorf("close access stream for writing: %w", err)
+	}
+	data, err := io.ReadAll(io.LimitReader(s, maxAccessGrantSize))
+	if err != nil {
+		return nil, fmt.Errorf("read access grant: %w", err)
+	}
+	var grant crowdllama.AccessGrant
+	if err := json.Unmarshal(data, &grant); err != nil {
+		return nil, fmt.Errorf("decode access grant: %w", err)
+	}
+	return &grant, nil
+}
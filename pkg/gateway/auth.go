@@ -0,0 +1,184 @@
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// API key scopes
+const (
+	ScopeChat = "chat" // /api/chat and /v1/chat/completions
+	ScopeAll  = "*"    // every endpoint, also granted by a key without scopes
+)
+
+// routeScopes maps endpoints to the scope a key needs to call them. Other endpoints need a valid key with any
+// scope, except the health check, which load balancers call without credentials.
+var routeScopes = map[string]string{
+	"/api/chat":            ScopeChat,
+	"/v1/chat/completions": ScopeChat,
+}
+
+// publicRoutes are the endpoints served without an API key
+var publicRoutes = map[string]bool{
+	"/api/health": true,
+}
+
+var (
+	errMissingAPIKey = errors.New("missing API key, send it as 'Authorization: Bearer <key>'")
+	errInvalidAPIKey = errors.New("invalid API key")
+)
+
+// APIKey is a key clients authenticate to the gateway with
+type APIKey struct {
+	Name   string   `json:"name"`             // identifies the client in logs
+	Key    string   `json:"key"`              // secret sent as a Bearer token
+	Scopes []string `json:"scopes,omitempty"` // endpoints the key may call, see ScopeChat; empty allows all
+	Limits Limits   `json:"limits"`           // overrides the gateway's limits for this client; -1 removes a limit
+}
+
+// Allows returns true if the key may call endpoints that need scope
+func (k *APIKey) Allows(scope string) bool {
+	return len(k.Scopes) == 0 || slices.Contains(k.Scopes, ScopeAll) || slices.Contains(k.Scopes, scope)
+}
+
+// APIKeys is the set of keys the gateway accepts
+type APIKeys struct {
+	byHash map[[sha256.Size]byte]*APIKey
+}
+
+// NewAPIKeys checks keys and builds the set of accepted keys. Names and keys must be unique.
+func NewAPIKeys(keys []APIKey) (*APIKeys, error) {
+	set := &APIKeys{byHash: make(map[[sha256.Size]byte]*APIKey, len(keys))}
+	names := make(map[string]bool, len(keys))
+	for i := range keys {
+		key := &keys[i]
+		switch {
+		case key.Name == "":
+			return nil, fmt.Errorf("API key %d has no name", i+1)
+		case key.Key == "":
+			return nil, fmt.Errorf("API key %q has no key", key.Name)
+		case names[key.Name]:
+			return nil, fmt.Errorf("duplicate API key name %q", key.Name)
+		}
+		for _, scope := range key.Scopes {
+			if scope != ScopeChat && scope != ScopeAll {
+				return nil, fmt.Errorf("API key %q has unknown scope %q, expected %s or %s", key.Name, scope, ScopeChat, ScopeAll)
+			}
+		}
+		hash := sha256.Sum256([]byte(key.Key))
+		if _, ok := set.byHash[hash]; ok {
+			return nil, fmt.Errorf("API key %q reuses the key of another client", key.Name)
+		}
+		names[key.Name] = true
+		set.byHash[hash] = key
+	}
+	return set, nil
+}
+
+// LoadAPIKeys reads the keys from a JSON file holding an array of APIKey objects, if path is set, and adds the
+// inline keys given as name=key, which get all scopes. It returns nil if no key is configured.
+func LoadAPIKeys(path string, inline []string) (*APIKeys, error) {
+	var keys []APIKey
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read API keys file %s: %w", path, err)
+		}
+		if err := json.Unmarshal(data, &keys); err != nil {
+			return nil, fmt.Errorf("parse API keys file %s: %w", path, err)
+		}
+	}
+	for _, entry := range inline {
+		name, key, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, errors.New("inline API keys must be written as name=key")
+		}
+		keys = append(keys, APIKey{Name: name, Key: key})
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return NewAPIKeys(keys)
+}
+
+// Len returns the number of accepted keys
+func (s *APIKeys) Len() int {
+	return len(s.byHash)
+}
+
+// Authenticate returns the key sent in an Authorization header
+func (s *APIKeys) Authenticate(header string) (*APIKey, error) {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, errMissingAPIKey
+	}
+	// Looking keys up by their hash keeps the comparison from leaking how much of a guess matched
+	key, ok := s.byHash[sha256.Sum256([]byte(strings.TrimSpace(token)))]
+	if !ok {
+		return nil, errInvalidAPIKey
+	}
+	return key, nil
+}
+
+// clientKey is the context key of the API key a request was authenticated with
+type clientKey struct{}
+
+// ClientFromContext returns the API key the request carrying ctx was authenticated with, or nil if the gateway
+// does not require keys
+func ClientFromContext(ctx context.Context) *APIKey {
+	key, _ := ctx.Value(clientKey{}).(*APIKey)
+	return key
+}
+
+// SetAPIKeys requires clients to authenticate with one of keys; nil serves every client
+func (g *Gateway) SetAPIKeys(keys *APIKeys) {
+	g.apiKeys = keys
+}
+
+// authMiddleware rejects requests without a valid API key before they reach the handlers
+func (g *Gateway) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.apiKeys == nil || publicRoutes[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := g.apiKeys.Authenticate(r.Header.Get("Authorization"))
+		if err != nil {
+			g.logger.Warn("Rejected unauthenticated request",
+				zap.String("path", r.URL.Path),
+				zap.String("remote_addr", r.RemoteAddr),
+				zap.Error(err))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="crowdllama"`)
+			g.sendEndpointError(w, r, http.StatusUnauthorized, err)
+			return
+		}
+		if scope, ok := routeScopes[r.URL.Path]; ok && !key.Allows(scope) {
+			g.logger.Warn("Rejected request outside the key's scopes",
+				zap.String("path", r.URL.Path),
+				zap.String("client", key.Name),
+				zap.String("scope", scope))
+			g.sendEndpointError(w, r, http.StatusForbidden, fmt.Errorf("API key %q lacks the %s scope", key.Name, scope))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey{}, key)))
+	})
+}
+
+// sendEndpointError sends an error in the format of the endpoint the request called
+func (g *Gateway) sendEndpointError(w http.ResponseWriter, r *http.Request, statusCode int, err error) {
+	if strings.HasPrefix(r.URL.Path, "/v1/") {
+		g.sendOpenAIError(w, statusCode, err)
+		return
+	}
+	g.sendJSONResponse(w, map[string]string{"error": err.Error()}, statusCode)
+}
@@ -0,0 +1,95 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	if _, err := NewAPIKeys([]APIKey{{Name: "monitor", Key: "sk-monitor", Scopes: []string{"admin"}}}); err == nil {
+		t.Error("Expected an unknown scope to be rejected")
+	}
+	keys, err := NewAPIKeys([]APIKey{
+		{Name: "alice", Key: "sk-alice", Scopes: []string{ScopeChat}},
+		{Name: "bob", Key: "sk-bob", Scopes: []string{ScopeAll}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create API keys: %v", err)
+	}
+
+	g := &Gateway{logger: zap.NewNop(), apiKeys: keys}
+	var client *APIKey
+	handler := g.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client = ClientFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, tc := range []struct {
+		path, header string
+		status       int
+		client       string
+	}{
+		{"/api/chat", "", http.StatusUnauthorized, ""},
+		{"/v1/chat/completions", "Bearer sk-wrong", http.StatusUnauthorized, ""},
+		{"/api/chat", "Basic sk-alice", http.StatusUnauthorized, ""},
+		{"/api/chat", "Bearer sk-alice", http.StatusOK, "alice"},
+		{"/v1/chat/completions", "bearer sk-bob", http.StatusOK, "bob"},
+		{"/api/health", "", http.StatusOK, ""},
+	} {
+		client = nil
+		req := httptest.NewRequest(http.MethodPost, tc.path, http.NoBody)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tc.status {
+			t.Errorf("%s with %q: expected status %d, got %d", tc.path, tc.header, tc.status, rec.Code)
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s with %q: expected a WWW-Authenticate header", tc.path, tc.header)
+		}
+		var got string
+		if client != nil {
+			got = client.Name
+		}
+		if got != tc.client {
+			t.Errorf("%s with %q: expected client %q, got %q", tc.path, tc.header, tc.client, got)
+		}
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	if keys, err := LoadAPIKeys("", nil); err != nil || keys != nil {
+		t.Errorf("Expected no keys without configuration, got %v, %v", keys, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	data := `[{"name": "alice", "key": "sk-alice", "scopes": ["chat"]}]`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("Failed to write keys file: %v", err)
+	}
+	keys, err := LoadAPIKeys(path, []string{"ci=sk-ci"})
+	if err != nil {
+		t.Fatalf("Failed to load API keys: %v", err)
+	}
+	if keys.Len() != 2 {
+		t.Errorf("Expected 2 keys, got %d", keys.Len())
+	}
+	if key, err := keys.Authenticate("Bearer sk-ci"); err != nil || key.Name != "ci" || !key.Allows(ScopeChat) {
+		t.Errorf("Expected the inline key to authenticate with all scopes, got %+v, %v", key, err)
+	}
+
+	if _, err := LoadAPIKeys(path, []string{"alice=sk-other"}); err == nil {
+		t.Error("Expected duplicate key names to be rejected")
+	}
+	if _, err := LoadAPIKeys("", []string{"sk-no-name"}); err == nil {
+		t.Error("Expected an inline key without a name to be rejected")
+	}
+}
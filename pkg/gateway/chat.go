@@ -0,0 +1,279 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+
+	llamav1 "github.com/crowdllama/crowdllama-pb/llama/v1"
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+)
+
+const (
+	// WorkerHeader names the peer ID of the worker that produced a response
+	WorkerHeader = "X-CrowdLlama-Worker"
+
+	// NetworkDurationHeader reports, in nanoseconds, how much time the gateway and the network added on top
+	// of the worker's processing time
+	NetworkDurationHeader = "X-CrowdLlama-Network-Duration"
+
+	// StreamingHeader is set to "buffered" on streamed responses. Workers answer with complete responses, so a
+	// streamed response only starts once the whole message has been generated and carries it in one chunk.
+	StreamingHeader = "X-CrowdLlama-Streaming"
+)
+
+// chatResult is a worker response together with the worker it came from and the time the gateway waited for it
+type chatResult struct {
+	resp     *llamav1.GenerateResponse
+	workerID string
+	elapsed  time.Duration
+}
+
+// maxWorkerAttempts bounds how many workers a chat request is sent to when workers answer that they are
+// not accepting requests, e.g. because they are draining before a restart
+const maxWorkerAttempts = 3
+
+// chat routes a chat request to the best worker and returns its response. On failure it also returns the
+// HTTP status code describing the error.
+func (g *Gateway) chat(ctx context.Context, req *GenerateRequest) (*chatResult, int, error) {
+	pbReq, err := buildInferenceRequest(req)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	features := requiredFeatures(req)
+	for attempt := 1; ; attempt++ {
+		result, statusCode, err := g.chatWithBestWorker(ctx, req, pbReq, features)
+		if err == nil {
+			g.recordUsage(ctx, result.resp)
+		}
+		if !errors.Is(err, crowdllama.ErrWorkerUnavailable) || attempt == maxWorkerAttempts {
+			return result, statusCode, err
+		}
+		g.logger.Info("Retrying chat request on another worker", zap.String("model", req.Model), zap.Int("attempt", attempt+1))
+	}
+}
+
+// chatWithBestWorker sends a chat request to the best worker. If the worker is not accepting requests,
+// ErrWorkerUnavailable is returned so that the request can be retried on another worker.
+func (g *Gateway) chatWithBestWorker(
+	ctx context.Context,
+	req *GenerateRequest,
+	pbReq *llamav1.BaseMessage,
+	features []string,
+) (*chatResult, int, error) {
+	bestWorker := g.peer.PeerManager.FindBestWorkerWithFeatures(req.Model, features)
+	if bestWorker == nil {
+		g.logger.Error("Failed to find suitable worker", zap.String("model", req.Model), zap.Strings("required_features", features))
+		if g.FindBestWorker(req.Model) != nil {
+			return nil, http.StatusServiceUnavailable,
+				fmt.Errorf("no worker for model %s supports the required features: %v", req.Model, features)
+		}
+		g.hintModelDemand(req.Model)
+		return nil, http.StatusServiceUnavailable, errors.New("no suitable worker found")
+	}
+
+	// Log API message received
+	g.logger.Debug("Consumer API received inference request",
+		zap.String("model", req.Model),
+		zap.String("prompt", req.Messages[0].Content),
+		zap.Bool("stream", req.Stream),
+		zap.Int("messages", len(req.Messages)),
+		zap.Bool("tools", len(req.Tools) > 0),
+		zap.String("worker_id", bestWorker.PeerID))
+
+	start := time.Now()
+	pbResp, err := g.RequestInferenceMessage(ctx, bestWorker.PeerID, pbReq)
+	if errors.Is(err, crowdllama.ErrAccessDenied) {
+		statusCode, err := g.workerRejection(req.Model, bestWorker, crowdllama.NewInferenceError(err))
+		return nil, statusCode, err
+	}
+	if err != nil {
+		g.logger.Error("Failed to request inference", zap.Error(err))
+		if errors.Is(err, crowdllama.ErrMessageTooLarge) {
+			return nil, http.StatusRequestEntityTooLarge, err
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+	if inferenceErr := crowdllama.GetResponseError(pbResp); inferenceErr != nil {
+		statusCode, err := g.workerRejection(req.Model, bestWorker, inferenceErr)
+		return nil, statusCode, err
+	}
+	result := &chatResult{resp: pbResp, workerID: bestWorker.PeerID, elapsed: time.Since(start)}
+	g.settleReceipt(result.workerID, pbResp)
+
+	if reported := pbResp.GetWorkerId(); reported != "" && reported != result.workerID {
+		g.logger.Warn("Worker reported a different peer ID than the one the request was sent to",
+			zap.String("worker_id", result.workerID),
+			zap.String("reported_worker_id", reported))
+	}
+	g.logger.Info("Chat request served",
+		zap.String("model", req.Model),
+		zap.String("worker_id", result.workerID),
+		zap.Int32("prompt_tokens", pbResp.GetPromptEvalCount()),
+		zap.Int32("completion_tokens", pbResp.GetEvalCount()),
+		zap.Duration("elapsed", result.elapsed))
+	return result, http.StatusOK, nil
+}
+
+// workerRejection returns the status code and error for a request a worker refused. A worker that is not
+// accepting requests, or not from this gateway right now, is marked as paused until its next metadata update
+// says otherwise.
+func (g *Gateway) workerRejection(model string, worker *crowdllama.Resource, inferenceErr *crowdllama.InferenceError) (int, error) {
+	g.logger.Warn("Worker rejected inference request",
+		zap.String("model", model),
+		zap.String("worker_id", worker.PeerID),
+		zap.Int("code", inferenceErr.Code),
+		zap.String("error", inferenceErr.Message))
+	switch inferenceErr.Code {
+	case http.StatusForbidden:
+		// The worker may have restarted and forgotten the access token; present it again next time
+		if id, err := peer.Decode(worker.PeerID); err == nil {
+			g.accessGrants.forget(id)
+		}
+	case http.StatusServiceUnavailable, http.StatusTooManyRequests:
+	default:
+		return inferenceErr.HTTPStatus(), inferenceErr
+	}
+
+	paused := *worker
+	paused.Paused = true
+	g.peer.PeerManager.AddOrUpdatePeer(worker.PeerID, &paused)
+	return http.StatusServiceUnavailable, fmt.Errorf("%w: %s", crowdllama.ErrWorkerUnavailable, inferenceErr.Message)
+}
+
+// workerDuration returns the worker's processing time, or 0 if the worker did not report a usable one. Older
+// workers sent a timestamp in the total duration field, which is recognisable by exceeding the elapsed time.
+func (r *chatResult) workerDuration() time.Duration {
+	total := time.Duration(r.resp.GetTotalDuration())
+	if total <= 0 || total > r.elapsed {
+		return 0
+	}
+	return total
+}
+
+// networkDuration returns the time the gateway and the network added on top of the worker, or 0 if unknown
+func (r *chatResult) networkDuration() time.Duration {
+	worker := r.workerDuration()
+	if worker == 0 {
+		return 0
+	}
+	return r.elapsed - worker
+}
+
+// stats returns the statistics of the response in Ollama's format. The total duration is the worker's, so
+// together with the network duration it adds up to the time the gateway waited.
+func (r *chatResult) stats() GenerateStats {
+	total := r.workerDuration()
+	if total == 0 {
+		total = r.elapsed
+	}
+	return GenerateStats{
+		TotalDuration:      total.Nanoseconds(),
+		LoadDuration:       r.resp.GetLoadDuration(),
+		PromptEvalCount:    r.resp.GetPromptEvalCount(),
+		PromptEvalDuration: r.resp.GetPromptEvalDuration(),
+		EvalCount:          r.resp.GetEvalCount(),
+		EvalDuration:       r.resp.GetEvalDuration(),
+		NetworkDuration:    r.networkDuration().Nanoseconds(),
+	}
+}
+
+// setResultHeaders reports the worker that produced a response and, if known, the gateway and network overhead
+func setResultHeaders(w http.ResponseWriter, result *chatResult) {
+	w.Header().Set(WorkerHeader, result.workerID)
+	if network := result.networkDuration(); network > 0 {
+		w.Header().Set(NetworkDurationHeader, strconv.FormatInt(network.Nanoseconds(), 10))
+	}
+}
+
+// buildInferenceRequest converts a chat request to a PB request. The first message travels in the prompt so
+// older workers can still answer; conversations and tool definitions are attached for workers that use them.
+func buildInferenceRequest(req *GenerateRequest) (*llamav1.BaseMessage, error) {
+	messages := make([]crowdllama.ChatMessage, 0, len(req.Messages))
+	for i, msg := range req.Messages {
+		images, err := decodeImages(msg.Images)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image in message %d: %w", i, err)
+		}
+		messages = append(messages, crowdllama.ChatMessage{
+			Role:      msg.Role,
+			Content:   msg.Content,
+			Images:    images,
+			ToolCalls: msg.ToolCalls,
+			ToolName:  msg.ToolName,
+		})
+	}
+
+	pbReq := crowdllama.CreateGenerateRequestWithImages(req.Model, messages[0].Content, messages[0].Images, req.Stream)
+	generateReq := pbReq.GetGenerateRequest()
+	if len(req.Tools) > 0 {
+		crowdllama.SetRequestTools(generateReq, req.Tools)
+	}
+	if len(messages) > 1 || len(req.Tools) > 0 {
+		if err := crowdllama.SetRequestMessages(generateReq, messages); err != nil {
+			return nil, fmt.Errorf("encode conversation: %w", err)
+		}
+	}
+	if options := req.modelOptions(); len(options) > 0 {
+		if err := crowdllama.SetRequestOptions(generateReq, options); err != nil {
+			return nil, fmt.Errorf("encode options: %w", err)
+		}
+	}
+	return pbReq, nil
+}
+
+// requiredFeatures returns the worker features needed to serve a chat request. The gateway relays a
+// single response per request, so streaming is not required even when the client asks for it.
+func requiredFeatures(req *GenerateRequest) []string {
+	features := []string{crowdllama.FeatureChat}
+	usesTools := len(req.Tools) > 0
+	hasImages := false
+	for _, msg := range req.Messages {
+		usesTools = usesTools || len(msg.ToolCalls) > 0 || msg.Role == "tool"
+		hasImages = hasImages || len(msg.Images) > 0
+	}
+	if usesTools {
+		features = append(features, crowdllama.FeatureTools)
+	}
+	if hasImages {
+		features = append(features, crowdllama.FeatureVision)
+	}
+	if len(req.modelOptions()) > 0 {
+		features = append(features, crowdllama.FeatureOptions)
+	}
+	return features
+}
+
+// streamOllamaResponse writes a complete response in Ollama's streaming format: a chunk with the whole
+// message followed by a final chunk that carries the done reason and statistics. It is not incremental.
+func (g *Gateway) streamOllamaResponse(w http.ResponseWriter, resp *GenerateResponse) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set(StreamingHeader, "buffered")
+	w.WriteHeader(http.StatusOK)
+
+	chunk := *resp
+	chunk.Done = false
+	chunk.DoneReason = ""
+	chunk.GenerateStats = GenerateStats{}
+	final := *resp
+	final.Message = Message{Role: resp.Message.Role}
+
+	encoder := json.NewEncoder(w)
+	for _, part := range []*GenerateResponse{&chunk, &final} {
+		if err := encoder.Encode(part); err != nil {
+			g.logger.Error("Failed to encode streamed response", zap.Error(err))
+			return
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+}
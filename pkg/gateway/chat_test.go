@@ -0,0 +1,241 @@
+package gateway
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	llamav1 "github.com/crowdllama/crowdllama-pb/llama/v1"
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+	peerpkg "github.com/crowdllama/crowdllama/pkg/peer"
+	"github.com/crowdllama/crowdllama/pkg/peermanager"
+)
+
+func TestBuildInferenceRequestWithTools(t *testing.T) {
+	var req GenerateRequest
+	body := `{"model":"llama3.1","tools":[{"type":"function","function":{"name":"get_weather"}}],"messages":[
+		{"role":"user","content":"What is the weather in Paris?"},
+		{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function",
+			"function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},
+		{"role":"tool","content":"22 degrees","tool_name":"get_weather"}
+	]}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("Failed to decode request: %v", err)
+	}
+
+	features := requiredFeatures(&req)
+	if !slices.Contains(features, crowdllama.FeatureTools) {
+		t.Errorf("Expected the tools feature to be required, got %v", features)
+	}
+
+	pbReq, err := buildInferenceRequest(&req)
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	generateReq := pbReq.GetGenerateRequest()
+	if generateReq.GetPrompt() != "What is the weather in Paris?" {
+		t.Errorf("Expected the first message in the prompt, got %q", generateReq.GetPrompt())
+	}
+	if len(crowdllama.GetRequestTools(generateReq)) == 0 {
+		t.Error("Expected tools to be attached")
+	}
+	messages := crowdllama.GetRequestMessages(generateReq)
+	if len(messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(messages))
+	}
+	if args := string(messages[1].ToolCalls[0].Function.Arguments); args != `{"city":"Paris"}` {
+		t.Errorf("Expected OpenAI arguments to be decoded to an object, got %s", args)
+	}
+	if messages[2].ToolName != "get_weather" {
+		t.Errorf("Expected tool name on the tool message, got %q", messages[2].ToolName)
+	}
+}
+
+func TestBuildInferenceRequestWithOptions(t *testing.T) {
+	var req GenerateRequest
+	body := `{"model":"llama3.2","max_tokens":256,"temperature":0.7,"options":{"num_ctx":8192,"temperature":0.2},
+		"messages":[{"role":"user","content":"hello"}]}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("Failed to decode request: %v", err)
+	}
+
+	if features := requiredFeatures(&req); !slices.Contains(features, crowdllama.FeatureOptions) {
+		t.Errorf("Expected the options feature to be required, got %v", features)
+	}
+	pbReq, err := buildInferenceRequest(&req)
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	options, err := crowdllama.GetRequestOptions(pbReq.GetGenerateRequest())
+	if err != nil {
+		t.Fatalf("Failed to get options: %v", err)
+	}
+	if numPredict, _ := crowdllama.IntOption(options, "num_predict"); numPredict != 256 {
+		t.Errorf("Expected max_tokens to map to num_predict, got %v", options["num_predict"])
+	}
+	if options["temperature"] != 0.2 {
+		t.Errorf("Expected explicit options to take precedence, got %v", options["temperature"])
+	}
+
+	plain := GenerateRequest{Model: "llama3.2", Messages: []Message{{Role: "user", Content: "hello"}}}
+	if features := requiredFeatures(&plain); slices.Contains(features, crowdllama.FeatureOptions) {
+		t.Errorf("Expected no options feature for a plain request, got %v", features)
+	}
+}
+
+func TestOpenAIResponseWithToolCalls(t *testing.T) {
+	g := &Gateway{logger: zap.NewNop()}
+	pbResp := &llamav1.GenerateResponse{
+		Model:           "llama3.1",
+		CreatedAt:       timestamppb.Now(),
+		Done:            true,
+		DoneReason:      "stop",
+		PromptEvalCount: 12,
+		EvalCount:       7,
+	}
+	toolCalls := []crowdllama.ToolCall{
+		{Function: crowdllama.ToolCallFunction{Name: "get_weather", Arguments: json.RawMessage(`{"city":"Paris"}`)}},
+	}
+	if err := crowdllama.SetResponseToolCalls(pbResp, toolCalls); err != nil {
+		t.Fatalf("Failed to set tool calls: %v", err)
+	}
+
+	resp := g.newOpenAIChatResponse(&GenerateRequest{Model: "llama3.1"}, pbResp)
+	choice := resp.Choices[0]
+	if *choice.FinishReason != finishReasonToolCalls {
+		t.Errorf("Expected finish reason %q, got %q", finishReasonToolCalls, *choice.FinishReason)
+	}
+	if len(choice.Message.ToolCalls) != 1 || choice.Message.ToolCalls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("Unexpected tool calls: %+v", choice.Message.ToolCalls)
+	}
+
+	if resp.Usage == nil || resp.Usage.TotalTokens != 19 {
+		t.Errorf("Expected usage with 19 total tokens, got %+v", resp.Usage)
+	}
+
+	recorder := httptest.NewRecorder()
+	g.streamOpenAIResponse(recorder, resp)
+	if got := recorder.Header().Get(StreamingHeader); got != "buffered" {
+		t.Errorf("Expected streamed response to be marked as buffered, got %q", got)
+	}
+	var events []string
+	scanner := bufio.NewScanner(recorder.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			events = append(events, data)
+		}
+	}
+	if len(events) != 3 || events[2] != "[DONE]" {
+		t.Fatalf("Expected two chunks and [DONE], got %v", events)
+	}
+	var chunk openAIChatResponse
+	if err := json.Unmarshal([]byte(events[0]), &chunk); err != nil {
+		t.Fatalf("Failed to decode chunk: %v", err)
+	}
+	delta := chunk.Choices[0].Delta
+	if chunk.Object != "chat.completion.chunk" || len(delta.ToolCalls) != 1 || delta.ToolCalls[0].Index == nil {
+		t.Errorf("Unexpected first chunk: %s", events[0])
+	}
+}
+
+func TestChatResultStats(t *testing.T) {
+	result := &chatResult{
+		resp:    &llamav1.GenerateResponse{TotalDuration: int64(80 * time.Millisecond), EvalCount: 7},
+		elapsed: 100 * time.Millisecond,
+	}
+	stats := result.stats()
+	if stats.TotalDuration != int64(80*time.Millisecond) || stats.NetworkDuration != int64(20*time.Millisecond) {
+		t.Errorf("Expected 80ms on the worker and 20ms of network, got %+v", stats)
+	}
+	if stats.EvalCount != 7 {
+		t.Errorf("Expected eval count 7, got %d", stats.EvalCount)
+	}
+
+	// Older workers sent a timestamp instead of a duration
+	legacy := &chatResult{
+		resp:    &llamav1.GenerateResponse{TotalDuration: time.Now().UnixNano()},
+		elapsed: 100 * time.Millisecond,
+	}
+	stats = legacy.stats()
+	if stats.TotalDuration != int64(100*time.Millisecond) || stats.NetworkDuration != 0 {
+		t.Errorf("Expected the elapsed time and no network duration for a legacy worker, got %+v", stats)
+	}
+}
+
+// recordingPeerManager records the metadata the gateway stores for peers
+type recordingPeerManager struct {
+	peermanager.I
+	updated map[string]*crowdllama.Resource
+}
+
+func (m *recordingPeerManager) AddOrUpdatePeer(peerID string, metadata *crowdllama.Resource) {
+	m.updated[peerID] = metadata
+}
+
+func TestWorkerRejection(t *testing.T) {
+	manager := &recordingPeerManager{updated: make(map[string]*crowdllama.Resource)}
+	g := &Gateway{logger: zap.NewNop(), peer: &peerpkg.Peer{PeerManager: manager}}
+	worker := &crowdllama.Resource{PeerID: "worker-1", WorkerMode: true}
+
+	refused := &crowdllama.InferenceError{Code: http.StatusBadRequest, Message: "num_ctx too large"}
+	statusCode, err := g.workerRejection("llama3.2", worker, refused)
+	if statusCode != http.StatusBadRequest || errors.Is(err, crowdllama.ErrWorkerUnavailable) {
+		t.Errorf("Expected a policy error to be returned as is, got %d %v", statusCode, err)
+	}
+	if len(manager.updated) != 0 {
+		t.Error("Expected a worker refusing a bad request to stay available")
+	}
+
+	draining := &crowdllama.InferenceError{Code: http.StatusServiceUnavailable, Message: "draining"}
+	statusCode, err = g.workerRejection("llama3.2", worker, draining)
+	if statusCode != http.StatusServiceUnavailable || !errors.Is(err, crowdllama.ErrWorkerUnavailable) {
+		t.Errorf("Expected a retryable error for a draining worker, got %d %v", statusCode, err)
+	}
+	if paused := manager.updated["worker-1"]; paused == nil || !paused.Paused || worker.Paused {
+		t.Errorf("Expected a paused copy of the worker to be stored, got %+v", paused)
+	}
+
+	throttled := crowdllama.NewInferenceError(crowdllama.ErrRateLimited)
+	if _, err = g.workerRejection("llama3.2", worker, throttled); !errors.Is(err, crowdllama.ErrWorkerUnavailable) {
+		t.Errorf("Expected a retryable error for a worker rate limiting the gateway, got %v", err)
+	}
+
+	// A worker that no longer honours the gateway's access token is skipped and gets the token again later
+	restricted := &crowdllama.Resource{PeerID: "12D3KooWDpJ7As7BWAwRMfu1VU2WCqNjvq387JEYKDBj4kx6nXTN", WorkerMode: true}
+	restrictedID, err := peer.Decode(restricted.PeerID)
+	if err != nil {
+		t.Fatalf("Failed to decode peer ID: %v", err)
+	}
+	g.accessGrants.record(restrictedID, time.Now().Add(time.Hour))
+	denied := crowdllama.NewInferenceError(crowdllama.ErrAccessDenied)
+	if _, err = g.workerRejection("llama3.2", restricted, denied); !errors.Is(err, crowdllama.ErrWorkerUnavailable) {
+		t.Errorf("Expected a retryable error for a worker denying access, got %v", err)
+	}
+	if g.accessGrants.valid(restrictedID, time.Now()) {
+		t.Error("Expected the access grant of the worker to be forgotten")
+	}
+}
+
+func TestChatRequestBodyLimit(t *testing.T) {
+	g := &Gateway{logger: zap.NewNop(), peer: &peerpkg.Peer{FrameOptions: crowdllama.FrameOptions{MaxSize: 1024}}}
+	content := strings.Repeat("a", 2*requestBodySlack)
+	body := `{"model":"llama3.2","messages":[{"role":"user","content":"` + content + `"}]}`
+
+	for path, handler := range map[string]http.HandlerFunc{"/api/chat": g.handleChat, "/v1/chat/completions": g.handleChatCompletions} {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected %s to answer 413 for an oversized body, got %d", path, rec.Code)
+		}
+	}
+}
@@ -4,9 +4,12 @@ package gateway
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"net/http"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
@@ -14,6 +17,9 @@ import (
 
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
 
 	llamav1 "github.com/crowdllama/crowdllama-pb/llama/v1"
 	"github.com/crowdllama/crowdllama/internal/discovery"
@@ -27,17 +33,58 @@ const DefaultHTTPPort = 9001
 // DiscoveryInterval is the interval for worker discovery
 const DiscoveryInterval = 10 * time.Second
 
+// directDialTimeout bounds how long the gateway tries to reach a worker directly before using a relay
+const directDialTimeout = 3 * time.Second
+
+// requestBodySlack is added to the request body limit for JSON syntax and fields that are not sent to the worker
+const requestBodySlack = 1024 * 1024
+
 // GenerateRequest represents the JSON request structure for the /api/chat endpoint
 type GenerateRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
-	Stream   bool      `json:"stream"`
+	Model    string          `json:"model"`
+	Messages []Message       `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Tools    json.RawMessage `json:"tools,omitempty"`   // tool definitions, passed through to the worker unchanged
+	Options  map[string]any  `json:"options,omitempty"` // model options in Ollama's format, e.g. num_ctx and num_predict
+
+	// OpenAI sampling parameters, sent to the worker as the equivalent options
+	MaxTokens           *int     `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int     `json:"max_completion_tokens,omitempty"`
+	Temperature         *float64 `json:"temperature,omitempty"`
+	TopP                *float64 `json:"top_p,omitempty"`
+	Seed                *int     `json:"seed,omitempty"`
+}
+
+// modelOptions returns the model options of the request, with OpenAI sampling parameters mapped to their
+// Ollama names. Explicit options take precedence.
+func (r *GenerateRequest) modelOptions() map[string]any {
+	options := make(map[string]any, len(r.Options)+4)
+	switch {
+	case r.MaxCompletionTokens != nil:
+		options["num_predict"] = *r.MaxCompletionTokens
+	case r.MaxTokens != nil:
+		options["num_predict"] = *r.MaxTokens
+	}
+	if r.Temperature != nil {
+		options["temperature"] = *r.Temperature
+	}
+	if r.TopP != nil {
+		options["top_p"] = *r.TopP
+	}
+	if r.Seed != nil {
+		options["seed"] = *r.Seed
+	}
+	maps.Copy(options, r.Options)
+	return options
 }
 
 // Message represents a message sent between gateway and worker
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role      string                `json:"role"`
+	Content   string                `json:"content"`
+	Images    []string              `json:"images,omitempty"`     // base64 encoded images for vision models
+	ToolCalls []crowdllama.ToolCall `json:"tool_calls,omitempty"` // tools the assistant asked to call
+	ToolName  string                `json:"tool_name,omitempty"`  // name of the tool whose result a tool message carries
 }
 
 // GenerateResponse represents the JSON response structure for the /api/chat endpoint
@@ -48,6 +95,19 @@ type GenerateResponse struct {
 	Stream     bool      `json:"stream"`
 	DoneReason string    `json:"done_reason"`
 	Done       bool      `json:"done"`
+	GenerateStats
+}
+
+// GenerateStats are the timing and token statistics of a response, in Ollama's format. Durations are in
+// nanoseconds.
+type GenerateStats struct {
+	TotalDuration      int64 `json:"total_duration,omitempty"`
+	LoadDuration       int64 `json:"load_duration,omitempty"`
+	PromptEvalCount    int32 `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration int64 `json:"prompt_eval_duration,omitempty"`
+	EvalCount          int32 `json:"eval_count,omitempty"`
+	EvalDuration       int64 `json:"eval_duration,omitempty"`
+	NetworkDuration    int64 `json:"network_duration,omitempty"` // time the gateway and the network added on top of the worker
 }
 
 // Gateway handles HTTP API requests and forwards them to workers in the P2P network
@@ -58,6 +118,13 @@ type Gateway struct {
 	discoveryCtx    context.Context
 	discoveryCancel context.CancelFunc
 	apiHandler      crowdllama.UnifiedAPIHandler
+	sessions        *sessionPool
+	modelHints      modelHints
+	apiKeys         *APIKeys // keys clients must authenticate with; nil serves every client
+	limits          *limiter
+	accessToken     string // presented to workers that restrict inference; empty presents none
+	accessGrants    accessGrants
+	prepaidCredits  map[peer.ID]int64 // tokens paid to workers in advance, drawn down by their receipts
 }
 
 // NewGateway creates a new gateway instance using an existing Peer
@@ -73,7 +140,10 @@ func NewGateway(
 		discoveryCtx:    discoveryCtx,
 		discoveryCancel: discoveryCancel,
 		apiHandler:      crowdllama.DefaultAPIHandler,
+		limits:          newLimiter(Limits{}),
 	}
+	g.sessions = newSessionPool(logger, p.FrameOptions, g.openSessionStream)
+	g.setupLedger()
 	return g, nil
 }
 
@@ -85,10 +155,11 @@ func (g *Gateway) StartHTTPServer(port int) error {
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/chat", g.handleChat)
+	mux.HandleFunc("/v1/chat/completions", g.handleChatCompletions)
 	mux.HandleFunc("/api/health", g.handleHealth)
 
-	// Wrap the mux with logging middleware
-	loggedMux := g.loggingMiddleware(mux)
+	// Wrap the mux with client limits, authentication, then logging, so rejected requests are logged too
+	loggedMux := g.loggingMiddleware(g.authMiddleware(g.limitMiddleware(mux)))
 
 	g.server = &http.Server{
 		Addr:              fmt.Sprintf(":%d", port),
@@ -96,6 +167,9 @@ func (g *Gateway) StartHTTPServer(port int) error {
 		ReadHeaderTimeout: 30 * time.Second,
 	}
 
+	if g.apiKeys == nil {
+		g.logger.Warn("Gateway accepts requests without an API key, do not expose it beyond localhost")
+	}
 	g.logger.Info("Starting HTTP server", zap.Int("port", port))
 	if err := g.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("listen and serve: %w", err)
@@ -153,8 +227,16 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return n, nil
 }
 
+// Flush sends buffered data to the client so streamed responses are not held back by the wrapper
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 // StopHTTPServer gracefully stops the HTTP server
 func (g *Gateway) StopHTTPServer(ctx context.Context) error {
+	defer g.sessions.closeAll()
 	if g.server != nil {
 		g.logger.Info("Stopping HTTP server")
 		if err := g.server.Shutdown(ctx); err != nil {
@@ -173,9 +255,8 @@ func (g *Gateway) handleChat(w http.ResponseWriter, r *http.Request) {
 
 	// Parse the request (JSON to PB)
 	var req GenerateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		g.logger.Error("Failed to decode request", zap.Error(err))
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if statusCode, err := g.decodeChatRequest(w, r, &req); err != nil {
+		http.Error(w, err.Error(), statusCode)
 		return
 	}
 	if req.Model == "" {
@@ -187,29 +268,9 @@ func (g *Gateway) handleChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := r.Context()
-	bestWorker := g.FindBestWorker(req.Model)
-	if bestWorker == nil {
-		g.logger.Error("Failed to find suitable worker")
-		w.WriteHeader(http.StatusServiceUnavailable)
-		if err := json.NewEncoder(w).Encode(map[string]string{"error": "No suitable worker found"}); err != nil {
-			g.logger.Error("Failed to encode error response", zap.Error(err))
-		}
-		return
-	}
-
-	// Log API message received
-	g.logger.Debug("Consumer API received inference request",
-		zap.String("model", req.Model),
-		zap.String("prompt", req.Messages[0].Content),
-		zap.Bool("stream", req.Stream),
-		zap.String("worker_id", bestWorker.PeerID))
-
-	// Use PB-based RequestInference
-	pbResp, err := g.RequestInference(ctx, bestWorker.PeerID, req.Model, req.Messages[0].Content, req.Stream)
+	result, statusCode, err := g.chat(r.Context(), &req)
 	if err != nil {
-		g.logger.Error("Failed to request inference", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(statusCode)
 		if encodeErr := json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}); encodeErr != nil {
 			g.logger.Error("Failed to encode error response", zap.Error(encodeErr))
 		}
@@ -217,15 +278,27 @@ func (g *Gateway) handleChat(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Convert PB GenerateResponse to HTTP JSON response
+	pbResp := result.resp
+	toolCalls, err := crowdllama.GetResponseToolCalls(pbResp)
+	if err != nil {
+		g.logger.Warn("Dropping malformed tool calls from worker response", zap.Error(err))
+	}
 	generateResponse := GenerateResponse{
 		Model:     pbResp.GetModel(),
 		CreatedAt: pbResp.GetCreatedAt().AsTime(),
 		Message: Message{
-			Role:    "assistant",
-			Content: pbResp.GetResponse(),
+			Role:      "assistant",
+			Content:   pbResp.GetResponse(),
+			ToolCalls: toolCalls,
 		},
-		Done:       pbResp.GetDone(),
-		DoneReason: pbResp.GetDoneReason(),
+		Done:          pbResp.GetDone(),
+		DoneReason:    pbResp.GetDoneReason(),
+		GenerateStats: result.stats(),
+	}
+	setResultHeaders(w, result)
+	if req.Stream {
+		g.streamOllamaResponse(w, &generateResponse)
+		return
 	}
 	g.sendJSONResponse(w, generateResponse, http.StatusOK)
 }
@@ -241,39 +314,57 @@ func (g *Gateway) sendJSONResponse(w http.ResponseWriter, response interface{},
 
 // RequestInference sends an inference request to a specific worker using PB messages
 func (g *Gateway) RequestInference(ctx context.Context, workerID, model, prompt string, stream bool) (*llamav1.GenerateResponse, error) {
+	return g.RequestInferenceWithImages(ctx, workerID, model, prompt, nil, stream)
+}
+
+// RequestInferenceWithImages sends an inference request with attached images to a specific worker
+func (g *Gateway) RequestInferenceWithImages(
+	ctx context.Context,
+	workerID, model, prompt string,
+	images [][]byte,
+	stream bool,
+) (*llamav1.GenerateResponse, error) {
+	return g.RequestInferenceMessage(ctx, workerID, crowdllama.CreateGenerateRequestWithImages(model, prompt, images, stream))
+}
+
+// RequestInferenceMessage sends a prepared generate request to a specific worker
+func (g *Gateway) RequestInferenceMessage(
+	ctx context.Context,
+	workerID string,
+	pbReq *llamav1.BaseMessage,
+) (*llamav1.GenerateResponse, error) {
 	pid, err := peer.Decode(workerID)
 	if err != nil {
 		return nil, fmt.Errorf("invalid worker peer ID: %w", err)
 	}
-	peerInfo, err := g.peer.DHT.FindPeer(ctx, pid)
-	if err != nil {
-		return nil, fmt.Errorf("could not find worker peer: %w", err)
+	if err := g.ensureWorkerAddrs(ctx, pid); err != nil {
+		return nil, err
 	}
-	streamObj, err := g.peer.Host.NewStream(ctx, peerInfo.ID, crowdllama.InferenceProtocol)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open stream: %w", err)
+	if err := g.ensureAccess(ctx, pid); err != nil {
+		return nil, err
 	}
-	defer func() {
-		if closeErr := streamObj.Close(); closeErr != nil {
-			g.logger.Warn("failed to close stream", zap.Error(closeErr))
-		}
-	}()
 
-	// Create PB request
-	pbReq := crowdllama.CreateGenerateRequest(model, prompt, stream)
+	generateReq := pbReq.GetGenerateRequest()
 	g.logger.Debug("Consumer sending inference request to network",
-		zap.String("model", model),
-		zap.String("prompt", prompt),
+		zap.String("model", generateReq.GetModel()),
+		zap.String("prompt", generateReq.GetPrompt()),
+		zap.Int("images", len(crowdllama.GetRequestImages(generateReq))),
+		zap.Int("messages", len(crowdllama.GetRequestMessages(generateReq))),
 		zap.String("worker_id", workerID),
-		zap.Bool("stream", stream))
-	if writeErr := g.writePBMessage(streamObj, pbReq); writeErr != nil {
-		return nil, fmt.Errorf("failed to write PB request: %w", writeErr)
+		zap.Bool("stream", generateReq.GetStream()))
+
+	if err := g.checkRequestSize(workerID, pbReq); err != nil {
+		return nil, err
 	}
 
-	g.logger.Debug("Waiting for PB response from worker...")
-	pbResp, err := g.readPBMessage(streamObj)
+	var pbResp *llamav1.BaseMessage
+	if g.workerSupportsSessions(workerID) {
+		pbResp, err = g.requestOverSession(ctx, pid, pbReq)
+	} else {
+		pbResp, err = g.requestOverStream(ctx, pid, pbReq)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to read PB response: %w", err)
+		return nil, err
 	}
 
 	generateResp, err := crowdllama.ExtractGenerateResponse(pbResp)
@@ -292,9 +383,171 @@ func (g *Gateway) RequestInference(ctx context.Context, workerID, model, prompt
 	return generateResp, nil
 }
 
+// ensureWorkerAddrs makes sure the worker can be dialed. Addresses already in the peerstore, e.g. from
+// discovery or an earlier request, are reused so the DHT is only queried for unknown workers.
+func (g *Gateway) ensureWorkerAddrs(ctx context.Context, workerID peer.ID) error {
+	if g.peer.Host.Network().Connectedness(workerID) == network.Connected || len(g.peer.Host.Peerstore().Addrs(workerID)) > 0 {
+		return nil
+	}
+
+	peerInfo, err := g.peer.DHT.FindPeer(ctx, workerID)
+	if err != nil {
+		return fmt.Errorf("could not find worker peer: %w", err)
+	}
+	g.peer.Host.Peerstore().AddAddrs(peerInfo.ID, peerInfo.Addrs, peerstore.TempAddrTTL)
+	return nil
+}
+
+// decodeChatRequest decodes a chat request body, refusing bodies larger than any message the gateway could
+// send. Images are base64 encoded in JSON, so the body may be a third larger than the message.
+func (g *Gateway) decodeChatRequest(w http.ResponseWriter, r *http.Request, req *GenerateRequest) (int, error) {
+	limit := int64(g.peer.FrameOptions.Limit())*4/3 + requestBodySlack
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		g.logger.Error("Failed to decode request", zap.Error(err))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return http.StatusRequestEntityTooLarge, fmt.Errorf("%w: request body exceeds %d bytes", crowdllama.ErrMessageTooLarge, limit)
+		}
+		return http.StatusBadRequest, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return http.StatusOK, nil
+}
+
+// checkRequestSize rejects requests larger than the local limit or the limit the worker advertised, so an
+// oversized request fails with a clear error instead of being dropped by the worker
+func (g *Gateway) checkRequestSize(workerID string, pbReq *llamav1.BaseMessage) error {
+	size := proto.Size(pbReq)
+	if limit := g.peer.FrameOptions.Limit(); size > limit {
+		return fmt.Errorf("%w: request is %d bytes, the gateway accepts at most %d bytes", crowdllama.ErrMessageTooLarge, size, limit)
+	}
+
+	info, ok := g.peer.PeerManager.GetAllPeers()[workerID]
+	if ok && info.Metadata != nil && info.Metadata.MaxMessageSize > 0 && size > info.Metadata.MaxMessageSize {
+		return fmt.Errorf("%w: request is %d bytes, worker %s accepts at most %d bytes",
+			crowdllama.ErrMessageTooLarge, size, workerID, info.Metadata.MaxMessageSize)
+	}
+	return nil
+}
+
+// workerSupportsSessions returns true if the worker advertised a version of the inference session protocol
+func (g *Gateway) workerSupportsSessions(workerID string) bool {
+	info, ok := g.peer.PeerManager.GetAllPeers()[workerID]
+	if !ok || info.Metadata == nil {
+		return false
+	}
+	for _, id := range crowdllama.InferenceSessionProtocols() {
+		if info.Metadata.SupportsProtocol(string(id)) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestOverSession sends a request on the shared session to the worker. A session that ended before the
+// request was answered, e.g. because the worker closed it while idle, is replaced once.
+func (g *Gateway) requestOverSession(ctx context.Context, workerID peer.ID, pbReq *llamav1.BaseMessage) (*llamav1.BaseMessage, error) {
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		session, err := g.sessions.get(ctx, workerID)
+		if err != nil {
+			return nil, err
+		}
+
+		pbResp, err := session.roundTrip(ctx, pbReq)
+		if err == nil {
+			return pbResp, nil
+		}
+		if !errors.Is(err, errSessionClosed) {
+			return nil, fmt.Errorf("failed to get PB response: %w", err)
+		}
+		lastErr = err
+		g.logger.Debug("Inference session ended, retrying on a new session",
+			zap.String("worker_id", workerID.String()),
+			zap.Error(err))
+	}
+	return nil, fmt.Errorf("failed to get PB response: %w", lastErr)
+}
+
+// requestOverStream sends a request on a dedicated stream, for workers without session support
+func (g *Gateway) requestOverStream(ctx context.Context, workerID peer.ID, pbReq *llamav1.BaseMessage) (*llamav1.BaseMessage, error) {
+	streamObj, err := g.openInferenceStream(ctx, workerID, crowdllama.InferenceProtocols()...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := streamObj.Close(); closeErr != nil {
+			g.logger.Warn("failed to close stream", zap.Error(closeErr))
+		}
+	}()
+
+	if writeErr := g.writePBMessage(streamObj, pbReq); writeErr != nil {
+		return nil, fmt.Errorf("failed to write PB request: %w", writeErr)
+	}
+
+	g.logger.Debug("Waiting for PB response from worker...")
+	pbResp, err := g.readPBMessage(streamObj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PB response: %w", err)
+	}
+	return pbResp, nil
+}
+
+// openSessionStream opens the stream backing an inference session
+func (g *Gateway) openSessionStream(ctx context.Context, workerID peer.ID) (network.Stream, error) {
+	return g.openInferenceStream(ctx, workerID, crowdllama.InferenceSessionProtocols()...)
+}
+
+// openInferenceStream opens an inference stream to a worker, preferring a direct connection. An existing
+// connection is reused. Workers that are only reachable through a relay are dialed over it right away; others
+// are used over a relay only when they cannot be dialed directly. The relayed connection also lets the worker
+// start hole punching so later requests can go direct.
+func (g *Gateway) openInferenceStream(ctx context.Context, workerID peer.ID, protocols ...protocol.ID) (network.Stream, error) {
+	if len(g.peer.Host.Network().ConnsToPeer(workerID)) > 0 || g.relayOnly(workerID) {
+		streamObj, err := g.peer.Host.NewStream(network.WithAllowLimitedConn(ctx, "inference"), workerID, protocols...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open stream: %w", err)
+		}
+		return streamObj, nil
+	}
+
+	directCtx, cancel := context.WithTimeout(ctx, directDialTimeout)
+	defer cancel()
+
+	streamObj, err := g.peer.Host.NewStream(network.WithForceDirectDial(directCtx, "inference"), workerID, protocols...)
+	if err == nil {
+		return streamObj, nil
+	}
+	g.logger.Debug("Direct connection to worker failed, trying relay",
+		zap.String("worker_id", workerID.String()),
+		zap.Error(err))
+
+	streamObj, relayErr := g.peer.Host.NewStream(network.WithAllowLimitedConn(ctx, "inference"), workerID, protocols...)
+	if relayErr != nil {
+		return nil, fmt.Errorf("failed to open stream (direct: %v): %w", err, relayErr)
+	}
+	g.logger.Info("Using relayed connection to worker", zap.String("worker_id", workerID.String()))
+	return streamObj, nil
+}
+
+// relayOnly returns true if the worker reported that it is not publicly reachable or all its known addresses
+// are relay addresses
+func (g *Gateway) relayOnly(workerID peer.ID) bool {
+	if info, ok := g.peer.PeerManager.GetAllPeers()[workerID.String()]; ok && info.Metadata != nil && info.Metadata.IsPrivate() {
+		return true
+	}
+	addrs := g.peer.Host.Peerstore().Addrs(workerID)
+	for _, addr := range addrs {
+		if _, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT); err != nil {
+			return false
+		}
+	}
+	return len(addrs) > 0
+}
+
 // writePBMessage writes a length-prefixed protobuf message to a network stream
 func (g *Gateway) writePBMessage(s network.Stream, msg *llamav1.BaseMessage) error {
-	if err := crowdllama.WriteLengthPrefixedPB(s, msg); err != nil {
+	if err := crowdllama.WriteLengthPrefixedPBWithOptions(s, msg, g.peer.FrameOptions.ForProtocol(string(s.Protocol()))); err != nil {
 		return fmt.Errorf("failed to write length-prefixed PB message: %w", err)
 	}
 	g.logger.Debug("Gateway sent PB request", zap.Int("bytes", proto.Size(msg)))
@@ -303,7 +556,7 @@ func (g *Gateway) writePBMessage(s network.Stream, msg *llamav1.BaseMessage) err
 
 // readPBMessage reads a length-prefixed protobuf message from a network stream
 func (g *Gateway) readPBMessage(s network.Stream) (*llamav1.BaseMessage, error) {
-	msg, err := crowdllama.ReadLengthPrefixedPB(s)
+	msg, err := crowdllama.ReadLengthPrefixedPBWithOptions(s, g.peer.FrameOptions.ForProtocol(string(s.Protocol())))
 	if err != nil {
 		return nil, fmt.Errorf("failed to read length-prefixed PB message: %w", err)
 	}
@@ -331,15 +584,9 @@ func (g *Gateway) GetAvailablePeers() map[string]*crowdllama.Resource {
 	return result
 }
 
-// GetAvailableWorkers returns only worker peers
+// GetAvailableWorkers returns the worker peers that accept requests
 func (g *Gateway) GetAvailableWorkers() map[string]*crowdllama.Resource {
-	result := make(map[string]*crowdllama.Resource)
-	for peerID, info := range g.peer.PeerManager.GetHealthyPeers() {
-		if info.Metadata != nil && info.Metadata.WorkerMode {
-			result[peerID] = info.Metadata
-		}
-	}
-	return result
+	return g.peer.PeerManager.GetAvailableWorkers()
 }
 
 // FindBestWorker finds the best available worker for a specific model
@@ -379,47 +626,52 @@ func (g *Gateway) StartBackgroundDiscovery() {
 	}()
 }
 
-// runDiscovery performs a single discovery run and updates the worker map
+// runDiscovery performs a single discovery run and updates the worker map as peers are found
 func (g *Gateway) runDiscovery() {
-	ctx, cancel := context.WithTimeout(g.discoveryCtx, 10*time.Second)
-	defer cancel()
+	var updatedCount, skippedCount atomic.Int32
+	opts := discovery.DiscoveryOptions{
+		MaxProviders: g.peer.Config.MaxProviders,
+		OnPeer: func(peer *crowdllama.Resource) {
+			if g.addDiscoveredPeer(peer) {
+				updatedCount.Add(1)
+			} else {
+				skippedCount.Add(1)
+			}
+		},
+	}
 
-	peers, err := g.DiscoverPeers(ctx)
-	if err != nil {
+	if _, err := discovery.DiscoverPeersWithOptions(g.discoveryCtx, g.peer.DHT, g.logger, g.peer.PeerManager, opts); err != nil {
 		g.logger.Warn("Background discovery failed", zap.Error(err))
 		return
 	}
 
-	updatedCount := 0
-	skippedCount := 0
-	for _, peer := range peers {
-		// Check if this peer is already marked as unhealthy or recently removed
-		if g.peer.PeerManager.IsPeerUnhealthy(peer.PeerID) {
-			g.logger.Debug("Skipping unhealthy peer",
-				zap.String("peer_id", peer.PeerID))
-			skippedCount++
-			continue
-		}
-
-		// Additional check: skip peers with old metadata
-		if time.Since(peer.LastUpdated) > 1*time.Minute {
-			g.logger.Debug("Skipping peer with old metadata",
-				zap.String("peer_id", peer.PeerID),
-				zap.Time("last_updated", peer.LastUpdated))
-			skippedCount++
-			continue
-		}
+	if updatedCount.Load() > 0 || skippedCount.Load() > 0 {
+		g.logger.Info("Background discovery completed",
+			zap.Int32("updated_count", updatedCount.Load()),
+			zap.Int32("skipped_count", skippedCount.Load()),
+			zap.Int("total_workers", len(g.peer.PeerManager.GetAllPeers())))
+	}
+}
 
-		g.peer.PeerManager.AddOrUpdatePeer(peer.PeerID, peer)
-		updatedCount++
+// addDiscoveredPeer adds a discovered peer to the peer manager, returning false if it was skipped
+func (g *Gateway) addDiscoveredPeer(peer *crowdllama.Resource) bool {
+	// Check if this peer is already marked as unhealthy or recently removed
+	if g.peer.PeerManager.IsPeerUnhealthy(peer.PeerID) {
+		g.logger.Debug("Skipping unhealthy peer",
+			zap.String("peer_id", peer.PeerID))
+		return false
 	}
 
-	if updatedCount > 0 || skippedCount > 0 {
-		g.logger.Info("Background discovery completed",
-			zap.Int("updated_count", updatedCount),
-			zap.Int("skipped_count", skippedCount),
-			zap.Int("total_workers", len(g.peer.PeerManager.GetAllPeers())))
+	// Additional check: skip peers with old metadata
+	if time.Since(peer.LastUpdated) > 1*time.Minute {
+		g.logger.Debug("Skipping peer with old metadata",
+			zap.String("peer_id", peer.PeerID),
+			zap.Time("last_updated", peer.LastUpdated))
+		return false
 	}
+
+	g.peer.PeerManager.AddOrUpdatePeer(peer.PeerID, peer)
+	return true
 }
 
 // GetWorkerHealthStatus returns detailed health information about all workers
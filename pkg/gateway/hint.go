@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+)
+
+// modelHintInterval is the minimum time between two hints for the same model
+const modelHintInterval = 20 * time.Second
+
+// modelHintTimeout bounds how long sending a hint may take
+const modelHintTimeout = 10 * time.Second
+
+// modelHints remembers when demand for each unserved model was last reported to a worker
+type modelHints struct {
+	mu   sync.Mutex
+	sent map[string]time.Time
+}
+
+// claim returns true if a hint for model may be sent now, and records it as sent
+func (h *modelHints) claim(model string, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.sent == nil {
+		h.sent = make(map[string]time.Time)
+	}
+	for m, sentAt := range h.sent {
+		if now.Sub(sentAt) >= modelHintInterval {
+			delete(h.sent, m)
+		}
+	}
+	if _, ok := h.sent[model]; ok {
+		return false
+	}
+	h.sent[model] = now
+	return true
+}
+
+// hintModelDemand reports a request for a model no worker serves to the least loaded worker that pulls models
+// on demand. Each worker decides by itself whether the demand justifies pulling the model.
+func (g *Gateway) hintModelDemand(model string) {
+	var target *crowdllama.Resource
+	for _, worker := range g.peer.PeerManager.GetAvailableWorkers() {
+		if !worker.IsCompatible() || !worker.SupportsFeatures(crowdllama.FeaturePull) {
+			continue
+		}
+		if target == nil || worker.Load < target.Load || (worker.Load == target.Load && worker.PeerID < target.PeerID) {
+			target = worker
+		}
+	}
+	if target == nil || !g.modelHints.claim(model, time.Now()) {
+		return
+	}
+
+	go func() {
+		if err := g.sendModelHint(target.PeerID, model); err != nil {
+			g.logger.Debug("Failed to send model hint", zap.String("worker_id", target.PeerID), zap.Error(err))
+			return
+		}
+		g.logger.Info("Reported demand for unserved model", zap.String("model", model), zap.String("worker_id", target.PeerID))
+	}()
+}
+
+// sendModelHint sends a model hint to a worker
+func (g *Gateway) sendModelHint(workerID, model string) error {
+	id, err := peer.Decode(workerID)
+	if err != nil {
+		return fmt.Errorf("invalid worker ID: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(g.discoveryCtx, modelHintTimeout)
+	defer cancel()
+	s, err := g.peer.Host.NewStream(network.WithAllowLimitedConn(ctx, "model-hint"), id, crowdllama.ModelHintProtocol)
+	if err != nil {
+		return fmt.Errorf("open stream: %w", err)
+	}
+	defer func() {
+		if closeErr := s.Close(); closeErr != nil {
+			g.logger.Debug("Failed to close stream", zap.Error(closeErr))
+		}
+	}()
+
+	data, err := json.Marshal(crowdllama.ModelHint{Model: model})
+	if err != nil {
+		return fmt.Errorf("encode model hint: %w", err)
+	}
+	if _, err := s.Write(data); err != nil {
+		return fmt.Errorf("write model hint: %w", err)
+	}
+	return nil
+}
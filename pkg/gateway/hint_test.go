@@ -0,0 +1,24 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+)
+
+func TestModelHintsClaim(t *testing.T) {
+	var hints modelHints
+	now := time.Now()
+
+	if !hints.claim("llama3.2", now) {
+		t.Error("Expected the first hint to be sent")
+	}
+	if hints.claim("llama3.2", now.Add(time.Second)) {
+		t.Error("Expected a second hint within the interval to be suppressed")
+	}
+	if !hints.claim("mistral", now.Add(time.Second)) {
+		t.Error("Expected hints for other models to be sent")
+	}
+	if !hints.claim("llama3.2", now.Add(modelHintInterval)) {
+		t.Error("Expected a hint to be sent again after the interval")
+	}
+}
@@ -0,0 +1,161 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+
+	llamav1 "github.com/crowdllama/crowdllama-pb/llama/v1"
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+	"github.com/crowdllama/crowdllama/pkg/ledger"
+)
+
+// creditedWorkerWeight scales the score of workers the gateway has credit with, so it draws on them first
+const creditedWorkerWeight = 2.0
+
+// receiptTimeout bounds how long returning a countersigned receipt to a worker may take
+const receiptTimeout = 10 * time.Second
+
+// ParsePrepaidCredits parses prepaid credits given as peer=tokens
+func ParsePrepaidCredits(entries []string) (map[peer.ID]int64, error) {
+	credits := make(map[peer.ID]int64, len(entries))
+	for _, entry := range entries {
+		workerID, tokens, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, errors.New("prepaid credits must be written as peer=tokens")
+		}
+		id, err := peer.Decode(workerID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer ID in prepaid credit %q: %w", entry, err)
+		}
+		amount, err := strconv.ParseInt(tokens, 10, 64)
+		if err != nil || amount <= 0 {
+			return nil, fmt.Errorf("invalid token amount in prepaid credit %q", entry)
+		}
+		credits[id] += amount
+	}
+	return credits, nil
+}
+
+// SetPrepaidCredits sets the tokens the gateway's operator paid workers for in advance. Receipts for tokens
+// a worker served draw its credit down, and the gateway prefers workers while they have credit left.
+func (g *Gateway) SetPrepaidCredits(credits map[peer.ID]int64) {
+	g.prepaidCredits = credits
+}
+
+// setupLedger makes worker selection prefer workers with which the gateway has credit
+func (g *Gateway) setupLedger() {
+	if g.peer.Ledger == nil {
+		return
+	}
+	g.peer.PeerManager.SetWorkerWeight(g.workerWeight)
+	g.logger.Info("Recording receipts and preferring workers with credit",
+		zap.Int("peers", len(g.peer.Ledger.Balances())))
+}
+
+// workerWeight returns creditedWorkerWeight for workers the gateway has credit with and 1 for others. The
+// credit is what the gateway paid the worker in advance plus what the worker owes it, less what it served.
+func (g *Gateway) workerWeight(workerID string) float64 {
+	id, err := peer.Decode(workerID)
+	if err != nil || g.prepaidCredits[id]+g.peer.Ledger.Balance(id).Credit() <= 0 {
+		return 1
+	}
+	return creditedWorkerWeight
+}
+
+// settleReceipt countersigns the receipt a worker attached to a response, records it and returns it to the
+// worker. Responses without a receipt, e.g. from workers without a ledger, are left alone.
+func (g *Gateway) settleReceipt(workerID string, resp *llamav1.GenerateResponse) {
+	data := crowdllama.GetResponseReceipt(resp)
+	if g.peer.Ledger == nil || data == nil {
+		return
+	}
+
+	r, err := g.acceptReceipt(workerID, resp, data)
+	if err != nil {
+		g.logger.Warn("Refused receipt", zap.String("worker_id", workerID), zap.Error(err))
+		return
+	}
+	g.logger.Debug("Recorded receipt",
+		zap.String("worker_id", workerID),
+		zap.String("receipt_id", r.ID),
+		zap.Int64("tokens", r.Tokens()))
+
+	info, ok := g.peer.PeerManager.GetAllPeers()[workerID]
+	if !ok || info.Metadata == nil || !info.Metadata.SupportsProtocol(crowdllama.ReceiptProtocol) {
+		return
+	}
+	go func() {
+		if err := g.sendReceipt(r); err != nil {
+			g.logger.Debug("Failed to return receipt", zap.String("worker_id", workerID), zap.Error(err))
+		}
+	}()
+}
+
+// acceptReceipt checks that a receipt matches the response it came with, then countersigns and records it
+func (g *Gateway) acceptReceipt(workerID string, resp *llamav1.GenerateResponse, data []byte) (*ledger.Receipt, error) {
+	r, err := ledger.ParseReceipt(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkReceipt(r, workerID, g.peer.Host.ID().String(), resp); err != nil {
+		return nil, err
+	}
+	if err := g.peer.SignReceipt(r); err != nil {
+		return nil, fmt.Errorf("countersign receipt: %w", err)
+	}
+	if err := g.peer.Ledger.Record(r); err != nil {
+		return nil, fmt.Errorf("record receipt: %w", err)
+	}
+	return r, nil
+}
+
+// checkReceipt verifies that the worker the request was sent to signed a receipt for the tokens of the
+// response, issued to the gateway
+func checkReceipt(r *ledger.Receipt, workerID, consumerID string, resp *llamav1.GenerateResponse) error {
+	switch {
+	case r.Worker != workerID:
+		return fmt.Errorf("%w: issued by %s", ledger.ErrInvalidReceipt, r.Worker)
+	case r.Consumer != consumerID:
+		return fmt.Errorf("%w: issued to %s", ledger.ErrInvalidReceipt, r.Consumer)
+	case r.PromptTokens != int64(resp.GetPromptEvalCount()) || r.CompletionTokens != int64(resp.GetEvalCount()):
+		return fmt.Errorf("%w: %d prompt and %d completion tokens, the response reports %d and %d", ledger.ErrInvalidReceipt,
+			r.PromptTokens, r.CompletionTokens, resp.GetPromptEvalCount(), resp.GetEvalCount())
+	}
+	return r.VerifyWorker()
+}
+
+// sendReceipt returns a countersigned receipt to its worker
+func (g *Gateway) sendReceipt(r *ledger.Receipt) error {
+	id, err := peer.Decode(r.Worker)
+	if err != nil {
+		return fmt.Errorf("invalid worker ID: %w", err)
+	}
+	data, err := r.Marshal()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(g.discoveryCtx, receiptTimeout)
+	defer cancel()
+	s, err := g.peer.Host.NewStream(network.WithAllowLimitedConn(ctx, "receipt"), id, crowdllama.ReceiptProtocol)
+	if err != nil {
+		return fmt.Errorf("open stream: %w", err)
+	}
+	defer func() {
+		if closeErr := s.Close(); closeErr != nil {
+			g.logger.Debug("Failed to close stream", zap.Error(closeErr))
+		}
+	}()
+	if _, err := s.Write(data); err != nil {
+		return fmt.Errorf("write receipt: %w", err)
+	}
+	return nil
+}
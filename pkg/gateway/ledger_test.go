@@ -0,0 +1,114 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+
+	llamav1 "github.com/crowdllama/crowdllama-pb/llama/v1"
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+	"github.com/crowdllama/crowdllama/pkg/ledger"
+	peerpkg "github.com/crowdllama/crowdllama/pkg/peer"
+	"github.com/crowdllama/crowdllama/pkg/peermanager"
+	"github.com/crowdllama/crowdllama/pkg/testhelpers/testkeys"
+)
+
+func TestCheckReceipt(t *testing.T) {
+	workerKey, worker := testkeys.NewKey(t)
+	consumer := "12D3KooWDpJ7As7BWAwRMfu1VU2WCqNjvq387JEYKDBj4kx6nXTN"
+	consumerID, err := peer.Decode(consumer)
+	if err != nil {
+		t.Fatalf("Failed to decode peer ID: %v", err)
+	}
+	resp := &llamav1.GenerateResponse{PromptEvalCount: 12, EvalCount: 30}
+
+	r, err := ledger.NewReceipt(worker, consumerID, "llama3.2", 12, 30, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to create receipt: %v", err)
+	}
+	if err := checkReceipt(r, worker.String(), consumer, resp); !errors.Is(err, ledger.ErrInvalidReceipt) {
+		t.Errorf("Expected an unsigned receipt to be refused, got %v", err)
+	}
+	if err := r.Sign(workerKey); err != nil {
+		t.Fatalf("Failed to sign receipt: %v", err)
+	}
+	if err := checkReceipt(r, worker.String(), consumer, resp); err != nil {
+		t.Errorf("Expected a receipt matching the response to be accepted, got %v", err)
+	}
+
+	if err := checkReceipt(r, consumer, consumer, resp); !errors.Is(err, ledger.ErrInvalidReceipt) {
+		t.Errorf("Expected a receipt from another worker to be refused, got %v", err)
+	}
+	if err := checkReceipt(r, worker.String(), worker.String(), resp); !errors.Is(err, ledger.ErrInvalidReceipt) {
+		t.Errorf("Expected a receipt issued to another consumer to be refused, got %v", err)
+	}
+	inflated := &llamav1.GenerateResponse{PromptEvalCount: 12, EvalCount: 3}
+	if err := checkReceipt(r, worker.String(), consumer, inflated); !errors.Is(err, ledger.ErrInvalidReceipt) {
+		t.Errorf("Expected a receipt for more tokens than the response reports to be refused, got %v", err)
+	}
+}
+
+func TestPrepaidCreditPrefersWorker(t *testing.T) {
+	consumerKey, consumer := testkeys.NewKey(t)
+	_, fast := testkeys.NewKey(t)
+	prepaidKey, prepaid := testkeys.NewKey(t)
+
+	l, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.jsonl"), consumer, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to open ledger: %v", err)
+	}
+	defer func() { _ = l.Close() }()
+
+	pm := peermanager.NewManager(context.Background(), nil, nil, zap.NewNop(), nil)
+	for id, throughput := range map[peer.ID]float64{fast: 150, prepaid: 100} {
+		worker := crowdllama.NewCrowdLlamaResource(id.String())
+		worker.WorkerMode = true
+		worker.SupportedModels = []string{"llama3.2"}
+		worker.TokensThroughput = throughput
+		worker.ProtocolVersion = crowdllama.ProtocolVersion
+		worker.Features = crowdllama.WorkerFeatures()
+		pm.AddOrUpdatePeer(id.String(), worker)
+	}
+
+	credits, err := ParsePrepaidCredits([]string{prepaid.String() + "=100"})
+	if err != nil {
+		t.Fatalf("Failed to parse prepaid credits: %v", err)
+	}
+	g := &Gateway{peer: &peerpkg.Peer{Ledger: l, PeerManager: pm}, logger: zap.NewNop()}
+	g.SetPrepaidCredits(credits)
+	g.setupLedger()
+
+	features := []string{crowdllama.FeatureChat}
+	if worker := pm.FindBestWorkerWithFeatures("llama3.2", features); worker == nil || worker.PeerID != prepaid.String() {
+		t.Fatalf("Expected the worker with prepaid credit to be selected, got %v", worker)
+	}
+
+	// Once receipts use up the credit, the faster worker wins again
+	r, err := ledger.NewReceipt(prepaid, consumer, "llama3.2", 40, 60, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to create receipt: %v", err)
+	}
+	for _, key := range []crypto.PrivKey{prepaidKey, consumerKey} {
+		if err := r.Sign(key); err != nil {
+			t.Fatalf("Failed to sign receipt: %v", err)
+		}
+	}
+	if err := l.Record(r); err != nil {
+		t.Fatalf("Failed to record receipt: %v", err)
+	}
+	if worker := pm.FindBestWorkerWithFeatures("llama3.2", features); worker == nil || worker.PeerID != fast.String() {
+		t.Errorf("Expected the faster worker to be selected once the credit is used up, got %v", worker)
+	}
+
+	for _, entry := range []string{"12D3KooW", prepaid.String(), prepaid.String() + "=-5", "nobody=10"} {
+		if _, err := ParsePrepaidCredits([]string{entry}); err == nil {
+			t.Errorf("Expected prepaid credit %q to be refused", entry)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+
+	llamav1 "github.com/crowdllama/crowdllama-pb/llama/v1"
+)
+
+// clientIdleExpiry is how long the limiter keeps the state of a client without requests
+const clientIdleExpiry = 24 * time.Hour
+
+// clientSweepInterval is how often the limiter forgets idle clients
+const clientSweepInterval = 10 * time.Minute
+
+// Limits bound how much of the swarm a single client may use. Zero fields impose no limit.
+type Limits struct {
+	RequestsPerMinute int   `json:"requests_per_minute,omitempty"`
+	MaxConcurrent     int   `json:"max_concurrent,omitempty"`
+	DailyTokens       int64 `json:"daily_tokens,omitempty"` // prompt and generated tokens per UTC day
+}
+
+// override returns l with the limits set in o replacing its own; negative limits in o remove the limit
+func (l Limits) override(o Limits) Limits {
+	pick := func(base, override int64) int64 {
+		switch {
+		case override < 0:
+			return 0
+		case override > 0:
+			return override
+		default:
+			return base
+		}
+	}
+	return Limits{
+		RequestsPerMinute: int(pick(int64(l.RequestsPerMinute), int64(o.RequestsPerMinute))),
+		MaxConcurrent:     int(pick(int64(l.MaxConcurrent), int64(o.MaxConcurrent))),
+		DailyTokens:       pick(l.DailyTokens, o.DailyTokens),
+	}
+}
+
+// limitExceededError is returned when a client is over one of its limits
+type limitExceededError struct {
+	reason     string
+	retryAfter time.Duration
+}
+
+func (e *limitExceededError) Error() string {
+	return e.reason
+}
+
+// clientLimiter tracks the usage of one client
+type clientLimiter struct {
+	limits   Limits
+	rate     *rate.Limiter // nil without a request rate limit
+	active   int
+	day      time.Time // UTC day the token count belongs to
+	tokens   int64
+	lastSeen time.Time
+}
+
+// limiter enforces Limits per client, identified by API key name or, without keys, by IP address
+type limiter struct {
+	mu        sync.Mutex
+	defaults  Limits
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// newLimiter creates a limiter applying defaults to every client
+func newLimiter(defaults Limits) *limiter {
+	return &limiter{defaults: defaults, clients: make(map[string]*clientLimiter), now: time.Now}
+}
+
+// limitClientKey is the context key of the client a request is accounted to
+type limitClientKey struct{}
+
+// clientID identifies the client of a request for accounting
+func clientID(r *http.Request) (string, Limits) {
+	if key := ClientFromContext(r.Context()); key != nil {
+		return "key:" + key.Name, key.Limits
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host, Limits{}
+}
+
+// admit counts a request of client against its limits. Admitted requests must call release.
+func (l *limiter) admit(client string, override Limits) *limitExceededError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweepLocked(now)
+	c := l.clientLocked(client, override, now)
+	c.lastSeen = now
+
+	if c.limits.MaxConcurrent > 0 && c.active >= c.limits.MaxConcurrent {
+		return &limitExceededError{
+			reason:     fmt.Sprintf("too many concurrent requests, at most %d allowed", c.limits.MaxConcurrent),
+			retryAfter: time.Second,
+		}
+	}
+	if c.limits.DailyTokens > 0 && c.tokens >= c.limits.DailyTokens {
+		return &limitExceededError{
+			reason:     fmt.Sprintf("daily quota of %d tokens used up", c.limits.DailyTokens),
+			retryAfter: c.day.AddDate(0, 0, 1).Sub(now),
+		}
+	}
+	if c.rate != nil {
+		reservation := c.rate.ReserveN(now, 1)
+		if delay := reservation.DelayFrom(now); delay > 0 {
+			reservation.CancelAt(now)
+			return &limitExceededError{
+				reason:     fmt.Sprintf("rate limit of %d requests per minute exceeded", c.limits.RequestsPerMinute),
+				retryAfter: delay,
+			}
+		}
+	}
+	c.active++
+	return nil
+}
+
+// release marks an admitted request of client as finished
+func (l *limiter) release(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c, ok := l.clients[client]; ok {
+		c.active--
+		c.lastSeen = l.now()
+	}
+}
+
+// recordTokens adds the tokens a worker reported for a response to the client's daily usage
+func (l *limiter) recordTokens(client string, tokens int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c, ok := l.clients[client]
+	if !ok {
+		return
+	}
+	c.resetDayLocked(l.now())
+	c.tokens += tokens
+}
+
+// clientLocked returns the state of client, creating it on first use. The caller must hold l.mu.
+func (l *limiter) clientLocked(client string, override Limits, now time.Time) *clientLimiter {
+	c, ok := l.clients[client]
+	if !ok {
+		c = &clientLimiter{limits: l.defaults.override(override)}
+		if c.limits.RequestsPerMinute > 0 {
+			// Allow a minute's worth of requests in a burst, refilled evenly over the minute
+			c.rate = rate.NewLimiter(rate.Limit(float64(c.limits.RequestsPerMinute)/60), c.limits.RequestsPerMinute)
+		}
+		l.clients[client] = c
+	}
+	c.resetDayLocked(now)
+	return c
+}
+
+// resetDayLocked starts a new token count when the UTC day changes
+func (c *clientLimiter) resetDayLocked(now time.Time) {
+	day := now.UTC().Truncate(24 * time.Hour)
+	if !day.Equal(c.day) {
+		c.day = day
+		c.tokens = 0
+	}
+}
+
+// sweepLocked forgets clients that have been idle for a day. The caller must hold l.mu.
+func (l *limiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < clientSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for client, c := range l.clients {
+		if c.active == 0 && now.Sub(c.lastSeen) > clientIdleExpiry {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// responseTokens returns the prompt and generated tokens a worker reported for a response
+func responseTokens(resp *llamav1.GenerateResponse) int64 {
+	return int64(resp.GetPromptEvalCount()) + int64(resp.GetEvalCount())
+}
+
+// SetLimits applies limits to every client; API keys may override them
+func (g *Gateway) SetLimits(limits Limits) {
+	g.limits = newLimiter(limits)
+}
+
+// limitMiddleware rejects chat requests of clients over their limits with 429 Too Many Requests
+func (g *Gateway) limitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := routeScopes[r.URL.Path]; !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client, override := clientID(r)
+		if limitErr := g.limits.admit(client, override); limitErr != nil {
+			g.logger.Warn("Rejected request over the client's limits",
+				zap.String("path", r.URL.Path),
+				zap.String("client", client),
+				zap.Error(limitErr))
+			seconds := int(math.Ceil(limitErr.retryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+			g.sendEndpointError(w, r, http.StatusTooManyRequests, limitErr)
+			return
+		}
+		defer g.limits.release(client)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), limitClientKey{}, client)))
+	})
+}
+
+// recordUsage counts the tokens of a response against the daily quota of the client that asked for it
+func (g *Gateway) recordUsage(ctx context.Context, resp *llamav1.GenerateResponse) {
+	client, ok := ctx.Value(limitClientKey{}).(string)
+	if !ok {
+		return
+	}
+	g.limits.recordTokens(client, responseTokens(resp))
+}
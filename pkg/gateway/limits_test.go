@@ -0,0 +1,89 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	llamav1 "github.com/crowdllama/crowdllama-pb/llama/v1"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := newLimiter(Limits{RequestsPerMinute: 2, MaxConcurrent: 1, DailyTokens: 100})
+	l.now = func() time.Time { return now }
+
+	if err := l.admit("ip:10.0.0.1", Limits{}); err != nil {
+		t.Fatalf("Expected the first request to be admitted, got %v", err)
+	}
+	if err := l.admit("ip:10.0.0.1", Limits{}); err == nil {
+		t.Error("Expected a second concurrent request to be refused")
+	}
+	l.release("ip:10.0.0.1")
+
+	if err := l.admit("ip:10.0.0.1", Limits{}); err != nil {
+		t.Fatalf("Expected a request within the burst to be admitted, got %v", err)
+	}
+	l.release("ip:10.0.0.1")
+	err := l.admit("ip:10.0.0.1", Limits{})
+	if err == nil || err.retryAfter != 30*time.Second {
+		t.Fatalf("Expected the rate limit to ask for a retry in 30s, got %v", err)
+	}
+	now = now.Add(30 * time.Second)
+	if err := l.admit("ip:10.0.0.1", Limits{}); err != nil {
+		t.Fatalf("Expected a request after the refill to be admitted, got %v", err)
+	}
+
+	l.recordTokens("ip:10.0.0.1", 120)
+	l.release("ip:10.0.0.1")
+	now = now.Add(time.Minute)
+	err = l.admit("ip:10.0.0.1", Limits{})
+	if err == nil || err.retryAfter != 12*time.Hour-90*time.Second {
+		t.Fatalf("Expected the used up quota to ask for a retry at midnight, got %v", err)
+	}
+
+	// Other clients and keys with their own limits are not affected
+	if err := l.admit("key:batch", Limits{DailyTokens: -1, MaxConcurrent: 5}); err != nil {
+		t.Errorf("Expected another client to be admitted, got %v", err)
+	}
+	l.recordTokens("key:batch", 1000)
+	if err := l.admit("key:batch", Limits{DailyTokens: -1, MaxConcurrent: 5}); err != nil {
+		t.Errorf("Expected a key without a quota to be admitted, got %v", err)
+	}
+
+	now = now.Add(12 * time.Hour)
+	if err := l.admit("ip:10.0.0.1", Limits{}); err != nil {
+		t.Errorf("Expected the quota to reset on the next day, got %v", err)
+	}
+}
+
+func TestLimitMiddleware(t *testing.T) {
+	g := &Gateway{logger: zap.NewNop(), limits: newLimiter(Limits{DailyTokens: 10})}
+	handler := g.limitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.recordUsage(r.Context(), &llamav1.GenerateResponse{PromptEvalCount: 4, EvalCount: 8})
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, http.NoBody)
+		req.RemoteAddr = "192.0.2.1:5000"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve("/api/chat"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the first request to succeed, got %d", rec.Code)
+	}
+	rec := serve("/v1/chat/completions")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected a 429 response with Retry-After once the quota is used, got %d %q",
+			rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := serve("/api/health"); rec.Code != http.StatusOK {
+		t.Errorf("Expected the health check not to be limited, got %d", rec.Code)
+	}
+}
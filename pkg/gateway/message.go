@@ -0,0 +1,139 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+)
+
+// errUnsupportedImageURL is returned for OpenAI image parts that reference a remote URL instead of inline data
+var errUnsupportedImageURL = errors.New("only base64 data URLs are supported for images")
+
+// contentPart is one element of an OpenAI style content array
+type contentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL json.RawMessage `json:"image_url,omitempty"`
+}
+
+// UnmarshalJSON accepts both the Ollama message format, with a string content and base64 images, and the
+// OpenAI format, where content is an array of text and image_url parts and tool arguments are JSON strings
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Role      string                `json:"role"`
+		Content   json.RawMessage       `json:"content"`
+		Images    []string              `json:"images"`
+		ToolCalls []crowdllama.ToolCall `json:"tool_calls"`
+		ToolName  string                `json:"tool_name"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("decode message: %w", err)
+	}
+	m.Role = raw.Role
+	m.Content = ""
+	m.Images = raw.Images
+	m.ToolName = raw.ToolName
+	m.ToolCalls = raw.ToolCalls
+	for i := range m.ToolCalls {
+		args, err := normalizeArguments(m.ToolCalls[i].Function.Arguments)
+		if err != nil {
+			return fmt.Errorf("tool call %d: %w", i, err)
+		}
+		m.ToolCalls[i].Function.Arguments = args
+	}
+	return m.setContent(raw.Content)
+}
+
+// setContent decodes a message content given as a string or as an array of content parts
+func (m *Message) setContent(content json.RawMessage) error {
+	if len(content) == 0 || string(content) == "null" {
+		return nil
+	}
+	if content[0] == '"' {
+		if err := json.Unmarshal(content, &m.Content); err != nil {
+			return fmt.Errorf("decode message content: %w", err)
+		}
+		return nil
+	}
+
+	var parts []contentPart
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return fmt.Errorf("decode message content parts: %w", err)
+	}
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			texts = append(texts, part.Text)
+		case "image_url":
+			image, err := imageFromURL(part.ImageURL)
+			if err != nil {
+				return err
+			}
+			m.Images = append(m.Images, image)
+		default:
+			return fmt.Errorf("unsupported content part type %q", part.Type)
+		}
+	}
+	m.Content = strings.Join(texts, "\n")
+	return nil
+}
+
+// normalizeArguments returns tool call arguments as a JSON object. OpenAI encodes them as a string
+// containing JSON, Ollama as the object itself.
+func normalizeArguments(args json.RawMessage) (json.RawMessage, error) {
+	if len(args) == 0 || args[0] != '"' {
+		return args, nil
+	}
+	var encoded string
+	if err := json.Unmarshal(args, &encoded); err != nil {
+		return nil, fmt.Errorf("decode arguments: %w", err)
+	}
+	if encoded == "" {
+		return json.RawMessage("{}"), nil
+	}
+	if !json.Valid([]byte(encoded)) {
+		return nil, errors.New("arguments are not valid JSON")
+	}
+	return json.RawMessage(encoded), nil
+}
+
+// imageFromURL extracts the base64 data of an OpenAI image_url, given either as a string or as {"url": ...}
+func imageFromURL(raw json.RawMessage) (string, error) {
+	var url string
+	if err := json.Unmarshal(raw, &url); err != nil {
+		var obj struct {
+			URL string `json:"url"`
+		}
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return "", fmt.Errorf("decode image_url: %w", err)
+		}
+		url = obj.URL
+	}
+
+	if !strings.HasPrefix(url, "data:") {
+		return "", errUnsupportedImageURL
+	}
+	_, data, ok := strings.Cut(url, ";base64,")
+	if !ok {
+		return "", errUnsupportedImageURL
+	}
+	return data, nil
+}
+
+// decodeImages decodes the base64 images of a message
+func decodeImages(images []string) ([][]byte, error) {
+	decoded := make([][]byte, 0, len(images))
+	for i, image := range images {
+		data, err := base64.StdEncoding.DecodeString(image)
+		if err != nil {
+			return nil, fmt.Errorf("image %d is not valid base64: %w", i, err)
+		}
+		decoded = append(decoded, data)
+	}
+	return decoded, nil
+}
@@ -0,0 +1,43 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestMessageUnmarshalFormats(t *testing.T) {
+	var ollama Message
+	if err := json.Unmarshal([]byte(`{"role":"user","content":"what is this?","images":["aGVsbG8="]}`), &ollama); err != nil {
+		t.Fatalf("Failed to decode Ollama message: %v", err)
+	}
+	if ollama.Content != "what is this?" || len(ollama.Images) != 1 || ollama.Images[0] != "aGVsbG8=" {
+		t.Errorf("Unexpected Ollama message: %+v", ollama)
+	}
+
+	var openAI Message
+	data := `{"role":"user","content":[
+		{"type":"text","text":"what is this?"},
+		{"type":"image_url","image_url":{"url":"data:image/png;base64,aGVsbG8="}}
+	]}`
+	if err := json.Unmarshal([]byte(data), &openAI); err != nil {
+		t.Fatalf("Failed to decode OpenAI message: %v", err)
+	}
+	if openAI.Content != "what is this?" || len(openAI.Images) != 1 || openAI.Images[0] != "aGVsbG8=" {
+		t.Errorf("Unexpected OpenAI message: %+v", openAI)
+	}
+
+	images, err := decodeImages(openAI.Images)
+	if err != nil {
+		t.Fatalf("Failed to decode images: %v", err)
+	}
+	if string(images[0]) != "hello" {
+		t.Errorf("Expected decoded image %q, got %q", "hello", images[0])
+	}
+
+	var remote Message
+	err = json.Unmarshal([]byte(`{"role":"user","content":[{"type":"image_url","image_url":{"url":"https://example.com/cat.png"}}]}`), &remote)
+	if !errors.Is(err, errUnsupportedImageURL) {
+		t.Errorf("Expected errUnsupportedImageURL for a remote image, got %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package gateway
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"go.uber.org/zap"
+
+	llamav1 "github.com/crowdllama/crowdllama-pb/llama/v1"
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+)
+
+// OpenAI finish reasons
+const (
+	finishReasonStop      = "stop"
+	finishReasonLength    = "length"
+	finishReasonToolCalls = "tool_calls"
+)
+
+// openAIChatResponse is the response of the OpenAI compatible /v1/chat/completions endpoint, used both for
+// complete responses and for streamed chunks
+type openAIChatResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   *openAIUsage   `json:"usage,omitempty"`
+}
+
+// openAIUsage reports the tokens a completion used
+type openAIUsage struct {
+	PromptTokens     int32 `json:"prompt_tokens"`
+	CompletionTokens int32 `json:"completion_tokens"`
+	TotalTokens      int32 `json:"total_tokens"`
+}
+
+// openAIChoice is a completion choice; Message is set on complete responses and Delta on streamed chunks
+type openAIChoice struct {
+	Index        int            `json:"index"`
+	Message      *openAIMessage `json:"message,omitempty"`
+	Delta        *openAIMessage `json:"delta,omitempty"`
+	FinishReason *string        `json:"finish_reason"`
+}
+
+// openAIMessage is an assistant message in OpenAI format
+type openAIMessage struct {
+	Role      string           `json:"role,omitempty"`
+	Content   string           `json:"content"`
+	ToolCalls []openAIToolCall `json:"tool_calls,omitempty"`
+}
+
+// openAIToolCall is a tool call in OpenAI format, whose arguments are a JSON encoded string
+type openAIToolCall struct {
+	Index    *int               `json:"index,omitempty"` // only set in streamed chunks
+	ID       string             `json:"id"`
+	Type     string             `json:"type"`
+	Function openAIToolFunction `json:"function"`
+}
+
+// openAIToolFunction names the called function and its arguments
+type openAIToolFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// handleChatCompletions handles the OpenAI compatible /v1/chat/completions endpoint
+func (g *Gateway) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		g.sendOpenAIError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	// The request body has the same shape as an Ollama chat request; Message accepts both formats
+	var req GenerateRequest
+	if statusCode, err := g.decodeChatRequest(w, r, &req); err != nil {
+		g.sendOpenAIError(w, statusCode, err)
+		return
+	}
+	if req.Model == "" {
+		g.sendOpenAIError(w, http.StatusBadRequest, errors.New("model is required"))
+		return
+	}
+	if len(req.Messages) == 0 {
+		g.sendOpenAIError(w, http.StatusBadRequest, errors.New("at least one message is required"))
+		return
+	}
+
+	result, statusCode, err := g.chat(r.Context(), &req)
+	if err != nil {
+		g.sendOpenAIError(w, statusCode, err)
+		return
+	}
+
+	resp := g.newOpenAIChatResponse(&req, result.resp)
+	setResultHeaders(w, result)
+	if req.Stream {
+		g.streamOpenAIResponse(w, resp)
+		return
+	}
+	g.sendJSONResponse(w, resp, http.StatusOK)
+}
+
+// newOpenAIChatResponse converts a worker response to an OpenAI chat completion
+func (g *Gateway) newOpenAIChatResponse(req *GenerateRequest, pbResp *llamav1.GenerateResponse) *openAIChatResponse {
+	id := newCompletionID()
+	toolCalls, err := crowdllama.GetResponseToolCalls(pbResp)
+	if err != nil {
+		g.logger.Warn("Dropping malformed tool calls from worker response", zap.Error(err))
+	}
+
+	message := &openAIMessage{Role: "assistant", Content: pbResp.GetResponse()}
+	for i, call := range toolCalls {
+		args := string(call.Function.Arguments)
+		if args == "" {
+			args = "{}"
+		}
+		message.ToolCalls = append(message.ToolCalls, openAIToolCall{
+			ID:       fmt.Sprintf("call_%s_%d", id, i),
+			Type:     "function",
+			Function: openAIToolFunction{Name: call.Function.Name, Arguments: args},
+		})
+	}
+
+	finishReason := finishReasonStop
+	switch {
+	case len(message.ToolCalls) > 0:
+		finishReason = finishReasonToolCalls
+	case pbResp.GetDoneReason() == finishReasonLength:
+		finishReason = finishReasonLength
+	}
+
+	model := pbResp.GetModel()
+	if model == "" {
+		model = req.Model
+	}
+	return &openAIChatResponse{
+		ID:      "chatcmpl-" + id,
+		Object:  "chat.completion",
+		Created: pbResp.GetCreatedAt().AsTime().Unix(),
+		Model:   model,
+		Choices: []openAIChoice{{Index: 0, Message: message, FinishReason: &finishReason}},
+		Usage: &openAIUsage{
+			PromptTokens:     pbResp.GetPromptEvalCount(),
+			CompletionTokens: pbResp.GetEvalCount(),
+			TotalTokens:      pbResp.GetPromptEvalCount() + pbResp.GetEvalCount(),
+		},
+	}
+}
+
+// streamOpenAIResponse writes a complete completion as server-sent events: a chunk with the whole message, a
+// chunk with the finish reason and usage, and the [DONE] marker. It is not incremental.
+func (g *Gateway) streamOpenAIResponse(w http.ResponseWriter, resp *openAIChatResponse) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set(StreamingHeader, "buffered")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	choice := resp.Choices[0]
+	delta := *choice.Message
+	delta.ToolCalls = slices.Clone(delta.ToolCalls)
+	for i := range delta.ToolCalls {
+		index := i
+		delta.ToolCalls[i].Index = &index
+	}
+
+	chunk := *resp
+	chunk.Object = "chat.completion.chunk"
+	chunk.Choices = []openAIChoice{{Index: 0, Delta: &delta}}
+	chunk.Usage = nil
+	final := chunk
+	final.Choices = []openAIChoice{{Index: 0, Delta: &openAIMessage{}, FinishReason: choice.FinishReason}}
+	final.Usage = resp.Usage
+
+	for _, event := range []*openAIChatResponse{&chunk, &final} {
+		data, err := json.Marshal(event)
+		if err != nil {
+			g.logger.Error("Failed to encode streamed response", zap.Error(err))
+			return
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			g.logger.Debug("Failed to write streamed response", zap.Error(err))
+			return
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	if _, err := fmt.Fprint(w, "data: [DONE]\n\n"); err != nil {
+		g.logger.Debug("Failed to write end of stream", zap.Error(err))
+	}
+}
+
+// sendOpenAIError sends an error in the OpenAI error format
+func (g *Gateway) sendOpenAIError(w http.ResponseWriter, statusCode int, err error) {
+	errType := "server_error"
+	if statusCode < http.StatusInternalServerError {
+		errType = "invalid_request_error"
+	}
+	g.sendJSONResponse(w, map[string]any{
+		"error": map[string]string{"message": err.Error(), "type": errType},
+	}, statusCode)
+}
+
+// newCompletionID returns a random identifier for a chat completion
+func newCompletionID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "0"
+	}
+	return hex.EncodeToString(b)
+}
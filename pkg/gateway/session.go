@@ -0,0 +1,249 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+
+	llamav1 "github.com/crowdllama/crowdllama-pb/llama/v1"
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+)
+
+// sessionWriteTimeout bounds writing a request whose context has no deadline
+const sessionWriteTimeout = 30 * time.Second
+
+// errNotSent is returned for requests whose context ended before they could be written
+var errNotSent = errors.New("request not sent")
+
+// errSessionClosed is returned for requests that were in flight when their session ended
+var errSessionClosed = errors.New("inference session closed")
+
+// workerSession is a long-lived stream to one worker that carries many concurrent requests
+type workerSession struct {
+	workerID  peer.ID
+	stream    network.Stream
+	frameOpts crowdllama.FrameOptions
+	logger    *zap.Logger
+	onClose   func(*workerSession)
+
+	nextID  atomic.Uint64
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[uint64]chan *llamav1.BaseMessage
+	err     error
+}
+
+// sessionPool keeps one inference session per worker
+type sessionPool struct {
+	logger    *zap.Logger
+	frameOpts crowdllama.FrameOptions
+	open      func(ctx context.Context, workerID peer.ID) (network.Stream, error)
+
+	mu       sync.Mutex
+	sessions map[peer.ID]*workerSession
+}
+
+func newSessionPool(
+	logger *zap.Logger,
+	frameOpts crowdllama.FrameOptions,
+	open func(ctx context.Context, workerID peer.ID) (network.Stream, error),
+) *sessionPool {
+	return &sessionPool{
+		logger:    logger,
+		frameOpts: frameOpts,
+		open:      open,
+		sessions:  make(map[peer.ID]*workerSession),
+	}
+}
+
+// get returns the session to a worker, opening one if none is active
+func (sp *sessionPool) get(ctx context.Context, workerID peer.ID) (*workerSession, error) {
+	sp.mu.Lock()
+	session, ok := sp.sessions[workerID]
+	sp.mu.Unlock()
+	if ok {
+		return session, nil
+	}
+
+	// Dial without holding the lock so a slow worker does not block requests to others
+	stream, err := sp.open(ctx, workerID)
+	if err != nil {
+		return nil, err
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if existing, ok := sp.sessions[workerID]; ok {
+		// Another request opened a session in the meantime
+		if closeErr := stream.Close(); closeErr != nil {
+			sp.logger.Debug("Failed to close duplicate session stream", zap.Error(closeErr))
+		}
+		return existing, nil
+	}
+
+	session = &workerSession{
+		workerID:  workerID,
+		stream:    stream,
+		frameOpts: sp.frameOpts.ForProtocol(string(stream.Protocol())),
+		logger:    sp.logger,
+		onClose:   sp.remove,
+		pending:   make(map[uint64]chan *llamav1.BaseMessage),
+	}
+	sp.sessions[workerID] = session
+	go session.readLoop()
+
+	sp.logger.Debug("Opened inference session",
+		zap.String("worker_id", workerID.String()),
+		zap.String("protocol", string(stream.Protocol())))
+	return session, nil
+}
+
+// remove forgets a session once it has ended
+func (sp *sessionPool) remove(session *workerSession) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.sessions[session.workerID] == session {
+		delete(sp.sessions, session.workerID)
+	}
+}
+
+// closeAll ends all sessions
+func (sp *sessionPool) closeAll() {
+	sp.mu.Lock()
+	sessions := make([]*workerSession, 0, len(sp.sessions))
+	for _, session := range sp.sessions {
+		sessions = append(sessions, session)
+	}
+	sp.mu.Unlock()
+
+	for _, session := range sessions {
+		if err := session.stream.Reset(); err != nil {
+			sp.logger.Debug("Failed to reset session stream", zap.Error(err))
+		}
+	}
+}
+
+// roundTrip sends a request on the session and waits for its response
+func (s *workerSession) roundTrip(ctx context.Context, req *llamav1.BaseMessage) (*llamav1.BaseMessage, error) {
+	requestID := s.nextID.Add(1)
+	respCh := make(chan *llamav1.BaseMessage, 1)
+
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	s.pending[requestID] = respCh
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, requestID)
+		s.mu.Unlock()
+	}()
+
+	err := s.send(ctx, requestID, req)
+	if errors.Is(err, crowdllama.ErrMessageTooLarge) || errors.Is(err, errNotSent) {
+		// Nothing was written, the session is still usable
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if err != nil {
+		s.fail(fmt.Errorf("%w: %w", errSessionClosed, err))
+		return nil, fmt.Errorf("%w: %w", errSessionClosed, err)
+	}
+
+	select {
+	case resp, ok := <-respCh:
+		if !ok {
+			return nil, s.closeErr()
+		}
+		return resp, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for worker response: %w", ctx.Err())
+	}
+}
+
+// send writes a request frame. A worker that stops reading would block the write, and every request queued
+// behind it, so the write is bounded by the request's deadline; a write that times out leaves a partial frame
+// on the stream and ends the session.
+func (s *workerSession) send(ctx context.Context, requestID uint64, req *llamav1.BaseMessage) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %w", errNotSent, err)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(sessionWriteTimeout)
+	}
+	if err := s.stream.SetWriteDeadline(deadline); err != nil {
+		s.logger.Debug("Failed to set write deadline", zap.Error(err))
+	}
+	defer func() {
+		if err := s.stream.SetWriteDeadline(time.Time{}); err != nil {
+			s.logger.Debug("Failed to clear write deadline", zap.Error(err))
+		}
+	}()
+	return crowdllama.WriteSessionFrame(s.stream, s.frameOpts, requestID, req)
+}
+
+// readLoop dispatches response frames to the requests waiting for them
+func (s *workerSession) readLoop() {
+	for {
+		requestID, msg, err := crowdllama.ReadSessionFrame(s.stream, s.frameOpts)
+		if err != nil {
+			s.fail(fmt.Errorf("%w: %w", errSessionClosed, err))
+			return
+		}
+
+		// Deliver under the lock so fail cannot close the channel concurrently; the channel is buffered
+		s.mu.Lock()
+		respCh, ok := s.pending[requestID]
+		if ok {
+			delete(s.pending, requestID)
+			respCh <- msg
+		}
+		s.mu.Unlock()
+		if !ok {
+			// The caller gave up, e.g. because its context was cancelled
+			s.logger.Debug("Dropping response for unknown request", zap.Uint64("request_id", requestID))
+		}
+	}
+}
+
+// fail ends the session and wakes up all pending requests
+func (s *workerSession) fail(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	for requestID, respCh := range s.pending {
+		close(respCh)
+		delete(s.pending, requestID)
+	}
+	s.mu.Unlock()
+
+	s.onClose(s)
+	if resetErr := s.stream.Reset(); resetErr != nil {
+		s.logger.Debug("Failed to reset session stream", zap.Error(resetErr))
+	}
+	s.logger.Debug("Inference session ended", zap.String("worker_id", s.workerID.String()), zap.Error(err))
+}
+
+// closeErr returns the reason the session ended
+func (s *workerSession) closeErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
@@ -0,0 +1,175 @@
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+)
+
+func newSessionTestHosts(t *testing.T) (gatewayHost, workerHost host.Host) {
+	t.Helper()
+	for _, h := range []*host.Host{&gatewayHost, &workerHost} {
+		created, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		if err != nil {
+			t.Fatalf("Failed to create host: %v", err)
+		}
+		t.Cleanup(func() { _ = created.Close() })
+		*h = created
+	}
+	gatewayHost.Peerstore().AddAddrs(workerHost.ID(), workerHost.Addrs(), time.Hour)
+	return gatewayHost, workerHost
+}
+
+func newTestSessionPool(gatewayHost host.Host) *sessionPool {
+	return newSessionPool(zap.NewNop(), crowdllama.FrameOptions{}, func(ctx context.Context, workerID peer.ID) (network.Stream, error) {
+		return gatewayHost.NewStream(ctx, workerID, crowdllama.InferenceSessionProtocol)
+	})
+}
+
+func TestSessionConcurrentRequests(t *testing.T) {
+	gatewayHost, workerHost := newSessionTestHosts(t)
+
+	// The worker answers requests in reverse order of arrival to check responses are matched by request ID
+	const requests = 8
+	workerHost.SetStreamHandler(crowdllama.InferenceSessionProtocol, func(s network.Stream) {
+		defer func() { _ = s.Close() }()
+		type frame struct {
+			id     uint64
+			prompt string
+		}
+		received := make([]frame, 0, requests)
+		for len(received) < requests {
+			id, msg, err := crowdllama.ReadSessionFrame(s, crowdllama.FrameOptions{})
+			if err != nil {
+				return
+			}
+			received = append(received, frame{id: id, prompt: msg.GetGenerateRequest().GetPrompt()})
+		}
+		for i := len(received) - 1; i >= 0; i-- {
+			resp := crowdllama.CreateGenerateRequest("echo", received[i].prompt, false)
+			if err := crowdllama.WriteSessionFrame(s, crowdllama.FrameOptions{}, received[i].id, resp); err != nil {
+				return
+			}
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pool := newTestSessionPool(gatewayHost)
+
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			session, err := pool.get(ctx, workerHost.ID())
+			if err != nil {
+				t.Errorf("Failed to get session: %v", err)
+				return
+			}
+			prompt := fmt.Sprintf("prompt-%d", i)
+			resp, err := session.roundTrip(ctx, crowdllama.CreateGenerateRequest("echo", prompt, false))
+			if err != nil {
+				t.Errorf("Request %d failed: %v", i, err)
+				return
+			}
+			if got := resp.GetGenerateRequest().GetPrompt(); got != prompt {
+				t.Errorf("Request %d got response for %q", i, got)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if conns := gatewayHost.Network().ConnsToPeer(workerHost.ID()); len(conns) != 1 {
+		t.Errorf("Expected a single connection to the worker, got %d", len(conns))
+	}
+}
+
+func TestSessionClosedByWorker(t *testing.T) {
+	gatewayHost, workerHost := newSessionTestHosts(t)
+
+	// The worker drops the session without answering
+	workerHost.SetStreamHandler(crowdllama.InferenceSessionProtocol, func(s network.Stream) {
+		if _, _, err := crowdllama.ReadSessionFrame(s, crowdllama.FrameOptions{}); err == nil {
+			_ = s.Reset()
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pool := newTestSessionPool(gatewayHost)
+
+	session, err := pool.get(ctx, workerHost.ID())
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	_, err = session.roundTrip(ctx, crowdllama.CreateGenerateRequest("echo", "hello", false))
+	if !errors.Is(err, errSessionClosed) {
+		t.Fatalf("Expected errSessionClosed, got %v", err)
+	}
+
+	next, err := pool.get(ctx, workerHost.ID())
+	if err != nil {
+		t.Fatalf("Failed to get new session: %v", err)
+	}
+	if next == session {
+		t.Error("Expected a closed session to be replaced")
+	}
+}
+
+func TestSessionWriteDeadline(t *testing.T) {
+	gatewayHost, workerHost := newSessionTestHosts(t)
+
+	// The worker accepts the session but never reads from it
+	release := make(chan struct{})
+	defer close(release)
+	workerHost.SetStreamHandler(crowdllama.InferenceSessionProtocol, func(s network.Stream) {
+		<-release
+		_ = s.Reset()
+	})
+
+	pool := newTestSessionPool(gatewayHost)
+	session, err := pool.get(context.Background(), workerHost.ID())
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+
+	// A prompt much larger than the stream window blocks the write until the deadline
+	prompt := make([]byte, 4*1024*1024)
+	if _, err := rand.Read(prompt); err != nil {
+		t.Fatalf("Failed to generate prompt: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := session.roundTrip(ctx, crowdllama.CreateGenerateRequest("echo", hex.EncodeToString(prompt), false))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, errSessionClosed) {
+			t.Fatalf("Expected errSessionClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the write to give up at the request's deadline")
+	}
+	_, err = session.roundTrip(context.Background(), crowdllama.CreateGenerateRequest("echo", "hello", false))
+	if !errors.Is(err, errSessionClosed) {
+		t.Errorf("Expected the session to be closed after a failed write, got %v", err)
+	}
+}
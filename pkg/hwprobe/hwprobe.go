@@ -0,0 +1,257 @@
+// Package hwprobe measures how busy the local machine is with work other than the worker's inference backend.
+package hwprobe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// commLength is the length /proc and nvidia-smi truncate process names to
+const commLength = 15
+
+// DefaultExcludedProcesses are the process names of CrowdLlama and common inference backends, whose load is
+// the worker's own
+var DefaultExcludedProcesses = []string{"crowdllama", "ollama", "ollama_llama_server", "llama-server", "vllm"}
+
+// Usage is the share of the machine's capacity used by processes other than the excluded ones, from 0 to 1
+type Usage struct {
+	CPU    float64
+	GPU    float64
+	HasGPU bool // false if GPU utilization could not be read, e.g. without an NVIDIA GPU
+}
+
+// Max returns the higher of the CPU and GPU usage
+func (u Usage) Max() float64 {
+	return max(u.CPU, u.GPU)
+}
+
+// Probe samples CPU usage from /proc and GPU usage from nvidia-smi, leaving out the excluded processes
+type Probe struct {
+	excluded   []string
+	self       int
+	procRoot   string
+	runCommand func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+	prev *cpuSample // CPU counters of the previous sample, nil before the first
+}
+
+// cpuSample holds CPU time counters in clock ticks
+type cpuSample struct {
+	total    uint64
+	idle     uint64
+	excluded map[int]uint64 // CPU time of each excluded process
+}
+
+// New creates a probe ignoring the processes with the given names, as well as the current process
+func New(excluded []string) *Probe {
+	return &Probe{
+		excluded:   excluded,
+		self:       os.Getpid(),
+		procRoot:   "/proc",
+		runCommand: runCommand,
+	}
+}
+
+// runCommand runs a command and returns its standard output
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("run %s: %w", name, err)
+	}
+	return out, nil
+}
+
+// Sample returns the usage since the previous call. CPU usage is measured between calls, so the first call
+// only records a baseline and reports no CPU usage. An error is returned only if CPU usage cannot be read.
+func (p *Probe) Sample(ctx context.Context) (Usage, error) {
+	var usage Usage
+	cur, err := p.readCPU()
+	if err != nil {
+		return usage, err
+	}
+	if p.prev != nil {
+		usage.CPU = foreignCPU(p.prev, cur)
+	}
+	p.prev = cur
+
+	if gpu, err := p.readGPU(ctx); err == nil {
+		usage.GPU, usage.HasGPU = gpu, true
+	}
+	return usage, nil
+}
+
+// foreignCPU returns the share of CPU time between two samples spent busy in processes that are not excluded
+func foreignCPU(prev, cur *cpuSample) float64 {
+	if cur.total <= prev.total {
+		return 0
+	}
+	total := float64(cur.total - prev.total)
+	busy := total - float64(cur.idle-prev.idle)
+
+	// Processes started since the previous sample spent all their CPU time within the interval
+	var excluded float64
+	for pid, ticks := range cur.excluded {
+		if before, ok := prev.excluded[pid]; ok && before <= ticks {
+			excluded += float64(ticks - before)
+		} else if !ok {
+			excluded += float64(ticks)
+		}
+	}
+	return clamp((busy - excluded) / total)
+}
+
+// readCPU reads the machine's CPU counters and the CPU time of the excluded processes
+func (p *Probe) readCPU() (*cpuSample, error) {
+	data, err := os.ReadFile(filepath.Join(p.procRoot, "stat"))
+	if err != nil {
+		return nil, fmt.Errorf("read CPU counters: %w", err)
+	}
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	fields := strings.Fields(string(line))
+	if len(fields) < 9 || fields[0] != "cpu" {
+		return nil, errors.New("read CPU counters: unexpected format")
+	}
+
+	// user nice system idle iowait irq softirq steal; guest time is already counted in user
+	sample := &cpuSample{excluded: make(map[int]uint64)}
+	for i, field := range fields[1:9] {
+		ticks, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("read CPU counters: %w", err)
+		}
+		sample.total += ticks
+		if i == 3 || i == 4 {
+			sample.idle += ticks
+		}
+	}
+
+	entries, err := os.ReadDir(p.procRoot)
+	if err != nil {
+		return nil, fmt.Errorf("list processes: %w", err)
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		name, ticks, err := p.readProcess(pid)
+		if err != nil || (pid != p.self && !p.isExcluded(name)) {
+			continue
+		}
+		sample.excluded[pid] = ticks
+	}
+	return sample, nil
+}
+
+// readProcess returns the name and CPU time of a process
+func (p *Probe) readProcess(pid int) (string, uint64, error) {
+	data, err := os.ReadFile(filepath.Join(p.procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return "", 0, fmt.Errorf("read process stat: %w", err)
+	}
+
+	// The name is in parentheses and may itself contain spaces and parentheses
+	stat := string(data)
+	open, end := strings.IndexByte(stat, '('), strings.LastIndexByte(stat, ')')
+	if open < 0 || end < open {
+		return "", 0, errors.New("read process stat: unexpected format")
+	}
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 13 {
+		return "", 0, errors.New("read process stat: unexpected format")
+	}
+	utime, uErr := strconv.ParseUint(fields[11], 10, 64)
+	stime, sErr := strconv.ParseUint(fields[12], 10, 64)
+	if uErr != nil || sErr != nil {
+		return "", 0, errors.New("read process stat: invalid CPU time")
+	}
+	return stat[open+1 : end], utime + stime, nil
+}
+
+// readGPU returns the share of GPU compute used by processes that are not excluded, averaged over all GPUs
+func (p *Probe) readGPU(ctx context.Context) (float64, error) {
+	out, err := p.runCommand(ctx, "nvidia-smi", "pmon", "-c", "1", "-s", "u")
+	if err != nil {
+		return 0, fmt.Errorf("read GPU usage: %w", err)
+	}
+
+	var columns *pmonColumns
+	gpus := make(map[string]bool)
+	var used float64
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 0:
+			continue
+		case fields[0] == "#":
+			// The first header line names the columns, which differ between driver versions
+			if columns == nil {
+				columns = parsePmonHeader(fields[1:])
+			}
+			continue
+		case columns == nil || len(fields) <= columns.name:
+			continue
+		}
+
+		gpus[fields[0]] = true
+		pid, pidErr := strconv.Atoi(fields[columns.pid])
+		sm, smErr := strconv.ParseFloat(fields[columns.sm], 64)
+		// Idle GPUs are listed with "-" in every column
+		if pidErr != nil || smErr != nil || pid == p.self || p.isExcluded(fields[columns.name]) {
+			continue
+		}
+		used += sm
+	}
+	if len(gpus) == 0 {
+		return 0, errors.New("read GPU usage: nvidia-smi listed no GPUs")
+	}
+	return clamp(used / float64(100*len(gpus))), nil
+}
+
+// pmonColumns are the indexes of the columns of nvidia-smi pmon the probe reads
+type pmonColumns struct {
+	pid, sm, name int
+}
+
+// parsePmonHeader finds the columns in the header of nvidia-smi pmon, or returns nil if one is missing
+func parsePmonHeader(header []string) *pmonColumns {
+	columns := pmonColumns{pid: -1, sm: -1, name: -1}
+	for i, column := range header {
+		switch column {
+		case "pid":
+			columns.pid = i
+		case "sm":
+			columns.sm = i
+		case "command":
+			columns.name = i
+		}
+	}
+	if columns.pid < 0 || columns.sm < 0 || columns.name < 0 {
+		return nil
+	}
+	return &columns
+}
+
+// isExcluded returns true if name is one of the excluded process names, or one truncated by /proc or nvidia-smi
+func (p *Probe) isExcluded(name string) bool {
+	for _, excluded := range p.excluded {
+		if name == excluded || (len(name) >= commLength && strings.HasPrefix(excluded, name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// clamp limits a share to the range from 0 to 1
+func clamp(share float64) float64 {
+	return min(max(share, 0), 1)
+}
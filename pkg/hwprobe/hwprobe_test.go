@@ -0,0 +1,98 @@
+package hwprobe
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pmonOutput is nvidia-smi pmon output for two GPUs, one running a game and the worker's backend
+const pmonOutput = `# gpu         pid  type    sm    mem    enc    dec    jpg    ofa    command
+# Idx           #   C/G     %      %      %      %      %      %    name
+    0       2001     G    60     20      -      -      -      -    Game.exe
+    0       3001     C    30     40      -      -      -      -    ollama_llama_se
+    1          -     -     -      -      -      -      -      -    -
+`
+
+// writeProc writes /proc/stat and the stat files of the given processes to a fake proc directory
+func writeProc(t *testing.T, root, cpu string, processes map[string]string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(root, "stat"), []byte(cpu+"\ncpu0 0 0 0 0 0 0 0 0 0 0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for pid, stat := range processes {
+		if err := os.MkdirAll(filepath.Join(root, pid), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, pid, "stat"), []byte(stat), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// procStat returns a /proc/<pid>/stat line with the given name and CPU time
+func procStat(pid, name, utime, stime string) string {
+	return pid + " (" + name + ") S 1 1 1 0 -1 0 0 0 0 0 " + utime + " " + stime + " 0 0 20 0 1 0 100 0 0"
+}
+
+func TestProbeSample(t *testing.T) {
+	root := t.TempDir()
+	probe := New(DefaultExcludedProcesses)
+	probe.procRoot = root
+	probe.runCommand = func(context.Context, string, ...string) ([]byte, error) {
+		return []byte(pmonOutput), nil
+	}
+
+	writeProc(t, root, "cpu  100 0 100 800 0 0 0 0 0 0", map[string]string{
+		"3001": procStat("3001", "ollama_llama_se", "50", "0"),
+		"4001": procStat("4001", "my (game) app", "10", "0"),
+	})
+	usage, err := probe.Sample(context.Background())
+	if err != nil {
+		t.Fatalf("Sample failed: %v", err)
+	}
+	if usage.CPU != 0 {
+		t.Errorf("Expected no CPU usage from the first sample, got %v", usage.CPU)
+	}
+	// The game uses 60% of one of two GPUs; the backend's 30% is left out
+	if !usage.HasGPU || math.Abs(usage.GPU-0.3) > 1e-9 {
+		t.Errorf("Expected 30%% GPU usage, got %+v", usage)
+	}
+
+	// 1000 ticks pass with 600 busy, 200 of them in the backend
+	writeProc(t, root, "cpu  500 0 300 1200 0 0 0 0 0 0", map[string]string{
+		"3001": procStat("3001", "ollama_llama_se", "200", "50"),
+		"4001": procStat("4001", "my (game) app", "400", "10"),
+	})
+	usage, err = probe.Sample(context.Background())
+	if err != nil {
+		t.Fatalf("Sample failed: %v", err)
+	}
+	if math.Abs(usage.CPU-0.4) > 1e-9 {
+		t.Errorf("Expected 40%% foreign CPU usage, got %v", usage.CPU)
+	}
+	if usage.Max() != usage.CPU {
+		t.Errorf("Expected Max to return the CPU usage, got %v", usage.Max())
+	}
+}
+
+func TestProbeWithoutGPU(t *testing.T) {
+	root := t.TempDir()
+	writeProc(t, root, "cpu  100 0 100 800 0 0 0 0 0 0", nil)
+	probe := New(nil)
+	probe.procRoot = root
+	probe.runCommand = func(context.Context, string, ...string) ([]byte, error) {
+		return nil, errors.New("executable file not found")
+	}
+
+	usage, err := probe.Sample(context.Background())
+	if err != nil {
+		t.Fatalf("Expected a missing nvidia-smi not to fail the sample, got %v", err)
+	}
+	if usage.HasGPU {
+		t.Error("Expected no GPU usage without nvidia-smi")
+	}
+}
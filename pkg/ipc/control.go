@@ -0,0 +1,79 @@
+package ipc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/crowdllama/crowdllama/pkg/peer"
+)
+
+// pauseDrainTimeout bounds how long a pause message waits for in-flight requests before answering
+const pauseDrainTimeout = 5 * time.Minute
+
+// handleAvailabilityMessage pauses or resumes the worker and answers with its availability. A pause is
+// answered once the requests in flight have finished.
+func (s *Server) handleAvailabilityMessage(baseMsg BaseMessage, conn net.Conn) {
+	p, ok := s.peerInstance.(*peer.Peer)
+	if !ok || !p.WorkerMode {
+		s.sendErrorResponse(conn, "Pause and resume need a running worker")
+		return
+	}
+
+	var err error
+	if baseMsg.Type == MessageTypePause {
+		ctx, cancel := context.WithTimeout(context.Background(), pauseDrainTimeout)
+		defer cancel()
+		err = p.Pause(ctx)
+	} else {
+		p.Resume()
+	}
+
+	response := ResponseMessage{
+		BaseMessage: BaseMessage{Type: MessageTypeResponse, ID: baseMsg.ID},
+		Payload:     p.Availability(),
+		Success:     err == nil,
+	}
+	if err != nil {
+		response.Error = err.Error()
+	}
+	if err := s.sendToIPC(conn, response, MessageTypeResponse); err != nil {
+		s.logger.Error("Failed to send availability response", zap.Error(err))
+	}
+}
+
+// SendCommand sends a message without arguments, such as MessageTypePause, to the IPC server listening on
+// socketPath and returns its response
+func SendCommand(ctx context.Context, socketPath, messageType string) (*ResponseMessage, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("connect to IPC socket: %w", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, fmt.Errorf("set IPC deadline: %w", err)
+		}
+	}
+
+	data, err := json.Marshal(BaseMessage{Type: messageType})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal IPC message: %w", err)
+	}
+	if _, err := conn.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to write to IPC connection: %w", err)
+	}
+
+	var response ResponseMessage
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return nil, fmt.Errorf("read IPC response: %w", err)
+	}
+	return &response, nil
+}
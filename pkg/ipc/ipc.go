@@ -32,6 +32,8 @@ const (
 	MessageTypePrompt           = "prompt"
 	MessageTypePromptResponse   = "prompt_response"
 	MessageTypeResponse         = "response"
+	MessageTypePause            = "pause"
+	MessageTypeResume           = "resume"
 )
 
 // BaseMessage represents the common structure for all IPC messages
@@ -135,6 +137,15 @@ func (s *Server) SetAPIHandler(handler crowdllama.UnifiedAPIHandler) {
 	s.apiHandler = handler
 }
 
+// handlerContext returns the context for API handler calls, carrying the local peer's identity when known
+func (s *Server) handlerContext() context.Context {
+	ctx := context.Background()
+	if p, ok := s.peerInstance.(*peer.Peer); ok && p.Host != nil {
+		ctx = crowdllama.WithWorkerID(ctx, p.Host.ID().String())
+	}
+	return ctx
+}
+
 // GetCurrentMode returns the current mode
 func (s *Server) GetCurrentMode() string {
 	return s.currentMode
@@ -269,6 +280,8 @@ func (s *Server) handleMessage(message []byte, conn net.Conn) {
 		s.handleInitializeMessage(message, conn)
 	case MessageTypePrompt:
 		s.handlePromptMessage(message, conn)
+	case MessageTypePause, MessageTypeResume:
+		s.handleAvailabilityMessage(baseMsg, conn)
 	default:
 		s.handleUnknownMessage(baseMsg, conn)
 	}
@@ -294,8 +307,7 @@ func (s *Server) handleProtobufMessage(message []byte, conn net.Conn) {
 		zap.String("model", generateReq.GetModel()),
 		zap.String("prompt", generateReq.GetPrompt()))
 
-	ctx := context.Background()
-	pbResp, err := s.apiHandler(ctx, &pbReq)
+	pbResp, err := s.apiHandler(s.handlerContext(), &pbReq)
 	if err != nil {
 		s.logger.Error("Failed to process protobuf prompt", zap.Error(err))
 		s.sendErrorResponse(conn, fmt.Sprintf("Failed to process prompt: %v", err))
@@ -453,8 +465,7 @@ func (s *Server) handlePromptMessage(message []byte, conn net.Conn) {
 		zap.String("model", generateReq.GetModel()),
 		zap.String("prompt", generateReq.GetPrompt()))
 
-	ctx := context.Background()
-	pbResp, err := s.apiHandler(ctx, &pbReq)
+	pbResp, err := s.apiHandler(s.handlerContext(), &pbReq)
 	if err != nil {
 		s.logger.Error("Failed to process prompt", zap.Error(err))
 		s.sendErrorResponse(conn, fmt.Sprintf("Failed to process prompt: %v", err))
@@ -0,0 +1,222 @@
+// Package ledger keeps account of the tokens peers served each other, backed by receipts both peers signed.
+package ledger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// MaxReceiptAge is how old a receipt may be when it is recorded. Older receipts are refused, so the ledger
+// only needs to remember the IDs of recent receipts to refuse receipts recorded twice.
+const MaxReceiptAge = time.Hour
+
+// ErrDuplicateReceipt is returned for a receipt that was already recorded
+var ErrDuplicateReceipt = errors.New("receipt already recorded")
+
+// Balance sums up the receipts exchanged with one peer
+type Balance struct {
+	Peer     string `json:"peer"`
+	Served   int64  `json:"served"`   // tokens this peer served the other
+	Received int64  `json:"received"` // tokens the other peer served this one
+	Receipts int    `json:"receipts"`
+}
+
+// Credit returns the tokens the other peer owes this one; it is negative if this peer owes the other
+func (b Balance) Credit() int64 {
+	return b.Served - b.Received
+}
+
+// Ledger records receipts in an append-only file of JSON lines and keeps the balance of every peer
+type Ledger struct {
+	path   string
+	self   peer.ID
+	logger *zap.Logger
+	now    func() time.Time
+
+	mu        sync.Mutex
+	file      *os.File
+	balances  map[string]*Balance
+	seen      map[string]time.Time // issue time of recent receipts by ID
+	lastSweep time.Time
+}
+
+// Open loads the ledger of self from path, creating the file if it does not exist
+func Open(path string, self peer.ID, logger *zap.Logger) (*Ledger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create ledger directory: %w", err)
+	}
+
+	l := &Ledger{
+		path:     path,
+		self:     self,
+		logger:   logger,
+		now:      time.Now,
+		balances: make(map[string]*Balance),
+		seen:     make(map[string]time.Time),
+	}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ledger %s: %w", path, err)
+	}
+	l.file = file
+	return l, nil
+}
+
+// load replays the receipts already in the ledger file
+func (l *Ledger) load() error {
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open ledger %s: %w", l.path, err)
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil {
+			l.logger.Debug("Failed to close ledger", zap.Error(closeErr))
+		}
+	}()
+
+	receipts, skipped := 0, 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, MaxReceiptSize), 2*MaxReceiptSize)
+	for scanner.Scan() {
+		r, err := ParseReceipt(scanner.Bytes())
+		if err != nil || l.apply(r) != nil {
+			// A damaged line, e.g. from a crash while writing, must not prevent the node from starting
+			skipped++
+			continue
+		}
+		receipts++
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read ledger %s: %w", l.path, err)
+	}
+
+	l.logger.Info("Loaded credit ledger",
+		zap.String("path", l.path),
+		zap.Int("receipts", receipts),
+		zap.Int("skipped", skipped),
+		zap.Int("peers", len(l.balances)))
+	return nil
+}
+
+// Record verifies a receipt signed by both peers, one of which must be this peer, and adds it to the ledger
+func (l *Ledger) Record(r *Receipt) error {
+	if err := r.Verify(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	switch {
+	case r.Worker != l.self.String() && r.Consumer != l.self.String():
+		return fmt.Errorf("%w: %s is neither its worker nor its consumer", ErrInvalidReceipt, l.self)
+	case now.Sub(r.Issued) > MaxReceiptAge:
+		return fmt.Errorf("%w: issued at %s", ErrInvalidReceipt, r.Issued.Format(time.RFC3339))
+	case r.Issued.Sub(now) > MaxReceiptAge:
+		return fmt.Errorf("%w: issued in the future at %s", ErrInvalidReceipt, r.Issued.Format(time.RFC3339))
+	case !l.seen[r.ID].IsZero():
+		return fmt.Errorf("%w: %s", ErrDuplicateReceipt, r.ID)
+	}
+
+	data, err := r.Marshal()
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write ledger %s: %w", l.path, err)
+	}
+	l.sweepLocked(now)
+	return l.apply(r)
+}
+
+// apply adds a receipt to the balance of the other peer. The caller must hold l.mu or be loading the ledger.
+func (l *Ledger) apply(r *Receipt) error {
+	var other string
+	var served bool
+	switch l.self.String() {
+	case r.Worker:
+		other, served = r.Consumer, true
+	case r.Consumer:
+		other = r.Worker
+	default:
+		return fmt.Errorf("%w: %s is neither its worker nor its consumer", ErrInvalidReceipt, l.self)
+	}
+
+	balance, ok := l.balances[other]
+	if !ok {
+		balance = &Balance{Peer: other}
+		l.balances[other] = balance
+	}
+	if served {
+		balance.Served += r.Tokens()
+	} else {
+		balance.Received += r.Tokens()
+	}
+	balance.Receipts++
+	if l.now().Sub(r.Issued) <= MaxReceiptAge {
+		l.seen[r.ID] = r.Issued
+	}
+	return nil
+}
+
+// sweepLocked forgets the IDs of receipts too old to be recorded again. The caller must hold l.mu.
+func (l *Ledger) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < MaxReceiptAge {
+		return
+	}
+	l.lastSweep = now
+	for id, issued := range l.seen {
+		if now.Sub(issued) > MaxReceiptAge {
+			delete(l.seen, id)
+		}
+	}
+}
+
+// Balance returns the balance with a peer
+func (l *Ledger) Balance(id peer.ID) Balance {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if balance, ok := l.balances[id.String()]; ok {
+		return *balance
+	}
+	return Balance{Peer: id.String()}
+}
+
+// Balances returns the balances with all peers, ordered by peer ID
+func (l *Ledger) Balances() []Balance {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	balances := make([]Balance, 0, len(l.balances))
+	for _, balance := range l.balances {
+		balances = append(balances, *balance)
+	}
+	sort.Slice(balances, func(i, j int) bool { return balances[i].Peer < balances[j].Peer })
+	return balances
+}
+
+// Close closes the ledger file
+func (l *Ledger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close ledger %s: %w", l.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package ledger
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+
+	"github.com/crowdllama/crowdllama/pkg/testhelpers/testkeys"
+)
+
+// signedReceipt returns a receipt for tokens served by worker to consumer, signed by both
+func signedReceipt(t *testing.T, workerKey, consumerKey crypto.PrivKey, tokens int64, issued time.Time) *Receipt {
+	t.Helper()
+	worker, _ := peer.IDFromPrivateKey(workerKey)
+	consumer, _ := peer.IDFromPrivateKey(consumerKey)
+	r, err := NewReceipt(worker, consumer, "llama3.2", tokens/2, tokens-tokens/2, issued)
+	if err != nil {
+		t.Fatalf("Failed to create receipt: %v", err)
+	}
+	if err := r.Sign(workerKey); err != nil {
+		t.Fatalf("Failed to sign receipt as worker: %v", err)
+	}
+	if err := r.Sign(consumerKey); err != nil {
+		t.Fatalf("Failed to sign receipt as consumer: %v", err)
+	}
+	return r
+}
+
+func TestReceiptSignatures(t *testing.T) {
+	workerKey, worker := testkeys.NewKey(t)
+	consumerKey, consumer := testkeys.NewKey(t)
+	strangerKey, _ := testkeys.NewKey(t)
+
+	r, err := NewReceipt(worker, consumer, "llama3.2", 10, 20, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to create receipt: %v", err)
+	}
+	if err := r.Sign(strangerKey); !errors.Is(err, ErrInvalidReceipt) {
+		t.Errorf("Expected a third party not to be able to sign, got %v", err)
+	}
+	if err := r.Sign(workerKey); err != nil {
+		t.Fatalf("Failed to sign receipt: %v", err)
+	}
+	if err := r.Verify(); !errors.Is(err, ErrInvalidReceipt) {
+		t.Errorf("Expected a receipt without the consumer's signature to fail verification, got %v", err)
+	}
+
+	data, err := r.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to encode receipt: %v", err)
+	}
+	parsed, err := ParseReceipt(data)
+	if err != nil {
+		t.Fatalf("Failed to parse receipt: %v", err)
+	}
+	if err := parsed.VerifyWorker(); err != nil {
+		t.Fatalf("Expected the worker's signature to survive encoding, got %v", err)
+	}
+	if err := parsed.Sign(consumerKey); err != nil {
+		t.Fatalf("Failed to countersign receipt: %v", err)
+	}
+	if err := parsed.Verify(); err != nil {
+		t.Errorf("Expected a countersigned receipt to verify, got %v", err)
+	}
+
+	parsed.CompletionTokens = 2000
+	if err := parsed.Verify(); !errors.Is(err, ErrInvalidReceipt) {
+		t.Errorf("Expected an altered receipt to fail verification, got %v", err)
+	}
+}
+
+func TestLedger(t *testing.T) {
+	workerKey, worker := testkeys.NewKey(t)
+	consumerKey, consumer := testkeys.NewKey(t)
+	otherKey, other := testkeys.NewKey(t)
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	l, err := Open(path, consumer, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to open ledger: %v", err)
+	}
+	l.now = func() time.Time { return now }
+
+	received := signedReceipt(t, workerKey, consumerKey, 30, now)
+	if err := l.Record(received); err != nil {
+		t.Fatalf("Failed to record receipt: %v", err)
+	}
+	if err := l.Record(received); !errors.Is(err, ErrDuplicateReceipt) {
+		t.Errorf("Expected a receipt recorded twice to be refused, got %v", err)
+	}
+	// The consumer also works for other peers
+	if err := l.Record(signedReceipt(t, consumerKey, workerKey, 50, now)); err != nil {
+		t.Fatalf("Failed to record served receipt: %v", err)
+	}
+	if err := l.Record(signedReceipt(t, workerKey, otherKey, 10, now)); !errors.Is(err, ErrInvalidReceipt) {
+		t.Errorf("Expected a receipt between other peers to be refused, got %v", err)
+	}
+	if err := l.Record(signedReceipt(t, otherKey, consumerKey, 10, now.Add(-2*MaxReceiptAge))); !errors.Is(err, ErrInvalidReceipt) {
+		t.Errorf("Expected an old receipt to be refused, got %v", err)
+	}
+	unsigned, err := NewReceipt(other, consumer, "llama3.2", 10, 10, now)
+	if err != nil {
+		t.Fatalf("Failed to create receipt: %v", err)
+	}
+	if err := l.Record(unsigned); !errors.Is(err, ErrInvalidReceipt) {
+		t.Errorf("Expected an unsigned receipt to be refused, got %v", err)
+	}
+
+	want := Balance{Peer: worker.String(), Served: 50, Received: 30, Receipts: 2}
+	if got := l.Balance(worker); got != want || got.Credit() != 20 {
+		t.Errorf("Expected balance %+v, got %+v", want, got)
+	}
+	if got := l.Balance(other); got.Receipts != 0 {
+		t.Errorf("Expected no balance with a peer without receipts, got %+v", got)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("Failed to close ledger: %v", err)
+	}
+
+	// Balances are restored from the file, skipping damaged lines
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatalf("Failed to open ledger file: %v", err)
+	}
+	if _, err := file.WriteString("{\"id\":\n"); err != nil {
+		t.Fatalf("Failed to damage ledger file: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("Failed to close ledger file: %v", err)
+	}
+	reopened, err := Open(path, consumer, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to reopen ledger: %v", err)
+	}
+	defer func() {
+		if err := reopened.Close(); err != nil {
+			t.Errorf("Failed to close ledger: %v", err)
+		}
+	}()
+	if got := reopened.Balances(); len(got) != 1 || got[0] != want {
+		t.Errorf("Expected the balance to be restored, got %+v", got)
+	}
+}
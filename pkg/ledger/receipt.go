@@ -0,0 +1,157 @@
+package ledger
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// receiptDomain is prepended to the signed claims so that receipt signatures cannot be mistaken for
+// signatures a peer's key makes for other purposes
+const receiptDomain = "crowdllama-receipt:"
+
+// MaxReceiptSize bounds the size of an encoded receipt
+const MaxReceiptSize = 4096
+
+// ErrInvalidReceipt is returned for receipts that are malformed, badly signed or not meant for this peer
+var ErrInvalidReceipt = errors.New("invalid receipt")
+
+// ReceiptClaims describe the tokens a worker served a consumer for one request
+type ReceiptClaims struct {
+	ID               string    `json:"id"`
+	Worker           string    `json:"worker"`   // peer ID of the worker that served the request
+	Consumer         string    `json:"consumer"` // peer ID of the consumer that sent it
+	Model            string    `json:"model"`
+	PromptTokens     int64     `json:"prompt_tokens"`
+	CompletionTokens int64     `json:"completion_tokens"`
+	Issued           time.Time `json:"issued"`
+}
+
+// Receipt is a ReceiptClaims signed by the worker and, once it accepted them, the consumer
+type Receipt struct {
+	ReceiptClaims
+	WorkerSignature   []byte `json:"worker_signature,omitempty"`
+	ConsumerSignature []byte `json:"consumer_signature,omitempty"`
+}
+
+// NewReceipt creates an unsigned receipt for a request worker served consumer
+func NewReceipt(worker, consumer peer.ID, model string, promptTokens, completionTokens int64, issued time.Time) (*Receipt, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("generate receipt ID: %w", err)
+	}
+	return &Receipt{ReceiptClaims: ReceiptClaims{
+		ID:               hex.EncodeToString(id),
+		Worker:           worker.String(),
+		Consumer:         consumer.String(),
+		Model:            model,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		Issued:           issued.UTC().Truncate(time.Second),
+	}}, nil
+}
+
+// ParseReceipt decodes a receipt. It does not verify the signatures.
+func ParseReceipt(data []byte) (*Receipt, error) {
+	if len(data) > MaxReceiptSize {
+		return nil, fmt.Errorf("%w: %d bytes exceeds the limit of %d", ErrInvalidReceipt, len(data), MaxReceiptSize)
+	}
+	var r Receipt
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidReceipt, err)
+	}
+	return &r, nil
+}
+
+// Marshal encodes the receipt
+func (r *Receipt) Marshal() ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("encode receipt: %w", err)
+	}
+	return data, nil
+}
+
+// Tokens returns the prompt and completion tokens of the receipt
+func (r *Receipt) Tokens() int64 {
+	return r.PromptTokens + r.CompletionTokens
+}
+
+// Sign signs the receipt as the worker or the consumer, whichever key is given
+func (r *Receipt) Sign(key crypto.PrivKey) error {
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("derive signer peer ID: %w", err)
+	}
+	claims, err := r.signedClaims()
+	if err != nil {
+		return err
+	}
+	signature, err := key.Sign(claims)
+	if err != nil {
+		return fmt.Errorf("sign receipt: %w", err)
+	}
+
+	switch id.String() {
+	case r.Worker:
+		r.WorkerSignature = signature
+	case r.Consumer:
+		r.ConsumerSignature = signature
+	default:
+		return fmt.Errorf("%w: %s is neither its worker nor its consumer", ErrInvalidReceipt, id)
+	}
+	return nil
+}
+
+// VerifyWorker checks the worker's signature
+func (r *Receipt) VerifyWorker() error {
+	return r.verify("worker", r.Worker, r.WorkerSignature)
+}
+
+// Verify checks the signatures of both the worker and the consumer
+func (r *Receipt) Verify() error {
+	if err := r.VerifyWorker(); err != nil {
+		return err
+	}
+	return r.verify("consumer", r.Consumer, r.ConsumerSignature)
+}
+
+// verify checks the signature of a party with the key embedded in its peer ID
+func (r *Receipt) verify(party, signer string, signature []byte) error {
+	if len(signature) == 0 {
+		return fmt.Errorf("%w: missing %s signature", ErrInvalidReceipt, party)
+	}
+	id, err := peer.Decode(signer)
+	if err != nil {
+		return fmt.Errorf("%w: %s peer ID: %w", ErrInvalidReceipt, party, err)
+	}
+	// Ed25519 peer IDs embed the public key; peers with other key types cannot be verified
+	key, err := id.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("%w: %s %s has no embedded public key: %w", ErrInvalidReceipt, party, signer, err)
+	}
+	claims, err := r.signedClaims()
+	if err != nil {
+		return err
+	}
+	valid, err := key.Verify(claims, signature)
+	if err != nil || !valid {
+		return fmt.Errorf("%w: bad %s signature", ErrInvalidReceipt, party)
+	}
+	return nil
+}
+
+// signedClaims returns the bytes both parties sign
+func (r *Receipt) signedClaims() ([]byte, error) {
+	claims, err := json.Marshal(r.ReceiptClaims)
+	if err != nil {
+		return nil, fmt.Errorf("encode receipt claims: %w", err)
+	}
+	return append([]byte(receiptDomain), claims...), nil
+}
@@ -0,0 +1,228 @@
+// Package modelpull pulls models a worker is asked for but does not have, once enough demand is observed.
+package modelpull
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+)
+
+// Pull states reported in crowdllama.ModelPull
+const (
+	StatusPulling = "pulling"
+	StatusFailed  = "failed"
+)
+
+// DefaultThreshold is the number of peers asking for a missing model that trigger a pull
+const DefaultThreshold = 3
+
+// DefaultWindow is how long demand for a model is remembered, and how long a failed pull is not retried
+const DefaultWindow = 10 * time.Minute
+
+// errQuotaExceeded is returned when a model does not fit in the disk quota
+var errQuotaExceeded = errors.New("model does not fit in the disk quota")
+
+// errModelTooLarge is returned when a model exceeds the size limit
+var errModelTooLarge = errors.New("model exceeds the size limit")
+
+// Backend stores models and downloads new ones; *crowdllama.OllamaBackend implements it
+type Backend interface {
+	PullModel(ctx context.Context, model string, progress func(crowdllama.PullProgress)) error
+	ModelDiskUsage(ctx context.Context) (int64, error)
+}
+
+// Config controls when models are pulled
+type Config struct {
+	Threshold    int                     // peers asking for a missing model within Window that trigger a pull; 0 uses DefaultThreshold
+	Window       time.Duration           // 0 uses DefaultWindow
+	DiskQuota    int64                   // bytes all stored models may take together; 0 means no limit
+	MaxModelSize int64                   // largest model in bytes that may be pulled; 0 means no limit
+	Allow        func(model string) bool // models that may be pulled; nil allows every model
+}
+
+// Puller counts the peers asking for missing models and pulls a model once enough distinct peers asked for it,
+// so a single peer cannot make the worker download a model. It runs one pull at a time.
+type Puller struct {
+	ctx     context.Context
+	backend Backend
+	cfg     Config
+	logger  *zap.Logger
+
+	mu       sync.Mutex
+	demand   map[string]map[string]time.Time  // time of the last request per peer and missing model, within the window
+	pulls    map[string]*crowdllama.ModelPull // running and recently failed pulls
+	failedAt map[string]time.Time
+	running  bool
+}
+
+// New creates a puller; pulls stop when ctx is done
+func New(ctx context.Context, backend Backend, cfg Config, logger *zap.Logger) *Puller {
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = DefaultThreshold
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultWindow
+	}
+	return &Puller{
+		ctx:      ctx,
+		backend:  backend,
+		cfg:      cfg,
+		logger:   logger,
+		demand:   make(map[string]map[string]time.Time),
+		pulls:    make(map[string]*crowdllama.ModelPull),
+		failedAt: make(map[string]time.Time),
+	}
+}
+
+// RecordDemand notes that a peer asked for a model the worker does not have, and starts pulling it once the
+// number of peers asking reaches the threshold. Models being pulled or whose pull failed within the window are
+// ignored.
+func (p *Puller) RecordDemand(model, peerID string) {
+	if model == "" || (p.cfg.Allow != nil && !p.cfg.Allow(model)) {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.expire(now)
+	if _, ok := p.pulls[model]; ok {
+		return
+	}
+
+	if p.demand[model] == nil {
+		p.demand[model] = make(map[string]time.Time)
+	}
+	p.demand[model][peerID] = now
+	if len(p.demand[model]) < p.cfg.Threshold || p.running {
+		return
+	}
+
+	delete(p.demand, model)
+	p.running = true
+	p.pulls[model] = &crowdllama.ModelPull{Model: model, Status: StatusPulling}
+	p.logger.Info("Pulling model on demand", zap.String("model", model))
+	go p.pull(model)
+}
+
+// Progress returns the running and recently failed pulls, sorted by model
+func (p *Puller) Progress() []crowdllama.ModelPull {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire(time.Now())
+	progress := make([]crowdllama.ModelPull, 0, len(p.pulls))
+	for _, pull := range p.pulls {
+		progress = append(progress, *pull)
+	}
+	sort.Slice(progress, func(i, j int) bool { return progress[i].Model < progress[j].Model })
+	return progress
+}
+
+// pull downloads a model and records the outcome
+func (p *Puller) pull(model string) {
+	ctx, cancel := context.WithCancelCause(p.ctx)
+	defer cancel(nil)
+	err := p.download(ctx, cancel, model)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running = false
+	if err == nil {
+		delete(p.pulls, model)
+		p.logger.Info("Pulled model on demand", zap.String("model", model))
+		return
+	}
+
+	pull := p.pulls[model]
+	pull.Status = StatusFailed
+	pull.Error = err.Error()
+	p.failedAt[model] = time.Now()
+	p.logger.Warn("Failed to pull model", zap.String("model", model), zap.Error(err))
+}
+
+// download pulls a model, enforcing the size limit and disk quota as the layer sizes become known
+func (p *Puller) download(ctx context.Context, cancel context.CancelCauseFunc, model string) error {
+	var usage int64
+	if p.cfg.DiskQuota > 0 {
+		var err error
+		if usage, err = p.backend.ModelDiskUsage(ctx); err != nil {
+			return fmt.Errorf("check disk usage: %w", err)
+		}
+		if usage >= p.cfg.DiskQuota {
+			return fmt.Errorf("%w: stored models already use %d of %d bytes", errQuotaExceeded, usage, p.cfg.DiskQuota)
+		}
+	}
+
+	err := p.backend.PullModel(ctx, model, p.progressFunc(model, usage, cancel))
+	if cause := context.Cause(ctx); errors.Is(cause, errQuotaExceeded) || errors.Is(cause, errModelTooLarge) {
+		return cause
+	}
+	if err != nil {
+		return fmt.Errorf("pull model: %w", err)
+	}
+	return nil
+}
+
+// progressFunc returns the progress callback of a pull. Ollama reports progress per layer, so the sizes of all
+// layers seen so far are summed; the pull is cancelled as soon as they exceed the size limit or the quota
+// left after usage.
+func (p *Puller) progressFunc(model string, usage int64, cancel context.CancelCauseFunc) func(crowdllama.PullProgress) {
+	type layer struct{ total, completed int64 }
+	layers := make(map[string]layer)
+
+	return func(update crowdllama.PullProgress) {
+		if update.Digest == "" || update.Total == 0 {
+			return
+		}
+		layers[update.Digest] = layer{total: update.Total, completed: update.Completed}
+
+		var total, completed int64
+		for _, l := range layers {
+			total += l.total
+			completed += l.completed
+		}
+
+		p.mu.Lock()
+		p.pulls[model].Total = total
+		p.pulls[model].Completed = completed
+		p.mu.Unlock()
+
+		if p.cfg.MaxModelSize > 0 && total > p.cfg.MaxModelSize {
+			cancel(fmt.Errorf("%w: needs at least %d bytes, the limit is %d", errModelTooLarge, total, p.cfg.MaxModelSize))
+		}
+		if p.cfg.DiskQuota > 0 && usage+total > p.cfg.DiskQuota {
+			cancel(fmt.Errorf("%w: needs at least %d bytes, %d of %d bytes are in use",
+				errQuotaExceeded, total, usage, p.cfg.DiskQuota))
+		}
+	}
+}
+
+// expire forgets demand and failures older than the window. The caller must hold p.mu.
+func (p *Puller) expire(now time.Time) {
+	cutoff := now.Add(-p.cfg.Window)
+	for model, peers := range p.demand {
+		for peerID, requested := range peers {
+			if !requested.After(cutoff) {
+				delete(peers, peerID)
+			}
+		}
+		if len(peers) == 0 {
+			delete(p.demand, model)
+		}
+	}
+	for model, failedAt := range p.failedAt {
+		if failedAt.Before(cutoff) {
+			delete(p.failedAt, model)
+			delete(p.pulls, model)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package modelpull
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+)
+
+// fakeBackend pulls models by replaying a list of progress updates
+type fakeBackend struct {
+	mu      sync.Mutex
+	usage   int64
+	updates []crowdllama.PullProgress
+	pulled  []string
+	release chan struct{} // closed to let pulls finish
+}
+
+func (b *fakeBackend) PullModel(ctx context.Context, model string, progress func(crowdllama.PullProgress)) error {
+	for _, update := range b.updates {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		progress(update)
+	}
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pulled = append(b.pulled, model)
+	return nil
+}
+
+func (b *fakeBackend) ModelDiskUsage(context.Context) (int64, error) {
+	return b.usage, nil
+}
+
+func (b *fakeBackend) pulledModels() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.pulled...)
+}
+
+// waitFor polls cond until it holds or the test times out
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not met within 5s")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPullAfterThreshold(t *testing.T) {
+	backend := &fakeBackend{
+		updates: []crowdllama.PullProgress{
+			{Status: "pulling manifest"},
+			{Status: "pulling a", Digest: "sha256:a", Total: 100, Completed: 50},
+			{Status: "pulling b", Digest: "sha256:b", Total: 20, Completed: 20},
+		},
+		release: make(chan struct{}),
+	}
+	puller := New(context.Background(), backend, Config{Threshold: 2}, zap.NewNop())
+
+	puller.RecordDemand("llama3.2", "peer-a")
+	puller.RecordDemand("llama3.2", "peer-a")
+	if len(puller.Progress()) != 0 {
+		t.Fatal("Expected no pull while a single peer asks for the model")
+	}
+
+	puller.RecordDemand("llama3.2", "peer-b")
+	waitFor(t, func() bool {
+		progress := puller.Progress()
+		return len(progress) == 1 && progress[0].Total == 120
+	})
+	progress := puller.Progress()[0]
+	if progress.Model != "llama3.2" || progress.Status != StatusPulling || progress.Completed != 70 {
+		t.Errorf("Unexpected progress: %+v", progress)
+	}
+
+	close(backend.release)
+	waitFor(t, func() bool { return len(puller.Progress()) == 0 })
+	if pulled := backend.pulledModels(); len(pulled) != 1 || pulled[0] != "llama3.2" {
+		t.Errorf("Expected llama3.2 to be pulled once, got %v", pulled)
+	}
+}
+
+func TestPullDiskQuota(t *testing.T) {
+	backend := &fakeBackend{
+		usage:   900,
+		updates: []crowdllama.PullProgress{{Status: "pulling a", Digest: "sha256:a", Total: 200}},
+		release: make(chan struct{}),
+	}
+	puller := New(context.Background(), backend, Config{Threshold: 1, DiskQuota: 1000}, zap.NewNop())
+
+	puller.RecordDemand("llama3.1:70b", "peer-a")
+	waitFor(t, func() bool {
+		progress := puller.Progress()
+		return len(progress) == 1 && progress[0].Status == StatusFailed
+	})
+	progress := puller.Progress()[0]
+	if !strings.Contains(progress.Error, "disk quota") {
+		t.Errorf("Expected a quota error, got %q", progress.Error)
+	}
+	if len(backend.pulledModels()) != 0 {
+		t.Error("Expected the pull to be cancelled")
+	}
+
+	// A failed pull is not retried within the window
+	puller.RecordDemand("llama3.1:70b", "peer-a")
+	if progress := puller.Progress(); len(progress) != 1 || progress[0].Status != StatusFailed {
+		t.Errorf("Expected the failure to be kept, got %+v", progress)
+	}
+}
+
+func TestPullQuotaAlreadyFull(t *testing.T) {
+	backend := &fakeBackend{usage: 1000, release: make(chan struct{})}
+	puller := New(context.Background(), backend, Config{Threshold: 1, DiskQuota: 1000}, zap.NewNop())
+
+	puller.RecordDemand("mistral", "peer-a")
+	waitFor(t, func() bool {
+		progress := puller.Progress()
+		return len(progress) == 1 && progress[0].Status == StatusFailed
+	})
+	if err := puller.Progress()[0].Error; !strings.Contains(err, errQuotaExceeded.Error()) {
+		t.Errorf("Expected a quota error, got %q", err)
+	}
+}
+
+func TestPullPolicy(t *testing.T) {
+	backend := &fakeBackend{
+		updates: []crowdllama.PullProgress{{Status: "pulling a", Digest: "sha256:a", Total: 600}},
+		release: make(chan struct{}),
+	}
+	allow := func(model string) bool { return model != "mistral" }
+	puller := New(context.Background(), backend, Config{Threshold: 1, MaxModelSize: 500, Allow: allow}, zap.NewNop())
+
+	puller.RecordDemand("mistral", "peer-a")
+	if len(puller.Progress()) != 0 {
+		t.Error("Expected a model the policy refuses not to be pulled")
+	}
+
+	puller.RecordDemand("llama3.1:70b", "peer-a")
+	waitFor(t, func() bool {
+		progress := puller.Progress()
+		return len(progress) == 1 && progress[0].Status == StatusFailed
+	})
+	if err := puller.Progress()[0].Error; !strings.Contains(err, errModelTooLarge.Error()) {
+		t.Errorf("Expected a size limit error, got %q", err)
+	}
+}
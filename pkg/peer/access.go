@@ -0,0 +1,168 @@
+package peer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+
+	"github.com/crowdllama/crowdllama/pkg/config"
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+)
+
+// accessTokenReadTimeout bounds how long a worker waits for an access token
+const accessTokenReadTimeout = 5 * time.Second
+
+// accessControl restricts inference to allowed consumer peers and to peers holding a token of a trusted issuer
+type accessControl struct {
+	allowed map[peer.ID]bool
+	issuers map[peer.ID]bool
+	now     func() time.Time
+
+	mu     sync.Mutex
+	grants map[peer.ID]time.Time // expiry of the access tokens consumers presented
+}
+
+// newAccessControl parses the consumers a worker serves, or returns nil if the worker serves every peer
+func newAccessControl(cfg *config.Configuration, workerMode bool) (*accessControl, error) {
+	if !workerMode || (len(cfg.AllowPeers) == 0 && len(cfg.TrustedIssuers) == 0) {
+		return nil, nil
+	}
+
+	a := &accessControl{
+		allowed: make(map[peer.ID]bool, len(cfg.AllowPeers)),
+		issuers: make(map[peer.ID]bool, len(cfg.TrustedIssuers)),
+		now:     time.Now,
+		grants:  make(map[peer.ID]time.Time),
+	}
+	for _, s := range cfg.AllowPeers {
+		id, err := peer.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed peer %q: %w", s, err)
+		}
+		a.allowed[id] = true
+	}
+	for _, s := range cfg.TrustedIssuers {
+		id, err := peer.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted issuer %q: %w", s, err)
+		}
+		if _, err := id.ExtractPublicKey(); err != nil {
+			return nil, fmt.Errorf("trusted issuer %s has no embedded public key to verify tokens with: %w", s, err)
+		}
+		a.issuers[id] = true
+	}
+	return a, nil
+}
+
+// allows returns true if id is an allowed peer or presented a valid access token
+func (a *accessControl) allows(id peer.ID) bool {
+	if a.allowed[id] {
+		return true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	expires, ok := a.grants[id]
+	if ok && !a.now().Before(expires) {
+		delete(a.grants, id)
+		return false
+	}
+	return ok
+}
+
+// grant admits the peer a token was issued to if a trusted issuer signed it and it has not expired
+func (a *accessControl) grant(from peer.ID, token string) (time.Time, error) {
+	parsed, err := crowdllama.ParseAccessToken(token)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %w", crowdllama.ErrAccessDenied, err)
+	}
+	issuer, err := peer.Decode(parsed.Issuer)
+	switch {
+	case err != nil || !a.issuers[issuer]:
+		return time.Time{}, fmt.Errorf("%w: access token issuer %s is not trusted", crowdllama.ErrAccessDenied, parsed.Issuer)
+	case parsed.Subject != from.String():
+		return time.Time{}, fmt.Errorf("%w: access token was issued to %s", crowdllama.ErrAccessDenied, parsed.Subject)
+	case !a.now().Before(parsed.Expires):
+		return time.Time{}, fmt.Errorf("%w: access token expired at %s", crowdllama.ErrAccessDenied, parsed.Expires.Format(time.RFC3339))
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.grants[from] = parsed.Expires
+	return parsed.Expires, nil
+}
+
+// setupAccessControl accepts access tokens if the worker trusts token issuers
+func (p *Peer) setupAccessControl() {
+	if p.access == nil {
+		return
+	}
+	if len(p.access.issuers) > 0 {
+		p.Host.SetStreamHandler(crowdllama.AccessProtocol, p.handleAccessToken)
+	}
+	p.logger.Info("Serving only allowed consumers",
+		zap.Int("allowed_peers", len(p.access.allowed)),
+		zap.Int("trusted_issuers", len(p.access.issuers)))
+}
+
+// admitsConsumer returns true if the worker serves inference requests from remote
+func (p *Peer) admitsConsumer(remote peer.ID) bool {
+	if p.access == nil || p.access.allows(remote) {
+		return true
+	}
+	p.logger.Warn("Refused inference request from peer without access", zap.String("remote_peer", remote.String()))
+	return false
+}
+
+// handleAccessToken checks the access token a consumer presents and answers with an AccessGrant
+func (p *Peer) handleAccessToken(s network.Stream) {
+	if !p.acceptStream(s) {
+		return
+	}
+	defer func() {
+		if err := s.Close(); err != nil {
+			p.logger.Debug("Failed to close stream", zap.Error(err))
+		}
+	}()
+
+	if err := s.SetReadDeadline(time.Now().Add(accessTokenReadTimeout)); err != nil {
+		p.logger.Debug("Failed to set read deadline", zap.Error(err))
+	}
+	token, err := io.ReadAll(io.LimitReader(s, crowdllama.MaxAccessTokenSize))
+	if err != nil {
+		p.logger.Debug("Failed to read access token", zap.Error(err))
+		return
+	}
+
+	remote := s.Conn().RemotePeer()
+	var grant crowdllama.AccessGrant
+	if grant.Expires, err = p.access.grant(remote, string(token)); err != nil {
+		grant.Error = err.Error()
+		p.logger.Warn("Refused access token", zap.String("remote_peer", remote.String()), zap.Error(err))
+	} else {
+		p.logger.Info("Granted access to consumer",
+			zap.String("remote_peer", remote.String()),
+			zap.Time("expires", grant.Expires))
+	}
+
+	data, err := json.Marshal(grant)
+	if err != nil {
+		p.logger.Debug("Failed to encode access grant", zap.Error(err))
+		return
+	}
+	if _, err := s.Write(data); err != nil {
+		p.logger.Debug("Failed to write access grant", zap.Error(err))
+	}
+}
+
+// applyAccessMetadata advertises that the worker accepts access tokens
+func (p *Peer) applyAccessMetadata() {
+	if p.access != nil && len(p.access.issuers) > 0 {
+		p.Metadata.Protocols = append(p.Metadata.Protocols, crowdllama.AccessProtocol)
+	}
+}
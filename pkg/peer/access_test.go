@@ -0,0 +1,120 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+
+	"github.com/crowdllama/crowdllama/pkg/config"
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+	"github.com/crowdllama/crowdllama/pkg/testhelpers/testkeys"
+)
+
+func TestAccessControl(t *testing.T) {
+	issuerKey, issuer := testkeys.NewKey(t)
+	untrustedKey, _ := testkeys.NewKey(t)
+	_, gateway := testkeys.NewKey(t)
+	_, allowed := testkeys.NewKey(t)
+	_, stranger := testkeys.NewKey(t)
+
+	cfg := config.NewConfiguration()
+	if access, err := newAccessControl(cfg, true); err != nil || access != nil {
+		t.Fatalf("Expected a worker without restrictions to serve every peer, got %v, %v", access, err)
+	}
+	cfg.AllowPeers = []string{allowed.String()}
+	cfg.TrustedIssuers = []string{issuer.String()}
+	access, err := newAccessControl(cfg, true)
+	if err != nil {
+		t.Fatalf("Failed to set up access control: %v", err)
+	}
+	now := time.Now()
+	access.now = func() time.Time { return now }
+
+	if !access.allows(allowed) || access.allows(gateway) || access.allows(stranger) {
+		t.Fatal("Expected only the allowed peer to have access before any token is presented")
+	}
+
+	issue := func(key crypto.PrivKey, subject peer.ID, expires time.Time) string {
+		token, err := crowdllama.IssueAccessToken(key, subject, expires)
+		if err != nil {
+			t.Fatalf("Failed to issue token: %v", err)
+		}
+		return token
+	}
+	for name, token := range map[string]string{
+		"untrusted issuer": issue(untrustedKey, gateway, now.Add(time.Hour)),
+		"other subject":    issue(issuerKey, stranger, now.Add(time.Hour)),
+		"expired":          issue(issuerKey, gateway, now.Add(-time.Minute)),
+	} {
+		if _, err := access.grant(gateway, token); !errors.Is(err, crowdllama.ErrAccessDenied) {
+			t.Errorf("%s: expected access to be denied, got %v", name, err)
+		}
+	}
+	if access.allows(gateway) {
+		t.Fatal("Expected refused tokens not to grant access")
+	}
+
+	if _, err := access.grant(gateway, issue(issuerKey, gateway, now.Add(time.Hour))); err != nil {
+		t.Fatalf("Expected a valid token to be accepted, got %v", err)
+	}
+	if !access.allows(gateway) || access.allows(stranger) {
+		t.Error("Expected the token to grant access to its subject only")
+	}
+	now = now.Add(time.Hour)
+	if access.allows(gateway) {
+		t.Error("Expected access to end when the token expires")
+	}
+}
+
+func TestSessionRefusesPeerWithoutAccess(t *testing.T) {
+	hosts := make([]host.Host, 2)
+	for i := range hosts {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		if err != nil {
+			t.Fatalf("Failed to create host: %v", err)
+		}
+		t.Cleanup(func() { _ = h.Close() })
+		hosts[i] = h
+	}
+	workerHost, gatewayHost := hosts[0], hosts[1]
+
+	_, allowed := testkeys.NewKey(t)
+	cfg := config.NewConfiguration()
+	cfg.AllowPeers = []string{allowed.String()}
+	access, err := newAccessControl(cfg, true)
+	if err != nil {
+		t.Fatalf("Failed to set up access control: %v", err)
+	}
+	p := &Peer{Host: workerHost, WorkerMode: true, access: access, logger: zap.NewNop()}
+	workerHost.SetStreamHandler(crowdllama.InferenceSessionProtocol, func(s network.Stream) {
+		p.handleInferenceSession(context.Background(), s)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	gatewayHost.Peerstore().AddAddrs(workerHost.ID(), workerHost.Addrs(), time.Hour)
+	s, err := gatewayHost.NewStream(ctx, workerHost.ID(), crowdllama.InferenceSessionProtocol)
+	if err != nil {
+		t.Fatalf("Failed to open session: %v", err)
+	}
+	defer func() { _ = s.Reset() }()
+
+	// The worker must refuse the session without waiting for a frame
+	if err := s.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("Failed to set read deadline: %v", err)
+	}
+	_, err = s.Read(make([]byte, 1))
+	var netErr net.Error
+	if err == nil || errors.As(err, &netErr) && netErr.Timeout() {
+		t.Errorf("Expected the session of a peer without access to be reset, got %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package peer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+	"github.com/crowdllama/crowdllama/pkg/schedule"
+)
+
+// scheduleCheckInterval is how often a worker with a schedule checks whether it should serve requests
+const scheduleCheckInterval = 30 * time.Second
+
+// DefaultDrainTimeout is how long a worker shutting down waits for its in-flight requests by default
+const DefaultDrainTimeout = time.Minute
+
+// Availability reports whether a worker accepts new requests and how many it is still serving
+type Availability struct {
+	Paused   bool    `json:"paused"`
+	Reason   string  `json:"reason,omitempty"` // see crowdllama.PauseReasonOperator
+	InFlight int     `json:"in_flight"`
+	LocalUse float64 `json:"local_use,omitempty"` // share of the CPU or GPU other processes use, if watched
+}
+
+// setupSchedule parses the worker's availability schedule and starts following it
+func (p *Peer) setupSchedule(ctx context.Context) error {
+	if !p.WorkerMode || p.Config == nil || p.Config.Schedule == "" {
+		return nil
+	}
+
+	s, err := schedule.Parse(p.Config.Schedule)
+	if err != nil {
+		return fmt.Errorf("parse worker schedule: %w", err)
+	}
+	p.availMu.Lock()
+	p.schedule = s
+	p.availMu.Unlock()
+
+	p.logger.Info("Serving requests on a schedule",
+		zap.String("schedule", p.Config.Schedule),
+		zap.Bool("active", s.Active(time.Now())))
+	go p.followSchedule(ctx, s)
+	return nil
+}
+
+// followSchedule logs when the schedule opens or closes. Requests are admitted by checking the schedule
+// directly, so this loop only reports the changes.
+func (p *Peer) followSchedule(ctx context.Context, s *schedule.Schedule) {
+	ticker := time.NewTicker(scheduleCheckInterval)
+	defer ticker.Stop()
+
+	active := s.Active(time.Now())
+	for {
+		select {
+		case <-ticker.C:
+			if now := s.Active(time.Now()); now != active {
+				active = now
+				if active {
+					p.logger.Info("Schedule opened, accepting requests")
+				} else {
+					p.logger.Info("Schedule closed, finishing in-flight requests", zap.Int("in_flight", p.Availability().InFlight))
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Pause stops accepting new requests and advertising the worker, then waits until the requests in flight
+// have finished or ctx is done
+func (p *Peer) Pause(ctx context.Context) error {
+	p.availMu.Lock()
+	wasPaused := p.paused
+	p.paused = true
+	p.availMu.Unlock()
+
+	if !wasPaused {
+		p.logger.Info("Worker paused, finishing in-flight requests", zap.Int("in_flight", p.Availability().InFlight))
+	}
+	if err := p.waitIdle(ctx); err != nil {
+		return err
+	}
+	p.logger.Info("Worker paused and idle")
+	return nil
+}
+
+// Drain stops accepting new requests for good, as before shutting down, then waits until the requests in
+// flight have finished or ctx is done. Unlike Pause it cannot be undone by Resume.
+func (p *Peer) Drain(ctx context.Context) error {
+	p.availMu.Lock()
+	p.draining = true
+	p.availMu.Unlock()
+
+	p.logger.Info("Worker draining, finishing in-flight requests", zap.Int("in_flight", p.Availability().InFlight))
+	if err := p.waitIdle(ctx); err != nil {
+		return err
+	}
+	p.logger.Info("Worker drained")
+	return nil
+}
+
+// Resume accepts requests again after Pause. A worker outside its schedule stays paused until the schedule
+// opens.
+func (p *Peer) Resume() {
+	p.availMu.Lock()
+	wasPaused := p.paused
+	p.paused = false
+	p.availMu.Unlock()
+
+	if wasPaused {
+		p.logger.Info("Worker resumed")
+	}
+}
+
+// Availability returns whether the worker accepts new requests
+func (p *Peer) Availability() Availability {
+	p.availMu.Lock()
+	defer p.availMu.Unlock()
+	reason := p.pauseReasonLocked(time.Now())
+	return Availability{Paused: reason != "", Reason: reason, InFlight: p.inflight, LocalUse: p.localUse}
+}
+
+// pauseReasonLocked returns why the worker does not accept requests at now, or "" if it does. The caller must
+// hold p.availMu.
+func (p *Peer) pauseReasonLocked(now time.Time) string {
+	switch {
+	case p.draining:
+		return crowdllama.PauseReasonShutdown
+	case p.paused:
+		return crowdllama.PauseReasonOperator
+	case p.localBusy:
+		return crowdllama.PauseReasonLocalUse
+	case p.schedule != nil && !p.schedule.Active(now):
+		return crowdllama.PauseReasonSchedule
+	default:
+		return ""
+	}
+}
+
+// beginRequest admits a request unless the worker is paused; admitted requests must call endRequest
+func (p *Peer) beginRequest() error {
+	p.availMu.Lock()
+	defer p.availMu.Unlock()
+	if reason := p.pauseReasonLocked(time.Now()); reason != "" {
+		return fmt.Errorf("%w: paused by %s", crowdllama.ErrWorkerUnavailable, reason)
+	}
+	p.inflight++
+	return nil
+}
+
+// endRequest marks an admitted request as finished
+func (p *Peer) endRequest() {
+	p.availMu.Lock()
+	defer p.availMu.Unlock()
+	p.inflight--
+	if p.inflight == 0 && p.idle != nil {
+		close(p.idle)
+		p.idle = nil
+	}
+}
+
+// waitIdle waits until no request is in flight or ctx is done
+func (p *Peer) waitIdle(ctx context.Context) error {
+	p.availMu.Lock()
+	if p.inflight == 0 {
+		p.availMu.Unlock()
+		return nil
+	}
+	if p.idle == nil {
+		p.idle = make(chan struct{})
+	}
+	idle := p.idle
+	p.availMu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("wait for in-flight requests: %w", context.Cause(ctx))
+	}
+}
+
+// applyAvailability records in metadata whether the worker is paused
+func (p *Peer) applyAvailability(metadata *crowdllama.Resource) {
+	if !p.WorkerMode {
+		return
+	}
+	availability := p.Availability()
+	metadata.Paused = availability.Paused
+	metadata.PauseReason = availability.Reason
+}
@@ -0,0 +1,112 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+	"github.com/crowdllama/crowdllama/pkg/hwprobe"
+	"github.com/crowdllama/crowdllama/pkg/schedule"
+)
+
+func TestPauseFinishesInFlightRequests(t *testing.T) {
+	p := &Peer{WorkerMode: true, logger: zap.NewNop()}
+	if err := p.beginRequest(); err != nil {
+		t.Fatalf("Expected a running worker to accept requests, got %v", err)
+	}
+
+	paused := make(chan error, 1)
+	go func() { paused <- p.Pause(context.Background()) }()
+
+	// New requests are refused as soon as the pause starts, while the admitted one keeps running
+	deadline := time.Now().Add(5 * time.Second)
+	for !errors.Is(p.beginRequest(), crowdllama.ErrWorkerUnavailable) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected new requests to be refused while pausing")
+		}
+		p.endRequest()
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-paused:
+		t.Fatalf("Expected Pause to wait for the in-flight request, returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	p.endRequest()
+	if err := <-paused; err != nil {
+		t.Fatalf("Pause failed: %v", err)
+	}
+	if availability := p.Availability(); !availability.Paused || availability.Reason != crowdllama.PauseReasonOperator {
+		t.Errorf("Expected the worker to be paused by the operator, got %+v", availability)
+	}
+
+	p.Resume()
+	if err := p.beginRequest(); err != nil {
+		t.Errorf("Expected a resumed worker to accept requests, got %v", err)
+	}
+}
+
+func TestScheduleClosedRefusesRequests(t *testing.T) {
+	// A window on no day of the week is never open
+	closed := &schedule.Schedule{Windows: []schedule.Window{{End: 24 * time.Hour}}}
+	p := &Peer{WorkerMode: true, logger: zap.NewNop(), schedule: closed}
+
+	if err := p.beginRequest(); !errors.Is(err, crowdllama.ErrWorkerUnavailable) {
+		t.Errorf("Expected requests outside the schedule to be refused, got %v", err)
+	}
+	metadata := crowdllama.NewCrowdLlamaResource("worker")
+	p.applyAvailability(metadata)
+	if !metadata.Paused || metadata.PauseReason != crowdllama.PauseReasonSchedule {
+		t.Errorf("Expected the metadata to report a scheduled pause, got paused=%v reason=%q", metadata.Paused, metadata.PauseReason)
+	}
+}
+
+func TestDrainCannotBeResumed(t *testing.T) {
+	p := &Peer{WorkerMode: true, logger: zap.NewNop()}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := p.beginRequest(); err != nil {
+		t.Fatalf("Expected a running worker to accept requests, got %v", err)
+	}
+
+	// A drain that times out leaves the request running
+	cancel()
+	if err := p.Drain(ctx); err == nil {
+		t.Error("Expected the drain to stop waiting once its context is done")
+	}
+	if availability := p.Availability(); availability.Reason != crowdllama.PauseReasonShutdown || availability.InFlight != 1 {
+		t.Errorf("Expected a draining worker with one request in flight, got %+v", availability)
+	}
+
+	p.Resume()
+	if err := p.beginRequest(); !errors.Is(err, crowdllama.ErrWorkerUnavailable) {
+		t.Errorf("Expected a draining worker to keep refusing requests, got %v", err)
+	}
+}
+
+func TestLocalUsePausesWorker(t *testing.T) {
+	p := &Peer{WorkerMode: true, logger: zap.NewNop()}
+
+	p.recordLocalUse(hwprobe.Usage{CPU: 0.2, GPU: 0.9, HasGPU: true}, DefaultLocalUseThreshold)
+	if err := p.beginRequest(); !errors.Is(err, crowdllama.ErrWorkerUnavailable) {
+		t.Fatalf("Expected requests to be refused while the machine is in local use, got %v", err)
+	}
+	if availability := p.Availability(); availability.Reason != crowdllama.PauseReasonLocalUse || availability.LocalUse != 0.9 {
+		t.Errorf("Expected the worker to be paused for local use, got %+v", availability)
+	}
+
+	// The worker resumes only after several quiet samples in a row
+	for i := range localUseResumeSamples {
+		if !p.Availability().Paused {
+			t.Fatalf("Expected the worker to stay paused after %d quiet samples", i)
+		}
+		p.recordLocalUse(hwprobe.Usage{CPU: 0.1}, DefaultLocalUseThreshold)
+	}
+	if err := p.beginRequest(); err != nil {
+		t.Errorf("Expected the worker to accept requests once local use stopped, got %v", err)
+	}
+}
@@ -0,0 +1,265 @@
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+
+	"github.com/crowdllama/crowdllama/pkg/config"
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+)
+
+const (
+	// DefaultPeerRequestsPerMinute is how many inference requests a worker accepts from one peer per minute
+	DefaultPeerRequestsPerMinute = 120
+
+	// DefaultPeerMaxConcurrent is how many inference requests a worker serves for one peer at once
+	DefaultPeerMaxConcurrent = 8
+
+	// DefaultPeerBanDuration is how long a worker refuses a peer that kept sending malformed messages
+	DefaultPeerBanDuration = 15 * time.Minute
+
+	// metadataRequestsPerMinute is how many metadata requests a worker answers for one peer per minute.
+	// Gateways ask about every 30 seconds, plus health checks.
+	metadataRequestsPerMinute = 20
+
+	// maxSessionsPerPeer and maxMetadataStreamsPerPeer cap the streams a peer may keep open per protocol.
+	// A gateway uses a single session, and metadata streams are short-lived.
+	maxSessionsPerPeer        = 4
+	maxMetadataStreamsPerPeer = 4
+
+	// malformedStrikes is how many malformed messages within strikeWindow get a peer banned
+	malformedStrikes = 3
+	strikeWindow     = 10 * time.Minute
+
+	// guardSweepInterval is how often the guard forgets peers that have been idle for guardIdleExpiry
+	guardSweepInterval = 10 * time.Minute
+	guardIdleExpiry    = time.Hour
+)
+
+// peerGuardState tracks the requests and misbehaviour of one remote peer
+type peerGuardState struct {
+	requests    *rate.Limiter // nil without a request rate limit
+	metadata    *rate.Limiter
+	active      int
+	strikes     int
+	firstStrike time.Time
+	bannedUntil time.Time
+	lastSeen    time.Time
+}
+
+// streamGuard protects a worker from peers that flood it with streams or send malformed messages
+type streamGuard struct {
+	requestsPerMinute int // 0 means no limit
+	maxConcurrent     int // 0 means no limit
+	banDuration       time.Duration
+	now               func() time.Time
+
+	mu        sync.Mutex
+	peers     map[peer.ID]*peerGuardState
+	lastSweep time.Time
+}
+
+// newStreamGuard creates the guard of a worker from its configuration, or returns nil for other peers
+func newStreamGuard(cfg *config.Configuration, workerMode bool) *streamGuard {
+	if !workerMode {
+		return nil
+	}
+	return &streamGuard{
+		requestsPerMinute: limitOrDefault(cfg.PeerRequestsPerMinute, DefaultPeerRequestsPerMinute),
+		maxConcurrent:     limitOrDefault(cfg.PeerMaxConcurrent, DefaultPeerMaxConcurrent),
+		banDuration:       durationOrDefault(cfg.PeerBanDuration, DefaultPeerBanDuration),
+		now:               time.Now,
+		peers:             make(map[peer.ID]*peerGuardState),
+	}
+}
+
+// limitOrDefault returns the configured limit, def for 0 and 0 (no limit) for negative values
+func limitOrDefault(configured, def int) int {
+	switch {
+	case configured < 0:
+		return 0
+	case configured == 0:
+		return def
+	default:
+		return configured
+	}
+}
+
+// durationOrDefault returns the configured duration, or def if none is configured
+func durationOrDefault(configured, def time.Duration) time.Duration {
+	if configured <= 0 {
+		return def
+	}
+	return configured
+}
+
+// stateLocked returns the state of id, creating it on first use. The caller must hold g.mu.
+func (g *streamGuard) stateLocked(id peer.ID, now time.Time) *peerGuardState {
+	if now.Sub(g.lastSweep) >= guardSweepInterval {
+		g.lastSweep = now
+		for other, state := range g.peers {
+			if state.active == 0 && now.After(state.bannedUntil) && now.Sub(state.lastSeen) > guardIdleExpiry {
+				delete(g.peers, other)
+			}
+		}
+	}
+
+	state, ok := g.peers[id]
+	if !ok {
+		state = &peerGuardState{metadata: rate.NewLimiter(rate.Limit(metadataRequestsPerMinute/60.0), metadataRequestsPerMinute)}
+		if g.requestsPerMinute > 0 {
+			// A minute's worth of requests may arrive at once, refilled evenly over the minute
+			state.requests = rate.NewLimiter(rate.Limit(float64(g.requestsPerMinute)/60), g.requestsPerMinute)
+		}
+		g.peers[id] = state
+	}
+	state.lastSeen = now
+	return state
+}
+
+// banned returns true if id is banned
+func (g *streamGuard) banned(id peer.ID) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	return now.Before(g.stateLocked(id, now).bannedUntil)
+}
+
+// allowMetadata returns true if id may request metadata now
+func (g *streamGuard) allowMetadata(id peer.ID) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	state := g.stateLocked(id, now)
+	return now.After(state.bannedUntil) && state.metadata.AllowN(now, 1)
+}
+
+// admit counts an inference request of id against its rate and concurrency limits. Admitted requests must
+// call release.
+func (g *streamGuard) admit(id peer.ID) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	state := g.stateLocked(id, now)
+
+	switch {
+	case now.Before(state.bannedUntil):
+		return fmt.Errorf("%w: banned until %s", crowdllama.ErrAccessDenied, state.bannedUntil.Format(time.RFC3339))
+	case g.maxConcurrent > 0 && state.active >= g.maxConcurrent:
+		return fmt.Errorf("%w: at most %d requests at once", crowdllama.ErrRateLimited, g.maxConcurrent)
+	case state.requests != nil && !state.requests.AllowN(now, 1):
+		return fmt.Errorf("%w: at most %d requests per minute", crowdllama.ErrRateLimited, g.requestsPerMinute)
+	}
+	state.active++
+	return nil
+}
+
+// release marks an admitted request of id as finished
+func (g *streamGuard) release(id peer.ID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if state, ok := g.peers[id]; ok {
+		state.active--
+	}
+}
+
+// strike records a malformed message from id and returns true if it got the peer banned
+func (g *streamGuard) strike(id peer.ID) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	state := g.stateLocked(id, now)
+
+	if now.Sub(state.firstStrike) > strikeWindow {
+		state.strikes, state.firstStrike = 0, now
+	}
+	state.strikes++
+	if state.strikes < malformedStrikes {
+		return false
+	}
+	state.strikes = 0
+	state.bannedUntil = now.Add(g.banDuration)
+	return true
+}
+
+// workerHostOptions returns the libp2p options of a worker's host
+func workerHostOptions(cfg *config.Configuration, workerMode bool) ([]libp2p.Option, error) {
+	if !workerMode {
+		return nil, nil
+	}
+	resourceManager, err := peerResourceLimits(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return []libp2p.Option{resourceManager}, nil
+}
+
+// peerResourceLimits caps the streams a single peer may open per CrowdLlama protocol, on top of libp2p's
+// default limits
+func peerResourceLimits(cfg *config.Configuration) (libp2p.Option, error) {
+	maxConcurrent := limitOrDefault(cfg.PeerMaxConcurrent, DefaultPeerMaxConcurrent)
+	inference := rcmgr.ResourceLimits{StreamsInbound: rcmgr.Unlimited}
+	if maxConcurrent > 0 {
+		inference.StreamsInbound = rcmgr.LimitVal(maxConcurrent)
+	}
+	protocolPeer := map[protocol.ID]rcmgr.ResourceLimits{
+		crowdllama.MetadataProtocol: {StreamsInbound: maxMetadataStreamsPerPeer},
+	}
+	for _, id := range crowdllama.InferenceProtocols() {
+		protocolPeer[id] = inference
+	}
+	for _, id := range crowdllama.InferenceSessionProtocols() {
+		protocolPeer[id] = rcmgr.ResourceLimits{StreamsInbound: maxSessionsPerPeer}
+	}
+
+	scaling := rcmgr.DefaultLimits
+	libp2p.SetDefaultServiceLimits(&scaling)
+	limits := rcmgr.PartialLimitConfig{ProtocolPeer: protocolPeer}.Build(scaling.AutoScale())
+	manager, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(limits))
+	if err != nil {
+		return nil, fmt.Errorf("create resource manager: %w", err)
+	}
+	return libp2p.ResourceManager(manager), nil
+}
+
+// acceptStream resets streams of banned peers and returns false for them
+func (p *Peer) acceptStream(s network.Stream) bool {
+	if p.guard == nil || !p.guard.banned(s.Conn().RemotePeer()) {
+		return true
+	}
+	p.logger.Debug("Refused stream of banned peer",
+		zap.String("remote_peer", s.Conn().RemotePeer().String()),
+		zap.String("protocol", string(s.Protocol())))
+	if err := s.Reset(); err != nil {
+		p.logger.Debug("Failed to reset stream", zap.Error(err))
+	}
+	return false
+}
+
+// reportReadError bans a peer that keeps sending messages the worker cannot decode and disconnects it
+func (p *Peer) reportReadError(remote peer.ID, err error) {
+	if p.guard == nil || (!errors.Is(err, crowdllama.ErrMalformedMessage) && !errors.Is(err, crowdllama.ErrMessageTooLarge)) {
+		return
+	}
+	if !p.guard.strike(remote) {
+		p.logger.Debug("Received malformed message", zap.String("remote_peer", remote.String()), zap.Error(err))
+		return
+	}
+	p.logger.Warn("Banning peer that sent malformed messages",
+		zap.String("remote_peer", remote.String()),
+		zap.Duration("duration", p.guard.banDuration),
+		zap.Error(err))
+	if err := p.Host.Network().ClosePeer(remote); err != nil {
+		p.logger.Debug("Failed to disconnect banned peer", zap.Error(err))
+	}
+}
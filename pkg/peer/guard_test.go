@@ -0,0 +1,103 @@
+package peer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/crowdllama/crowdllama/pkg/config"
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+	"github.com/crowdllama/crowdllama/pkg/testhelpers/testkeys"
+)
+
+func TestStreamGuard(t *testing.T) {
+	_, consumer := testkeys.NewKey(t)
+	_, other := testkeys.NewKey(t)
+
+	if guard := newStreamGuard(config.NewConfiguration(), false); guard != nil {
+		t.Fatal("Expected no guard for a consumer peer")
+	}
+	cfg := config.NewConfiguration()
+	cfg.PeerRequestsPerMinute = 2
+	cfg.PeerMaxConcurrent = 1
+	cfg.PeerBanDuration = time.Minute
+	guard := newStreamGuard(cfg, true)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	guard.now = func() time.Time { return now }
+
+	if err := guard.admit(consumer); err != nil {
+		t.Fatalf("Expected the first request to be admitted, got %v", err)
+	}
+	if err := guard.admit(consumer); !errors.Is(err, crowdllama.ErrRateLimited) {
+		t.Errorf("Expected a second concurrent request to be rate limited, got %v", err)
+	}
+	guard.release(consumer)
+	if err := guard.admit(consumer); err != nil {
+		t.Fatalf("Expected a request within the burst to be admitted, got %v", err)
+	}
+	guard.release(consumer)
+	if err := guard.admit(consumer); !errors.Is(err, crowdllama.ErrRateLimited) {
+		t.Errorf("Expected a request over the rate limit to be refused, got %v", err)
+	}
+	if err := guard.admit(other); err != nil {
+		t.Errorf("Expected another peer not to be affected, got %v", err)
+	}
+	guard.release(other)
+	now = now.Add(30 * time.Second)
+	if err := guard.admit(consumer); err != nil {
+		t.Fatalf("Expected a request after the refill to be admitted, got %v", err)
+	}
+	guard.release(consumer)
+
+	for i := 1; i < malformedStrikes; i++ {
+		if guard.strike(consumer) {
+			t.Fatalf("Expected strike %d not to ban the peer", i)
+		}
+	}
+	if !guard.strike(consumer) || !guard.banned(consumer) {
+		t.Fatal("Expected repeated malformed messages to ban the peer")
+	}
+	if err := guard.admit(consumer); !errors.Is(err, crowdllama.ErrAccessDenied) {
+		t.Errorf("Expected requests of a banned peer to be denied, got %v", err)
+	}
+	if guard.allowMetadata(consumer) {
+		t.Error("Expected metadata requests of a banned peer to be refused")
+	}
+
+	now = now.Add(time.Minute + time.Second)
+	if guard.banned(consumer) {
+		t.Error("Expected the ban to expire")
+	}
+	if err := guard.admit(consumer); err != nil {
+		t.Errorf("Expected requests after the ban to be admitted, got %v", err)
+	}
+}
+
+func TestStreamGuardStrikeWindow(t *testing.T) {
+	_, consumer := testkeys.NewKey(t)
+	guard := newStreamGuard(config.NewConfiguration(), true)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	guard.now = func() time.Time { return now }
+
+	for range malformedStrikes - 1 {
+		guard.strike(consumer)
+	}
+	now = now.Add(strikeWindow + time.Second)
+	if guard.strike(consumer) {
+		t.Error("Expected strikes outside the window not to add up to a ban")
+	}
+	if guard.maxConcurrent != DefaultPeerMaxConcurrent || guard.banDuration != DefaultPeerBanDuration {
+		t.Errorf("Expected the default limits, got %d and %s", guard.maxConcurrent, guard.banDuration)
+	}
+}
+
+func TestPeerResourceLimits(t *testing.T) {
+	cfg := config.NewConfiguration()
+	cfg.PeerMaxConcurrent = -1
+	if _, err := peerResourceLimits(cfg); err != nil {
+		t.Fatalf("Expected the resource manager to be created, got %v", err)
+	}
+	if opts, err := workerHostOptions(cfg, false); err != nil || opts != nil {
+		t.Errorf("Expected no host options for a consumer peer, got %v, %v", opts, err)
+	}
+}
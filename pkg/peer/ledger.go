@@ -0,0 +1,116 @@
+package peer
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+
+	llamav1 "github.com/crowdllama/crowdllama-pb/llama/v1"
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+	"github.com/crowdllama/crowdllama/pkg/ledger"
+)
+
+// receiptReadTimeout bounds how long a worker waits for a countersigned receipt
+const receiptReadTimeout = 5 * time.Second
+
+// setupLedger opens the credit ledger if one is configured. Workers also accept the receipts consumers
+// countersigned.
+func (p *Peer) setupLedger() error {
+	if p.Config == nil || p.Config.LedgerFile == "" {
+		return nil
+	}
+	l, err := ledger.Open(p.Config.LedgerFile, p.Host.ID(), p.logger)
+	if err != nil {
+		return err
+	}
+	p.Ledger = l
+	if p.WorkerMode {
+		p.Host.SetStreamHandler(crowdllama.ReceiptProtocol, p.handleReceipt)
+	}
+	return nil
+}
+
+// SignReceipt signs a receipt with the peer's key
+func (p *Peer) SignReceipt(r *ledger.Receipt) error {
+	key := p.Host.Peerstore().PrivKey(p.Host.ID())
+	if key == nil {
+		return errors.New("private key of the peer is not available")
+	}
+	return r.Sign(key)
+}
+
+// attachReceipt attaches a signed receipt for the tokens of a successful response, for the consumer to
+// countersign
+func (p *Peer) attachReceipt(resp *llamav1.BaseMessage, consumer peer.ID) {
+	generateResp := resp.GetGenerateResponse()
+	if p.Ledger == nil || generateResp == nil || crowdllama.GetResponseError(generateResp) != nil {
+		return
+	}
+	promptTokens, completionTokens := int64(generateResp.GetPromptEvalCount()), int64(generateResp.GetEvalCount())
+	if promptTokens+completionTokens == 0 {
+		return
+	}
+
+	r, err := ledger.NewReceipt(p.Host.ID(), consumer, generateResp.GetModel(), promptTokens, completionTokens, time.Now())
+	if err == nil {
+		err = p.SignReceipt(r)
+	}
+	var data []byte
+	if err == nil {
+		data, err = r.Marshal()
+	}
+	if err != nil {
+		p.logger.Warn("Failed to issue receipt", zap.String("remote_peer", consumer.String()), zap.Error(err))
+		return
+	}
+	crowdllama.SetResponseReceipt(generateResp, data)
+}
+
+// handleReceipt records a receipt the consumer countersigned
+func (p *Peer) handleReceipt(s network.Stream) {
+	if !p.acceptStream(s) {
+		return
+	}
+	defer func() {
+		if err := s.Close(); err != nil {
+			p.logger.Debug("Failed to close stream", zap.Error(err))
+		}
+	}()
+
+	if err := s.SetReadDeadline(time.Now().Add(receiptReadTimeout)); err != nil {
+		p.logger.Debug("Failed to set read deadline", zap.Error(err))
+	}
+	data, err := io.ReadAll(io.LimitReader(s, ledger.MaxReceiptSize+1))
+	if err != nil {
+		p.logger.Debug("Failed to read receipt", zap.Error(err))
+		return
+	}
+
+	remote := s.Conn().RemotePeer()
+	r, err := ledger.ParseReceipt(data)
+	if err == nil && (r.Worker != p.Host.ID().String() || r.Consumer != remote.String()) {
+		err = ledger.ErrInvalidReceipt
+	}
+	if err == nil {
+		err = p.Ledger.Record(r)
+	}
+	if err != nil {
+		p.logger.Debug("Refused receipt", zap.String("remote_peer", remote.String()), zap.Error(err))
+		return
+	}
+	p.logger.Debug("Recorded countersigned receipt",
+		zap.String("remote_peer", remote.String()),
+		zap.String("receipt_id", r.ID),
+		zap.Int64("tokens", r.Tokens()))
+}
+
+// applyLedgerMetadata advertises that the worker accepts countersigned receipts
+func (p *Peer) applyLedgerMetadata() {
+	if p.Ledger != nil && p.WorkerMode {
+		p.Metadata.Protocols = append(p.Metadata.Protocols, crowdllama.ReceiptProtocol)
+	}
+}
@@ -0,0 +1,101 @@
+package peer
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/crowdllama/crowdllama/pkg/hwprobe"
+)
+
+// localUseInterval is how often a worker yielding to local use samples the machine's usage
+const localUseInterval = 10 * time.Second
+
+// DefaultLocalUseThreshold is the share of CPU or GPU used by other processes at which a worker pauses
+const DefaultLocalUseThreshold = 0.5
+
+// minThroughputShare is the smallest share of its throughput a worker advertises while the owner uses the
+// machine, so that a fully busy machine still ranks behind idle workers instead of losing its score entirely
+const minThroughputShare = 0.05
+
+// localUseResumeSamples is how many samples in a row must stay below the threshold before a worker paused
+// for local use accepts requests again, so that short lulls in a game do not bring requests back
+const localUseResumeSamples = 3
+
+// setupLocalUseMonitor starts watching the machine's usage by other processes if the worker configuration asks
+// the worker to yield to its owner
+func (p *Peer) setupLocalUseMonitor(ctx context.Context) {
+	if !p.WorkerMode || p.Config == nil || !p.Config.YieldToLocalUse {
+		return
+	}
+
+	excluded := p.Config.BackendProcesses
+	if len(excluded) == 0 {
+		excluded = hwprobe.DefaultExcludedProcesses
+	}
+	threshold := DefaultLocalUseThreshold
+	if p.Config.LocalUseThreshold > 0 {
+		threshold = float64(p.Config.LocalUseThreshold) / 100
+	}
+
+	p.logger.Info("Yielding to local use",
+		zap.Float64("threshold", threshold),
+		zap.Strings("backend_processes", excluded))
+	go p.monitorLocalUse(ctx, hwprobe.New(excluded), threshold)
+}
+
+// monitorLocalUse samples the machine's usage until ctx is done
+func (p *Peer) monitorLocalUse(ctx context.Context, probe *hwprobe.Probe, threshold float64) {
+	ticker := time.NewTicker(localUseInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			usage, err := probe.Sample(ctx)
+			if err != nil {
+				p.logger.Debug("Failed to sample local usage", zap.Error(err))
+				continue
+			}
+			p.recordLocalUse(usage, threshold)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// recordLocalUse stores a usage sample, pausing the worker when other processes use at least threshold of the
+// CPU or GPU and resuming it once they have stayed below it for localUseResumeSamples samples
+func (p *Peer) recordLocalUse(usage hwprobe.Usage, threshold float64) {
+	p.availMu.Lock()
+	defer p.availMu.Unlock()
+
+	p.localUse = usage.Max()
+	p.localUseKnown = true
+	switch {
+	case p.localUse >= threshold:
+		p.calmSamples = 0
+		if !p.localBusy {
+			p.localBusy = true
+			p.logger.Info("Machine in local use, pausing worker",
+				zap.Float64("cpu", usage.CPU),
+				zap.Float64("gpu", usage.GPU),
+				zap.Int("in_flight", p.inflight))
+		}
+	case p.localBusy:
+		p.calmSamples++
+		if p.calmSamples >= localUseResumeSamples {
+			p.localBusy = false
+			p.logger.Info("Machine no longer in local use, resuming worker", zap.Float64("usage", p.localUse))
+		}
+	}
+}
+
+// LocalUse returns the share of the CPU or GPU other processes used in the last sample, and false if the
+// worker does not watch local use or has not sampled it yet
+func (p *Peer) LocalUse() (float64, bool) {
+	p.availMu.Lock()
+	defer p.availMu.Unlock()
+	return p.localUse, p.localUseKnown
+}
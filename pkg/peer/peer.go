@@ -3,8 +3,12 @@ package peer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ipfs/go-cid"
@@ -12,6 +16,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multihash"
 	"google.golang.org/protobuf/proto"
 
@@ -21,13 +26,23 @@ import (
 	"github.com/crowdllama/crowdllama/internal/discovery"
 	"github.com/crowdllama/crowdllama/pkg/config"
 	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+	"github.com/crowdllama/crowdllama/pkg/ledger"
+	"github.com/crowdllama/crowdllama/pkg/modelpull"
 	"github.com/crowdllama/crowdllama/pkg/peermanager"
+	"github.com/crowdllama/crowdllama/pkg/peerstate"
+	"github.com/crowdllama/crowdllama/pkg/schedule"
 	"github.com/crowdllama/crowdllama/pkg/version"
 )
 
 // MetadataUpdateInterval is the interval at which peer metadata is updated
 var MetadataUpdateInterval = 30 * time.Second
 
+// sessionIdleTimeout is how long an inference session may stay without requests before the worker closes it
+const sessionIdleTimeout = 5 * time.Minute
+
+// modelListTimeout bounds how long a metadata update waits for the backends to list their models
+const modelListTimeout = 5 * time.Second
+
 // SetMetadataUpdateInterval allows programmatically setting the metadata update interval
 func SetMetadataUpdateInterval(interval time.Duration) {
 	MetadataUpdateInterval = interval
@@ -49,6 +64,24 @@ type Peer struct {
 	// API handler for processing inference requests
 	APIHandler crowdllama.UnifiedAPIHandler
 
+	// Inference backend behind the API handler of a worker, nil for consumers
+	Backend crowdllama.Backend
+
+	// Pulls models on demand, nil unless enabled in the worker configuration
+	modelPuller *modelpull.Puller
+
+	// Consumers a worker serves, nil if it serves every peer
+	access *accessControl
+
+	// Per-peer rate limits and bans of a worker, nil for other peers
+	guard *streamGuard
+
+	// Receipts exchanged with other peers, nil unless a ledger file is configured
+	Ledger *ledger.Ledger
+
+	// Size limit and compression of inference messages, adjusted to each negotiated protocol
+	FrameOptions crowdllama.FrameOptions
+
 	// Peer management
 	PeerManager peermanager.I
 
@@ -65,6 +98,24 @@ type Peer struct {
 
 	// Bootstrap peers for reconnection
 	bootstrapPeers []string
+
+	// Persisted peer state, nil when persistence is disabled
+	stateStore *peerstate.Store
+
+	// Reachability last reported by AutoNAT (network.Reachability)
+	reachability atomic.Int32
+
+	// Availability of a worker: paused by the operator or by its schedule, and the requests it is serving
+	availMu       sync.Mutex
+	paused        bool
+	draining      bool
+	schedule      *schedule.Schedule
+	inflight      int
+	idle          chan struct{} // closed when the last in-flight request finishes, nil while nobody waits for it
+	localUse      float64       // share of CPU or GPU used by other processes in the last sample
+	localUseKnown bool
+	localBusy     bool // paused because the owner is using the machine
+	calmSamples   int  // samples in a row below the local use threshold while localBusy
 }
 
 // NewPeerWithConfig creates a new peer instance using the provided configuration
@@ -75,34 +126,196 @@ func NewPeerWithConfig(
 	workerMode bool,
 	logger *zap.Logger,
 ) (*Peer, error) {
-	h, kadDHT, err := discovery.NewHostAndDHT(ctx, privKey, logger)
+	bootstrapPeers, err := cfg.GetBootstrapPeers()
 	if err != nil {
+		return nil, fmt.Errorf("load bootstrap peers: %w", err)
+	}
+
+	backend, err := newBackend(cfg, workerMode, logger)
+	if err != nil {
+		return nil, err
+	}
+	access, err := newAccessControl(cfg, workerMode)
+	if err != nil {
+		return nil, err
+	}
+	workerOpts, err := workerHostOptions(cfg, workerMode)
+	if err != nil {
+		return nil, err
+	}
+
+	stateStore, err := openStateStore(cfg, workerMode, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	hostOpts := discovery.HostOptions{Relays: cfg.Relays, Libp2p: workerOpts}
+	if stateStore != nil {
+		hostOpts.DHT = append(hostOpts.DHT, stateStore.DHTOption())
+	}
+
+	h, kadDHT, err := discovery.NewHostAndDHT(ctx, privKey, logger, hostOpts)
+	if err != nil {
+		closeStateStore(stateStore, logger)
 		return nil, fmt.Errorf("new host and DHT: %w", err)
 	}
 
-	if err := bootstrapDHT(ctx, h, kadDHT, cfg, logger); err != nil {
+	if err := joinNetwork(ctx, h, kadDHT, bootstrapPeers, stateStore, logger); err != nil {
+		if closeErr := h.Close(); closeErr != nil {
+			logger.Debug("Failed to close host", zap.Error(closeErr))
+		}
+		closeStateStore(stateStore, logger)
 		return nil, err
 	}
 
 	logger.Debug("BootstrapDHT completed successfully")
 
-	peer := createPeerInstance(ctx, h, kadDHT, cfg, workerMode, logger)
+	peer := createPeerInstance(ctx, h, kadDHT, cfg, workerMode, backend, logger)
+	peer.bootstrapPeers = bootstrapPeers
+	peer.access = access
+	peer.guard = newStreamGuard(cfg, workerMode)
+	peer.setupAccessControl()
+	if err := peer.setupLedger(); err != nil {
+		peer.abortStart(stateStore)
+		return nil, err
+	}
 	setupStreamHandler(ctx, peer)
+	peer.setupModelPuller(ctx)
+	peer.setupLocalUseMonitor(ctx)
+	if err := peer.setupSchedule(ctx); err != nil {
+		peer.abortStart(stateStore)
+		return nil, err
+	}
+
+	if err := discovery.WatchReachability(ctx, h, logger, peer.setReachability); err != nil {
+		logger.Warn("Failed to watch reachability, it will be reported as unknown", zap.Error(err))
+	}
+
+	if stateStore != nil {
+		peer.stateStore = stateStore
+		peer.PeerManager.RestorePeers(stateStore.KnownPeers())
+		go stateStore.ConnectSavedPeers(ctx, h)
+		peer.startStatePersistence(ctx)
+	}
 
 	return peer, nil
 }
 
-func bootstrapDHT(ctx context.Context, h host.Host, kadDHT *dht.IpfsDHT, cfg *config.Configuration, logger *zap.Logger) error {
-	// Bootstrap with custom peers if provided, otherwise use defaults
-	if len(cfg.BootstrapPeers) > 0 {
-		if err := discovery.BootstrapDHTWithPeers(ctx, h, kadDHT, cfg.BootstrapPeers, logger); err != nil {
-			return fmt.Errorf("bootstrap DHT with custom peers: %w", err)
+// newBackend creates the inference backend of a worker from the configured endpoints, or returns nil for consumers
+func newBackend(cfg *config.Configuration, workerMode bool, logger *zap.Logger) (crowdllama.Backend, error) {
+	if !workerMode {
+		return nil, nil
+	}
+
+	configs := []crowdllama.BackendConfig{{Type: cfg.Backend, URL: cfg.GetBackendURL()}}
+	for _, spec := range cfg.BackendEndpoints {
+		backendCfg, err := crowdllama.ParseBackendEndpoint(spec)
+		if err != nil {
+			return nil, fmt.Errorf("create inference backend: %w", err)
 		}
-	} else {
-		if err := discovery.BootstrapDHT(ctx, h, kadDHT, logger); err != nil {
-			return fmt.Errorf("bootstrap DHT: %w", err)
+		configs = append(configs, backendCfg)
+	}
+
+	backends := make([]crowdllama.Backend, 0, len(configs))
+	for _, backendCfg := range configs {
+		backendCfg.APIKey = cfg.BackendAPIKey
+		backend, err := crowdllama.NewBackend(backendCfg)
+		if err != nil {
+			return nil, fmt.Errorf("create inference backend: %w", err)
 		}
+		logger.Info("Using inference backend", zap.String("backend", backend.Name()), zap.String("backend_url", backendCfg.URL))
+		backends = append(backends, backend)
+	}
+
+	multi, err := crowdllama.NewMultiBackend(backends...)
+	if err != nil {
+		return nil, fmt.Errorf("create inference backend: %w", err)
 	}
+	if policy := modelPolicy(cfg); !policy.IsZero() {
+		logger.Info("Enforcing model policy",
+			zap.Strings("allow", policy.Allow),
+			zap.Strings("deny", policy.Deny),
+			zap.Int("max_model_size_gb", cfg.MaxModelSizeGB),
+			zap.Int("max_context", policy.MaxContext),
+			zap.Int("max_predict", policy.MaxPredict))
+		return crowdllama.NewPolicyBackend(multi, policy), nil
+	}
+	return multi, nil
+}
+
+// modelPolicy returns the model policy of the worker configuration
+func modelPolicy(cfg *config.Configuration) crowdllama.ModelPolicy {
+	return crowdllama.ModelPolicy{
+		Allow:        cfg.AllowModels,
+		Deny:         cfg.DenyModels,
+		MaxModelSize: int64(cfg.MaxModelSizeGB) << 30,
+		MaxContext:   cfg.MaxContext,
+		MaxPredict:   cfg.MaxPredict,
+	}
+}
+
+// openStateStore opens the persisted peer state if a state directory is configured
+func openStateStore(cfg *config.Configuration, workerMode bool, logger *zap.Logger) (*peerstate.Store, error) {
+	if cfg.StateDir == "" {
+		return nil, nil
+	}
+
+	component := "consumer"
+	if workerMode {
+		component = "worker"
+	}
+
+	stateStore, err := peerstate.Open(cfg.StateDir, component, logger)
+	if err != nil {
+		return nil, fmt.Errorf("open peer state: %w", err)
+	}
+	return stateStore, nil
+}
+
+// closeStateStore closes the persisted peer state, if there is any
+func closeStateStore(stateStore *peerstate.Store, logger *zap.Logger) {
+	if stateStore == nil {
+		return
+	}
+	if err := stateStore.Close(); err != nil {
+		logger.Debug("Failed to close peer state", zap.Error(err))
+	}
+}
+
+// abortStart closes a peer and its persisted peer state after a later step of starting it failed
+func (p *Peer) abortStart(stateStore *peerstate.Store) {
+	if err := p.Close(); err != nil {
+		p.logger.Debug("Failed to close peer", zap.Error(err))
+	}
+	closeStateStore(stateStore, p.logger)
+}
+
+// joinNetwork bootstraps the DHT, falling back to previously known peers when no bootstrap peer is reachable
+func joinNetwork(
+	ctx context.Context,
+	h host.Host,
+	kadDHT *dht.IpfsDHT,
+	bootstrapPeers []string,
+	stateStore *peerstate.Store,
+	logger *zap.Logger,
+) error {
+	if stateStore != nil {
+		stateStore.RestorePeerstore(h)
+	}
+
+	err := discovery.BootstrapDHTWithPeers(ctx, h, kadDHT, bootstrapPeers, logger)
+	if err == nil {
+		return nil
+	}
+	if stateStore == nil {
+		return fmt.Errorf("bootstrap DHT: %w", err)
+	}
+
+	if rejoinErr := stateStore.Rejoin(ctx, h, kadDHT); rejoinErr != nil {
+		return fmt.Errorf("bootstrap DHT: %w (rejoin via saved peers: %w)", err, rejoinErr)
+	}
+
+	logger.Warn("Bootstrap peers unreachable, joined the network via saved peers", zap.Error(err))
 	return nil
 }
 
@@ -112,6 +325,7 @@ func createPeerInstance(
 	kadDHT *dht.IpfsDHT,
 	cfg *config.Configuration,
 	workerMode bool,
+	backend crowdllama.Backend,
 	logger *zap.Logger,
 ) *Peer {
 	// Initialize metadata
@@ -126,31 +340,34 @@ func createPeerInstance(
 
 	// Initialize peer manager
 	peerManagerConfig := getPeerManagerConfig()
-
-	// Set up API handler based on mode
-	var apiHandler crowdllama.UnifiedAPIHandler
-	if workerMode {
-		apiHandler = crowdllama.WorkerAPIHandler(cfg.GetOllamaBaseURL())
-	} else {
-		apiHandler = crowdllama.DefaultAPIHandler
+	if cfg.MaxProviders > 0 {
+		peerManagerConfig.MaxProviders = cfg.MaxProviders
 	}
 
 	peer := &Peer{
-		Host:              h,
-		DHT:               kadDHT,
-		Metadata:          metadata,
-		Config:            cfg,
-		WorkerMode:        workerMode,
-		APIHandler:        apiHandler,
-		PeerManager:       peermanager.NewManager(ctx, h, kadDHT, logger, peerManagerConfig),
+		Host:        h,
+		DHT:         kadDHT,
+		Metadata:    metadata,
+		Config:      cfg,
+		WorkerMode:  workerMode,
+		APIHandler:  crowdllama.DefaultAPIHandler,
+		Backend:     backend,
+		PeerManager: peermanager.NewManager(ctx, h, kadDHT, logger, peerManagerConfig),
+		FrameOptions: crowdllama.FrameOptions{
+			MaxSize:  cfg.MaxMessageSizeMB * 1024 * 1024,
+			Compress: !cfg.DisableCompression,
+		},
 		metadataCtx:       metadataCtx,
 		metadataCancel:    metadataCancel,
 		advertisingCtx:    advertisingCtx,
 		advertisingCancel: advertisingCancel,
-		bootstrapPeers:    cfg.BootstrapPeers,
 		logger:            logger,
 	}
 
+	if backend != nil {
+		peer.APIHandler = crowdllama.BackendAPIHandler(backend)
+	}
+
 	return peer
 }
 
@@ -175,10 +392,17 @@ func getPeerManagerConfig() *peermanager.Config {
 }
 
 func setupStreamHandler(ctx context.Context, peer *Peer) {
-	// Set up stream handler with the peer instance
-	peer.Host.SetStreamHandler(crowdllama.InferenceProtocol, func(s network.Stream) {
-		peer.handleInferenceRequest(ctx, s)
-	})
+	// Set up stream handlers with the peer instance, one per supported inference protocol version
+	for _, protocolID := range crowdllama.InferenceProtocols() {
+		peer.Host.SetStreamHandler(protocolID, func(s network.Stream) {
+			peer.handleInferenceRequest(ctx, s)
+		})
+	}
+	for _, protocolID := range crowdllama.InferenceSessionProtocols() {
+		peer.Host.SetStreamHandler(protocolID, func(s network.Stream) {
+			peer.handleInferenceSession(ctx, s)
+		})
+	}
 }
 
 // NewPeer creates a new peer instance
@@ -201,58 +425,189 @@ func (p *Peer) handleInferenceRequest(ctx context.Context, s network.Stream) {
 		p.logger.Debug("Consumer peer received inference request, ignoring")
 		return
 	}
+	if !p.acceptStream(s) {
+		return
+	}
+
+	// Refuse peers without access before reading their request
+	if !p.admitsConsumer(s.Conn().RemotePeer()) {
+		if err := p.writePBMessage(s, p.errorResponse(crowdllama.ErrAccessDenied)); err != nil {
+			p.logger.Debug("Failed to write PB response", zap.Error(err))
+		}
+		return
+	}
 
 	// Read PB message from stream
 	req, err := p.readPBMessage(s)
 	if err != nil {
 		p.logger.Debug("Failed to read PB message", zap.Error(err))
+		p.reportReadError(s.Conn().RemotePeer(), err)
+		return
+	}
+
+	resp := p.serveInferenceRequest(ctx, req, s.Conn().RemotePeer())
+
+	// Write PB response to stream
+	p.logger.Debug("Worker sending inference response to network",
+		zap.String("remote_peer", s.Conn().RemotePeer().String()))
+	err = p.writePBMessage(s, resp)
+	if errors.Is(err, crowdllama.ErrMessageTooLarge) {
+		err = p.writePBMessage(s, p.errorResponse(err))
+	}
+	if err != nil {
+		p.logger.Debug("Failed to write PB response", zap.Error(err))
 		return
 	}
 
+	p.logger.Info("Worker completed inference request",
+		zap.String("remote_peer", s.Conn().RemotePeer().String()))
+	p.logger.Debug("StreamHandler completed")
+}
+
+// handleInferenceSession serves a long-lived session stream. Requests are read as they arrive and processed
+// concurrently; each response is tagged with the ID of the request it answers.
+func (p *Peer) handleInferenceSession(ctx context.Context, s network.Stream) {
+	remotePeer := s.Conn().RemotePeer().String()
+	frameOpts := p.FrameOptions.ForProtocol(string(s.Protocol()))
+	var (
+		writeMu  sync.Mutex
+		inflight sync.WaitGroup
+	)
+	defer func() {
+		inflight.Wait()
+		if err := s.Close(); err != nil {
+			p.logger.Debug("Failed to close session stream", zap.Error(err))
+		}
+	}()
+
+	if !p.WorkerMode {
+		p.logger.Debug("Consumer peer received inference session, ignoring")
+		return
+	}
+	if !p.acceptStream(s) {
+		return
+	}
+	// Refuse peers without access before reading any of their frames
+	if !p.admitsConsumer(s.Conn().RemotePeer()) {
+		if err := s.Reset(); err != nil {
+			p.logger.Debug("Failed to reset session stream", zap.Error(err))
+		}
+		return
+	}
+
+	p.logger.Debug("Inference session opened", zap.String("remote_peer", remotePeer))
+	for {
+		// Idle sessions are closed; the gateway opens a new one on its next request
+		if err := s.SetReadDeadline(time.Now().Add(sessionIdleTimeout)); err != nil {
+			p.logger.Debug("Failed to set session read deadline", zap.Error(err))
+			return
+		}
+
+		requestID, req, err := crowdllama.ReadSessionFrame(s, frameOpts)
+		if err != nil {
+			p.logger.Debug("Inference session closed", zap.String("remote_peer", remotePeer), zap.Error(err))
+			p.reportReadError(s.Conn().RemotePeer(), err)
+			return
+		}
+
+		inflight.Add(1)
+		go func() {
+			defer inflight.Done()
+			// Access granted by a token may expire while the session is open
+			var resp *llamav1.BaseMessage
+			if p.admitsConsumer(s.Conn().RemotePeer()) {
+				resp = p.serveInferenceRequest(ctx, req, s.Conn().RemotePeer())
+			} else {
+				resp = p.errorResponse(crowdllama.ErrAccessDenied)
+			}
+
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			err := crowdllama.WriteSessionFrame(s, frameOpts, requestID, resp)
+			if errors.Is(err, crowdllama.ErrMessageTooLarge) {
+				err = crowdllama.WriteSessionFrame(s, frameOpts, requestID, p.errorResponse(err))
+			}
+			if err != nil {
+				p.logger.Debug("Failed to write session response",
+					zap.Uint64("request_id", requestID),
+					zap.Error(err))
+				return
+			}
+			p.logger.Info("Worker completed inference request",
+				zap.String("remote_peer", remotePeer),
+				zap.Uint64("request_id", requestID))
+		}()
+	}
+}
+
+// serveInferenceRequest processes a request of remote unless the peer is over its rate or concurrency limits,
+// attaching a receipt for the tokens served
+func (p *Peer) serveInferenceRequest(ctx context.Context, req *llamav1.BaseMessage, remote peer.ID) *llamav1.BaseMessage {
+	if p.guard != nil {
+		if err := p.guard.admit(remote); err != nil {
+			p.logger.Info("Refusing inference request", zap.String("remote_peer", remote.String()), zap.Error(err))
+			return p.errorResponse(err)
+		}
+		defer p.guard.release(remote)
+	}
+	resp := p.processInferenceRequest(ctx, req, remote.String())
+	p.attachReceipt(resp, remote)
+	return resp
+}
+
+// processInferenceRequest runs a request through the API handler, turning failures into an error response
+func (p *Peer) processInferenceRequest(ctx context.Context, req *llamav1.BaseMessage, remotePeer string) *llamav1.BaseMessage {
 	// Log the inference request details
 	if generateReq := req.GetGenerateRequest(); generateReq != nil {
 		p.logger.Debug("Worker received inference request from network",
 			zap.String("model", generateReq.Model),
 			zap.String("prompt", generateReq.Prompt),
 			zap.Bool("stream", generateReq.Stream),
-			zap.String("remote_peer", s.Conn().RemotePeer().String()))
+			zap.String("remote_peer", remotePeer))
 		p.logger.Info("Worker received generate request",
 			zap.String("model", generateReq.Model),
 			zap.String("prompt", generateReq.Prompt),
 			zap.Bool("stream", generateReq.Stream),
-			zap.String("remote_peer", s.Conn().RemotePeer().String()))
+			zap.String("remote_peer", remotePeer))
 	}
 
-	// Add logger to context for API handler
-	type loggerKey struct{}
-	ctxWithLogger := context.WithValue(ctx, loggerKey{}, p.logger)
+	if err := p.beginRequest(); err != nil {
+		p.logger.Info("Refusing inference request", zap.String("remote_peer", remotePeer), zap.Error(err))
+		return p.errorResponse(err)
+	}
+	defer p.endRequest()
+
+	// Add logger and worker identity to context for API handler
+	workerID := p.Host.ID().String()
+	handlerCtx := crowdllama.WithWorkerID(crowdllama.WithLogger(ctx, p.logger), workerID)
 
 	// Process the request using the API handler
-	resp, err := p.APIHandler(ctxWithLogger, req)
+	resp, err := p.APIHandler(handlerCtx, req)
 	if err != nil {
 		p.logger.Error("Failed to process inference request", zap.Error(err))
-		// Create error response
-		resp = &llamav1.BaseMessage{
-			Message: &llamav1.BaseMessage_GenerateResponse{
-				GenerateResponse: &llamav1.GenerateResponse{
-					Response: fmt.Sprintf("Error: %v", err),
-					Done:     true,
-				},
-			},
-		}
+		p.recordMissingModel(req, remotePeer, err)
+		return p.errorResponse(err)
 	}
 
-	// Write PB response to stream
-	p.logger.Debug("Worker sending inference response to network",
-		zap.String("remote_peer", s.Conn().RemotePeer().String()))
-	if err := p.writePBMessage(s, resp); err != nil {
-		p.logger.Debug("Failed to write PB response", zap.Error(err))
-		return
+	// Handlers that do not know the worker identity leave it empty
+	if generateResp := resp.GetGenerateResponse(); generateResp != nil && generateResp.WorkerId == "" {
+		generateResp.WorkerId = workerID
 	}
+	return resp
+}
 
-	p.logger.Info("Worker completed inference request",
-		zap.String("remote_peer", s.Conn().RemotePeer().String()))
-	p.logger.Debug("StreamHandler completed")
+// errorResponse creates the response sent to the consumer when a request fails. The error is also attached
+// with a status code, while older gateways show the response text.
+func (p *Peer) errorResponse(err error) *llamav1.BaseMessage {
+	resp := &llamav1.GenerateResponse{
+		Response: fmt.Sprintf("Error: %v", err),
+		Done:     true,
+		WorkerId: p.Host.ID().String(),
+	}
+	crowdllama.SetResponseError(resp, crowdllama.NewInferenceError(err))
+	return &llamav1.BaseMessage{
+		Message: &llamav1.BaseMessage_GenerateResponse{GenerateResponse: resp},
+	}
 }
 
 // readPBMessage reads a length-prefixed protobuf message from a network stream
@@ -261,7 +616,7 @@ func (p *Peer) readPBMessage(s network.Stream) (*llamav1.BaseMessage, error) {
 		return nil, fmt.Errorf("failed to set read deadline: %w", err)
 	}
 
-	msg, err := crowdllama.ReadLengthPrefixedPB(s)
+	msg, err := crowdllama.ReadLengthPrefixedPBWithOptions(s, p.FrameOptions.ForProtocol(string(s.Protocol())))
 	if err != nil {
 		return nil, fmt.Errorf("failed to read length-prefixed PB message: %w", err)
 	}
@@ -272,7 +627,7 @@ func (p *Peer) readPBMessage(s network.Stream) (*llamav1.BaseMessage, error) {
 
 // writePBMessage writes a length-prefixed protobuf message to a network stream
 func (p *Peer) writePBMessage(s network.Stream, msg *llamav1.BaseMessage) error {
-	if err := crowdllama.WriteLengthPrefixedPB(s, msg); err != nil {
+	if err := crowdllama.WriteLengthPrefixedPBWithOptions(s, msg, p.FrameOptions.ForProtocol(string(s.Protocol()))); err != nil {
 		return fmt.Errorf("failed to write length-prefixed PB message: %w", err)
 	}
 
@@ -291,9 +646,19 @@ func (p *Peer) SetupMetadataHandler() {
 			}
 		}()
 		p.logger.Debug("Peer received metadata request", zap.String("peer", s.Conn().RemotePeer().String()))
+		if p.guard != nil && !p.guard.allowMetadata(s.Conn().RemotePeer()) {
+			p.logger.Debug("Refused metadata request over the peer's rate limit", zap.String("peer", s.Conn().RemotePeer().String()))
+			if err := s.Reset(); err != nil {
+				p.logger.Debug("Failed to reset stream", zap.Error(err))
+			}
+			return
+		}
 
-		// Serialize metadata to JSON
-		metadataJSON, err := p.Metadata.ToJSON()
+		// Serialize metadata to JSON, with the current availability so a pause is seen without waiting for
+		// the next metadata update
+		metadata := *p.Metadata
+		p.applyAvailability(&metadata)
+		metadataJSON, err := metadata.ToJSON()
 		if err != nil {
 			p.logger.Debug("Failed to serialize metadata", zap.Error(err))
 			return
@@ -315,16 +680,32 @@ func (p *Peer) SetupMetadataHandler() {
 	p.logger.Debug("Metadata handler setup complete")
 }
 
+// setReachability records the reachability reported by AutoNAT
+func (p *Peer) setReachability(reachability network.Reachability) {
+	p.reachability.Store(int32(reachability))
+}
+
+// Reachability returns the reachability last reported by AutoNAT
+func (p *Peer) Reachability() network.Reachability {
+	return network.Reachability(p.reachability.Load())
+}
+
 // UpdateMetadata updates the peer's internal metadata
 func (p *Peer) UpdateMetadata() error {
 	if p.WorkerMode {
-		// Worker mode: use hardcoded values as requested
-		models := []string{"llama-2-7b", "llama-2-13b", "mistral-7b", "tinyllama"}
+		// Worker mode: models come from the backends, hardware values are still hardcoded
+		models := p.workerModels()
 		tokensThroughput := 150.0 // tokens/sec
 		vramGB := 24              // VRAM GB
 		load := 0.3               // current load (0.0 to 1.0)
 		gpuModel := "RTX 4090"
 
+		// Capacity the owner's own work takes is not available to the network
+		if localUse, ok := p.LocalUse(); ok {
+			load = max(load, localUse)
+			tokensThroughput *= max(1-localUse, minThroughputShare)
+		}
+
 		// Update the metadata
 		p.Metadata.SupportedModels = models
 		p.Metadata.TokensThroughput = tokensThroughput
@@ -333,6 +714,20 @@ func (p *Peer) UpdateMetadata() error {
 		p.Metadata.GPUModel = gpuModel
 		p.Metadata.LastUpdated = time.Now()
 		p.Metadata.Version = version.CommitHash // Set the CrowdLlama version
+		p.Metadata.Reachability = crowdllama.ReachabilityFromNetwork(p.Reachability())
+		p.Metadata.ProtocolVersion = crowdllama.ProtocolVersion
+		p.Metadata.Protocols = crowdllama.SupportedProtocols()
+		p.Metadata.Features = crowdllama.WorkerFeatures()
+		p.Metadata.MaxMessageSize = p.FrameOptions.Limit()
+		p.Metadata.VisionModels = nil
+		if p.Config != nil && len(p.Config.VisionModels) > 0 {
+			p.Metadata.VisionModels = p.Config.VisionModels
+			p.Metadata.Features = append(p.Metadata.Features, crowdllama.FeatureVision)
+		}
+		p.applyModelPullMetadata()
+		p.applyAccessMetadata()
+		p.applyLedgerMetadata()
+		p.applyAvailability(p.Metadata)
 
 		p.logger.Debug("Updated worker peer metadata",
 			zap.Strings("models", models),
@@ -340,7 +735,11 @@ func (p *Peer) UpdateMetadata() error {
 			zap.Int("vram", vramGB),
 			zap.Float64("load", load),
 			zap.String("gpu", gpuModel),
-			zap.String("version", p.Metadata.Version))
+			zap.String("version", p.Metadata.Version),
+			zap.String("reachability", p.Metadata.Reachability),
+			zap.Strings("features", p.Metadata.Features),
+			zap.Strings("vision_models", p.Metadata.VisionModels),
+			zap.Bool("paused", p.Metadata.Paused))
 	} else {
 		// Consumer mode: empty resource advertisement
 		p.Metadata.SupportedModels = []string{}
@@ -350,6 +749,11 @@ func (p *Peer) UpdateMetadata() error {
 		p.Metadata.GPUModel = ""
 		p.Metadata.LastUpdated = time.Now()
 		p.Metadata.Version = version.CommitHash
+		p.Metadata.Reachability = crowdllama.ReachabilityFromNetwork(p.Reachability())
+		p.Metadata.ProtocolVersion = crowdllama.ProtocolVersion
+		p.Metadata.Protocols = []string{crowdllama.MetadataProtocol}
+		p.Metadata.Features = nil
+		p.Metadata.VisionModels = nil
 
 		p.logger.Debug("Updated consumer peer metadata", zap.String("version", p.Metadata.Version))
 	}
@@ -357,6 +761,30 @@ func (p *Peer) UpdateMetadata() error {
 	return nil
 }
 
+// workerModels returns the models a worker advertises: those its backends list, followed by the configured
+// models and vision models
+func (p *Peer) workerModels() []string {
+	models := []string{}
+	if p.Backend != nil {
+		ctx, cancel := context.WithTimeout(p.metadataCtx, modelListTimeout)
+		defer cancel()
+		listed, err := p.Backend.ListModels(ctx)
+		if err != nil {
+			p.logger.Warn("Failed to list backend models", zap.Error(err))
+		}
+		models = append(models, listed...)
+	}
+
+	if p.Config != nil {
+		for _, model := range slices.Concat(p.Config.Models, p.Config.VisionModels) {
+			if !slices.Contains(models, model) {
+				models = append(models, model)
+			}
+		}
+	}
+	return models
+}
+
 // StartMetadataUpdates starts periodic metadata updates
 func (p *Peer) StartMetadataUpdates() {
 	go func() {
@@ -399,6 +827,24 @@ func (p *Peer) StopMetadataUpdates() {
 	p.removeMetadataHandler()
 }
 
+// Close closes the peer's DHT, persisted state, host and ledger, ending all its connections
+func (p *Peer) Close() error {
+	if err := p.DHT.Close(); err != nil {
+		p.logger.Debug("Failed to close DHT", zap.Error(err))
+	}
+	// The state store backs the DHT's records, so it is closed after the DHT
+	closeStateStore(p.stateStore, p.logger)
+	if p.Ledger != nil {
+		if err := p.Ledger.Close(); err != nil {
+			p.logger.Debug("Failed to close ledger", zap.Error(err))
+		}
+	}
+	if err := p.Host.Close(); err != nil {
+		return fmt.Errorf("close host: %w", err)
+	}
+	return nil
+}
+
 // removeMetadataHandler removes the metadata protocol handler
 func (p *Peer) removeMetadataHandler() {
 	p.Host.RemoveStreamHandler(crowdllama.MetadataProtocol)
@@ -470,6 +916,11 @@ func (p *Peer) AdvertisePeer(_ context.Context, namespace string) {
 		for {
 			select {
 			case <-ticker.C:
+				// Paused workers stop advertising so that gateways stop discovering them
+				if p.Availability().Paused {
+					continue
+				}
+
 				// Check if DHT is connected before attempting to advertise
 				if !p.IsDHTConnected() {
 					p.logger.Debug("DHT is disconnected, attempting to reconnect to bootstrap peers...")
@@ -503,6 +954,43 @@ func (p *Peer) AdvertisePeer(_ context.Context, namespace string) {
 	}()
 }
 
+// startStatePersistence periodically writes the peer state to disk
+func (p *Peer) startStatePersistence(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(peerstate.DefaultSaveInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := p.SaveState(ctx); err != nil {
+					p.logger.Warn("Failed to save peer state", zap.Error(err))
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// SaveState writes the peerstore, DHT records and known peers to disk. It is a no-op when
+// state persistence is disabled.
+func (p *Peer) SaveState(ctx context.Context) error {
+	if p.stateStore == nil {
+		return nil
+	}
+
+	knownPeers := make([]*crowdllama.Resource, 0)
+	for _, metadata := range p.PeerManager.GetAvailablePeers() {
+		knownPeers = append(knownPeers, metadata)
+	}
+
+	if err := p.stateStore.Save(ctx, p.Host, p.DHT, knownPeers); err != nil {
+		return fmt.Errorf("save peer state: %w", err)
+	}
+	return nil
+}
+
 // stopAdvertising stops the advertising process
 func (p *Peer) stopAdvertising() {
 	if p.advertisingCancel != nil {
@@ -518,8 +1006,8 @@ func (p *Peer) IsDHTConnected() bool {
 
 // AttemptBootstrapReconnection attempts to reconnect to bootstrap peers
 func (p *Peer) AttemptBootstrapReconnection(ctx context.Context) error {
-	if len(p.bootstrapPeers) > 0 {
-		return fmt.Errorf("bootstrap DHT with peers: %w", discovery.BootstrapDHTWithPeers(ctx, p.Host, p.DHT, p.bootstrapPeers, p.logger))
+	if err := discovery.BootstrapDHTWithPeers(ctx, p.Host, p.DHT, p.bootstrapPeers, p.logger); err != nil {
+		return fmt.Errorf("bootstrap DHT: %w", err)
 	}
-	return fmt.Errorf("bootstrap DHT: %w", discovery.BootstrapDHT(ctx, p.Host, p.DHT, p.logger))
+	return nil
 }
@@ -0,0 +1,114 @@
+package peer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"go.uber.org/zap"
+
+	llamav1 "github.com/crowdllama/crowdllama-pb/llama/v1"
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+	"github.com/crowdllama/crowdllama/pkg/modelpull"
+)
+
+// maxModelHintSize bounds the size of a model hint message
+const maxModelHintSize = 4096
+
+// modelHintReadTimeout bounds how long a worker waits for a model hint
+const modelHintReadTimeout = 5 * time.Second
+
+// setupModelPuller enables on-demand model pulls if the worker configuration asks for them. Pulls go through the
+// first Ollama backend, since other backends cannot download models.
+func (p *Peer) setupModelPuller(ctx context.Context) {
+	if !p.WorkerMode || p.Config == nil || !p.Config.PullModels {
+		return
+	}
+
+	backend := p.Backend
+	if policyBackend, ok := backend.(*crowdllama.PolicyBackend); ok {
+		backend = policyBackend.Unwrap()
+	}
+	var ollama *crowdllama.OllamaBackend
+	if multi, ok := backend.(*crowdllama.MultiBackend); ok {
+		for _, backend := range multi.Backends() {
+			if ollama, ok = backend.(*crowdllama.OllamaBackend); ok {
+				break
+			}
+		}
+	}
+	if ollama == nil {
+		p.logger.Warn("On-demand model pulls need an Ollama backend, disabling them")
+		return
+	}
+
+	policy := modelPolicy(p.Config)
+	p.modelPuller = modelpull.New(ctx, ollama, modelpull.Config{
+		Threshold:    p.Config.PullThreshold,
+		DiskQuota:    int64(p.Config.ModelDiskQuotaGB) << 30,
+		MaxModelSize: policy.MaxModelSize,
+		Allow:        policy.AllowsModel,
+	}, p.logger)
+	p.Host.SetStreamHandler(crowdllama.ModelHintProtocol, p.handleModelHint)
+	p.logger.Info("On-demand model pulls enabled",
+		zap.Int("threshold", p.Config.PullThreshold),
+		zap.Int("disk_quota_gb", p.Config.ModelDiskQuotaGB))
+}
+
+// handleModelHint records the demand a gateway reports for a model no worker serves
+func (p *Peer) handleModelHint(s network.Stream) {
+	if !p.acceptStream(s) {
+		return
+	}
+	defer func() {
+		if err := s.Close(); err != nil {
+			p.logger.Debug("Failed to close stream", zap.Error(err))
+		}
+	}()
+
+	// Peers without access may not make the worker download models
+	if !p.admitsConsumer(s.Conn().RemotePeer()) {
+		return
+	}
+
+	if err := s.SetReadDeadline(time.Now().Add(modelHintReadTimeout)); err != nil {
+		p.logger.Debug("Failed to set read deadline", zap.Error(err))
+	}
+	data, err := io.ReadAll(io.LimitReader(s, maxModelHintSize))
+	if err != nil {
+		p.logger.Debug("Failed to read model hint", zap.Error(err))
+		return
+	}
+
+	var hint crowdllama.ModelHint
+	if err := json.Unmarshal(data, &hint); err != nil {
+		p.logger.Debug("Ignoring malformed model hint", zap.Error(err))
+		return
+	}
+	p.logger.Debug("Received model hint",
+		zap.String("model", hint.Model),
+		zap.String("remote_peer", s.Conn().RemotePeer().String()))
+	p.modelPuller.RecordDemand(hint.Model, s.Conn().RemotePeer().String())
+}
+
+// recordMissingModel counts a request of a peer the backends rejected because they do not have the model
+func (p *Peer) recordMissingModel(req *llamav1.BaseMessage, remotePeer string, err error) {
+	if p.modelPuller == nil || !errors.Is(err, crowdllama.ErrModelNotFound) {
+		return
+	}
+	p.modelPuller.RecordDemand(req.GetGenerateRequest().GetModel(), remotePeer)
+}
+
+// applyModelPullMetadata advertises on-demand pulls and their progress
+func (p *Peer) applyModelPullMetadata() {
+	p.Metadata.ModelPulls = nil
+	if p.modelPuller == nil {
+		return
+	}
+	p.Metadata.Features = append(p.Metadata.Features, crowdllama.FeaturePull)
+	p.Metadata.Protocols = append(p.Metadata.Protocols, crowdllama.ModelHintProtocol)
+	p.Metadata.ModelPulls = p.modelPuller.Progress()
+}
@@ -4,6 +4,7 @@ package peermanager
 import (
 	"context"
 	"fmt"
+	"slices"
 	"sync"
 	"time"
 
@@ -25,7 +26,10 @@ type I interface {
 	GetAvailableWorkers() map[string]*crowdllama.Resource
 	GetAvailableConsumers() map[string]*crowdllama.Resource
 	FindBestWorker(requiredModel string) *crowdllama.Resource
+	FindBestWorkerWithFeatures(requiredModel string, requiredFeatures []string) *crowdllama.Resource
+	SetWorkerWeight(weight func(peerID string) float64)
 	AddOrUpdatePeer(peerID string, metadata *crowdllama.Resource)
+	RestorePeers(peers []*crowdllama.Resource)
 	RemovePeer(peerID string)
 	IsPeerUnhealthy(peerID string) bool
 	MarkPeerAsRecentlyRemoved(peerID string)
@@ -47,6 +51,9 @@ type Manager struct {
 	recentlyRemoved map[string]time.Time
 	peerMu          sync.RWMutex
 
+	// Scales the score of workers in FindBestWorker, nil weighs all workers alike
+	workerWeight func(peerID string) float64
+
 	// Discovery management
 	discoveryCtx    context.Context
 	discoveryCancel context.CancelFunc
@@ -69,6 +76,21 @@ type Config struct {
 	AdvertisingInterval    time.Duration
 	MetadataUpdateInterval time.Duration
 	PeerHealthConfig       *PeerHealthConfig
+
+	// Discovery round settings, zero values use the discovery package defaults
+	MaxProviders         int
+	DiscoveryConcurrency int
+	DiscoveryTimeout     time.Duration
+}
+
+// DiscoveryOptions returns the discovery options for this configuration, reporting peers to onPeer as they arrive
+func (c *Config) DiscoveryOptions(onPeer func(*crowdllama.Resource)) discovery.DiscoveryOptions {
+	return discovery.DiscoveryOptions{
+		MaxProviders: c.MaxProviders,
+		Concurrency:  c.DiscoveryConcurrency,
+		RoundTimeout: c.DiscoveryTimeout,
+		OnPeer:       onPeer,
+	}
 }
 
 // PeerHealthConfig holds peer health management settings
@@ -100,6 +122,9 @@ func DefaultConfig() *Config {
 		AdvertisingInterval:    30 * time.Second,
 		MetadataUpdateInterval: 30 * time.Second,
 		PeerHealthConfig:       DefaultPeerHealthConfig(),
+		MaxProviders:           discovery.DefaultMaxProviders,
+		DiscoveryConcurrency:   discovery.DefaultDiscoveryConcurrency,
+		DiscoveryTimeout:       discovery.DefaultDiscoveryTimeout,
 	}
 }
 
@@ -208,6 +233,33 @@ func (pm *Manager) AddOrUpdatePeer(peerID string, metadata *crowdllama.Resource)
 	}
 }
 
+// RestorePeers adds peers loaded from persisted state. They start out unhealthy and only become
+// available once a health check confirms they are reachable again.
+func (pm *Manager) RestorePeers(peers []*crowdllama.Resource) {
+	pm.peerMu.Lock()
+	defer pm.peerMu.Unlock()
+	now := time.Now()
+	restored := 0
+	for _, metadata := range peers {
+		if metadata == nil || metadata.PeerID == "" || metadata.PeerID == pm.host.ID().String() {
+			continue
+		}
+		if _, exists := pm.peers[metadata.PeerID]; exists {
+			continue
+		}
+		pm.peers[metadata.PeerID] = &PeerInfo{
+			PeerID:          metadata.PeerID,
+			Metadata:        metadata,
+			LastSeen:        now,
+			LastHealthCheck: time.Time{}, // check on the next health check round
+			IsHealthy:       false,
+			LastMetadataAge: time.Since(metadata.LastUpdated),
+		}
+		restored++
+	}
+	pm.logger.Info("Restored peers from persisted state", zap.Int("restored_peers", restored))
+}
+
 // RemovePeer removes a peer from the manager and marks it as recently removed
 func (pm *Manager) RemovePeer(peerID string) {
 	pm.peerMu.Lock()
@@ -284,11 +336,11 @@ func (pm *Manager) GetAvailablePeers() map[string]*crowdllama.Resource {
 	return result
 }
 
-// GetAvailableWorkers returns only worker peers
+// GetAvailableWorkers returns the worker peers that accept requests, leaving out paused workers
 func (pm *Manager) GetAvailableWorkers() map[string]*crowdllama.Resource {
 	result := make(map[string]*crowdllama.Resource)
 	for peerID, info := range pm.GetHealthyPeers() {
-		if info.Metadata != nil && info.Metadata.WorkerMode {
+		if info.Metadata != nil && info.Metadata.WorkerMode && !info.Metadata.Paused {
 			result[peerID] = info.Metadata
 		}
 	}
@@ -334,47 +386,69 @@ type PeerStatistics struct {
 	ConsumerPeers int `json:"consumer_peers"`
 }
 
+// privateWorkerPenalty scales the score of workers that reported they are behind NAT
+const privateWorkerPenalty = 0.5
+
+// SetWorkerWeight makes worker selection multiply the score of every worker by weight(peerID)
+func (pm *Manager) SetWorkerWeight(weight func(peerID string) float64) {
+	pm.peerMu.Lock()
+	defer pm.peerMu.Unlock()
+	pm.workerWeight = weight
+}
+
 // FindBestWorker finds the best available worker for a specific model
 func (pm *Manager) FindBestWorker(requiredModel string) *crowdllama.Resource {
+	return pm.FindBestWorkerWithFeatures(requiredModel, nil)
+}
+
+// FindBestWorkerWithFeatures finds the best available worker for a model among the workers that speak a
+// compatible protocol version and advertise all required features
+func (pm *Manager) FindBestWorkerWithFeatures(requiredModel string, requiredFeatures []string) *crowdllama.Resource {
 	workers := pm.GetAvailableWorkers()
 	if len(workers) == 0 {
 		return nil
 	}
 
-	// Filter workers that support the required model
+	// Filter workers that support the required model and features
 	suitableWorkers := make([]*crowdllama.Resource, 0)
 	for _, worker := range workers {
-		// Check if the worker supports the required model
-		supportsModel := false
-		for _, model := range worker.SupportedModels {
-			if model == requiredModel {
-				supportsModel = true
-				break
-			}
+		if !slices.Contains(worker.SupportedModels, requiredModel) {
+			continue
 		}
-
-		if supportsModel {
-			suitableWorkers = append(suitableWorkers, worker)
+		if !worker.IsCompatible() || !worker.SupportsModelFeatures(requiredModel, requiredFeatures...) {
+			pm.logger.Debug("Skipping worker lacking required capabilities",
+				zap.String("worker_id", worker.PeerID),
+				zap.Int("protocol_version", worker.GetProtocolVersion()),
+				zap.Strings("features", worker.GetFeatures()),
+				zap.Strings("required_features", requiredFeatures))
+			continue
 		}
+		suitableWorkers = append(suitableWorkers, worker)
 	}
 
 	if len(suitableWorkers) == 0 {
 		return nil
 	}
 
+	pm.peerMu.RLock()
+	weight := pm.workerWeight
+	pm.peerMu.RUnlock()
+
 	// Select the best worker based on criteria (lowest load, highest throughput)
+	// Start below any score so that a worker is selected even if all of them score 0
 	var selectedWorker *crowdllama.Resource
-	bestScore := float64(0)
+	bestScore := float64(-1)
 
 	for _, worker := range suitableWorkers {
-		// Simple scoring: tokens_throughput / (1 + current_load)
-		// This favors workers with high throughput and low current load
-		score := worker.TokensThroughput / (1 + worker.Load)
+		score := workerScore(worker, weight)
 		if score > bestScore {
 			bestScore = score
 			selectedWorker = worker
 		}
 	}
+	if selectedWorker == nil {
+		return nil
+	}
 
 	pm.logger.Info("Selected best worker",
 		zap.String("worker_id", selectedWorker.PeerID),
@@ -386,6 +460,21 @@ func (pm *Manager) FindBestWorker(requiredModel string) *crowdllama.Resource {
 	return selectedWorker
 }
 
+// workerScore rates a worker for selection, scaled by weight unless it is nil
+func workerScore(worker *crowdllama.Resource, weight func(peerID string) float64) float64 {
+	// Simple scoring: tokens_throughput / (1 + current_load)
+	// This favors workers with high throughput and low current load
+	score := worker.TokensThroughput / (1 + worker.Load)
+	if worker.IsPrivate() {
+		// Workers behind NAT may only be reachable through a relay, which adds latency
+		score *= privateWorkerPenalty
+	}
+	if weight != nil {
+		score *= weight(worker.PeerID)
+	}
+	return score
+}
+
 // Advertise starts advertising this peer in the network
 func (pm *Manager) Advertise(namespace string) {
 	pm.logger.Info("Starting peer advertisement", zap.String("namespace", namespace))
@@ -459,24 +548,30 @@ func (pm *Manager) startDiscovery() {
 func (pm *Manager) runDiscovery() {
 	pm.logger.Debug("Running peer discovery")
 
-	// Discover peers using the discovery package
-	discoveredPeers, err := discovery.DiscoverPeers(pm.discoveryCtx, pm.dht, pm.logger, pm)
-	if err != nil {
+	// Discover peers using the discovery package; each peer is added as soon as its metadata arrives
+	discovered := 0
+	var discoveredMu sync.Mutex
+	opts := pm.config.DiscoveryOptions(func(peer *crowdllama.Resource) {
+		// Skip peers that are unhealthy or were recently removed
+		if pm.IsPeerUnhealthy(peer.PeerID) {
+			return
+		}
+		pm.AddOrUpdatePeer(peer.PeerID, peer)
+		pm.logger.Info("Discovered new peer",
+			zap.String("peer_id", peer.PeerID),
+			zap.Bool("worker_mode", peer.WorkerMode))
+
+		discoveredMu.Lock()
+		discovered++
+		discoveredMu.Unlock()
+	})
+
+	if _, err := discovery.DiscoverPeersWithOptions(pm.discoveryCtx, pm.dht, pm.logger, pm, opts); err != nil {
 		pm.logger.Error("Failed to discover peers", zap.Error(err))
 		return
 	}
 
-	// Process discovered peers
-	for _, peer := range discoveredPeers {
-		// Skip if peer is already known and healthy
-		if !pm.IsPeerUnhealthy(peer.PeerID) {
-			// Add or update peer in manager
-			pm.AddOrUpdatePeer(peer.PeerID, peer)
-			pm.logger.Info("Discovered new peer",
-				zap.String("peer_id", peer.PeerID),
-				zap.Bool("worker_mode", peer.WorkerMode))
-		}
-	}
+	pm.logger.Debug("Peer discovery round finished", zap.Int("discovered_peers", discovered))
 }
 
 // startMetadataUpdates starts periodic metadata updates
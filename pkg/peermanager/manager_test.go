@@ -0,0 +1,36 @@
+package peermanager
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+)
+
+// newTestWorker returns the metadata of a worker serving llama3.2
+func newTestWorker(peerID string, throughput, load float64) *crowdllama.Resource {
+	worker := crowdllama.NewCrowdLlamaResource(peerID)
+	worker.WorkerMode = true
+	worker.SupportedModels = []string{"llama3.2"}
+	worker.TokensThroughput = throughput
+	worker.Load = load
+	worker.ProtocolVersion = crowdllama.ProtocolVersion
+	worker.Features = crowdllama.WorkerFeatures()
+	return worker
+}
+
+func TestFindBestWorkerWithZeroScores(t *testing.T) {
+	pm := NewManager(context.Background(), nil, nil, zap.NewNop(), nil)
+	pm.AddOrUpdatePeer("worker-a", newTestWorker("worker-a", 0, 1))
+	pm.AddOrUpdatePeer("worker-b", newTestWorker("worker-b", 0, 1))
+
+	worker := pm.FindBestWorkerWithFeatures("llama3.2", []string{crowdllama.FeatureChat})
+	if worker == nil {
+		t.Fatal("Expected a worker to be selected even though all workers score 0")
+	}
+	if worker := pm.FindBestWorkerWithFeatures("mistral", nil); worker != nil {
+		t.Errorf("Expected no worker for a model nobody serves, got %s", worker.PeerID)
+	}
+}
@@ -0,0 +1,311 @@
+// Package peerstate persists peer addresses, DHT records and known CrowdLlama peers across restarts.
+package peerstate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/multiformats/go-multiaddr"
+	"go.uber.org/zap"
+
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+)
+
+// DefaultStateDir is the default directory, relative to the home directory, for persisted peer state
+const DefaultStateDir = ".crowdllama/state"
+
+// DefaultSaveInterval is the interval at which state is periodically written to disk
+const DefaultSaveInterval = 1 * time.Minute
+
+// maxSavedPeers bounds the number of peerstore entries written to disk
+const maxSavedPeers = 256
+
+// connectTimeout bounds how long dialing the saved peers may take
+const connectTimeout = 15 * time.Second
+
+// PeerAddrs is a persisted peerstore entry
+type PeerAddrs struct {
+	PeerID string   `json:"peer_id"`
+	Addrs  []string `json:"addrs"`
+}
+
+// Snapshot is the on-disk representation of a node's peer state
+type Snapshot struct {
+	SavedAt    time.Time              `json:"saved_at"`
+	Peers      []PeerAddrs            `json:"peers"`
+	DHTRecords map[string][]byte      `json:"dht_records"`
+	KnownPeers []*crowdllama.Resource `json:"known_peers"`
+}
+
+// Store persists the libp2p peerstore, the DHT datastore and the peer manager table to a JSON file
+type Store struct {
+	path      string
+	logger    *zap.Logger
+	datastore *dssync.MutexDatastore
+	snapshot  *Snapshot
+	saveMu    sync.Mutex
+}
+
+// GetDefaultStateDir returns the default state directory
+func GetDefaultStateDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, DefaultStateDir), nil
+}
+
+// Open loads the state for a component (e.g. "worker", "consumer", "dht") from dir.
+// A missing state file is not an error; the store then starts empty.
+func Open(dir, component string, logger *zap.Logger) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create state directory %s: %w", dir, err)
+	}
+
+	s := &Store{
+		path:      filepath.Join(dir, component+".json"),
+		logger:    logger,
+		datastore: dssync.MutexWrap(ds.NewMapDatastore()),
+		snapshot:  &Snapshot{},
+	}
+
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		logger.Debug("No persisted peer state found", zap.String("path", s.path))
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file %s: %w", s.path, err)
+	}
+
+	if err := json.Unmarshal(data, s.snapshot); err != nil {
+		// A corrupt state file must not prevent the node from starting
+		logger.Warn("Ignoring unreadable peer state file", zap.String("path", s.path), zap.Error(err))
+		s.snapshot = &Snapshot{}
+		return s, nil
+	}
+
+	for key, value := range s.snapshot.DHTRecords {
+		if err := s.datastore.Put(context.Background(), ds.NewKey(key), value); err != nil {
+			return nil, fmt.Errorf("failed to restore DHT record %s: %w", key, err)
+		}
+	}
+
+	logger.Info("Loaded persisted peer state",
+		zap.String("path", s.path),
+		zap.Time("saved_at", s.snapshot.SavedAt),
+		zap.Int("peers", len(s.snapshot.Peers)),
+		zap.Int("dht_records", len(s.snapshot.DHTRecords)),
+		zap.Int("known_peers", len(s.snapshot.KnownPeers)))
+
+	return s, nil
+}
+
+// Close releases the DHT datastore of the store
+func (s *Store) Close() error {
+	if err := s.datastore.Close(); err != nil {
+		return fmt.Errorf("failed to close DHT datastore: %w", err)
+	}
+	return nil
+}
+
+// Path returns the path of the state file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// DHTOption returns the DHT option that backs the DHT's records with this store
+func (s *Store) DHTOption() dht.Option {
+	return dht.Datastore(s.datastore)
+}
+
+// SavedPeers returns the peers loaded from disk
+func (s *Store) SavedPeers() []peer.AddrInfo {
+	infos := make([]peer.AddrInfo, 0, len(s.snapshot.Peers))
+	for _, entry := range s.snapshot.Peers {
+		id, err := peer.Decode(entry.PeerID)
+		if err != nil {
+			continue
+		}
+		info := peer.AddrInfo{ID: id}
+		for _, addr := range entry.Addrs {
+			maddr, err := multiaddr.NewMultiaddr(addr)
+			if err != nil {
+				continue
+			}
+			info.Addrs = append(info.Addrs, maddr)
+		}
+		if len(info.Addrs) > 0 {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// KnownPeers returns the CrowdLlama peers loaded from disk
+func (s *Store) KnownPeers() []*crowdllama.Resource {
+	return s.snapshot.KnownPeers
+}
+
+// RestorePeerstore adds the saved peer addresses to the host's peerstore
+func (s *Store) RestorePeerstore(h host.Host) {
+	for _, info := range s.SavedPeers() {
+		if info.ID == h.ID() {
+			continue
+		}
+		h.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.AddressTTL)
+	}
+}
+
+// Save writes the current peer state to disk
+func (s *Store) Save(ctx context.Context, h host.Host, kadDHT *dht.IpfsDHT, knownPeers []*crowdllama.Resource) error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	records, err := s.dumpDatastore(ctx)
+	if err != nil {
+		return err
+	}
+
+	snapshot := &Snapshot{
+		SavedAt:    time.Now(),
+		Peers:      collectPeers(h, kadDHT),
+		DHTRecords: records,
+		KnownPeers: knownPeers,
+	}
+
+	// Keep the previously known peers while disconnected so an outage does not erase them
+	if len(snapshot.Peers) == 0 {
+		snapshot.Peers = s.snapshot.Peers
+	}
+	if len(snapshot.KnownPeers) == 0 {
+		snapshot.KnownPeers = s.snapshot.KnownPeers
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal peer state: %w", err)
+	}
+
+	// Write atomically so a crash never leaves a truncated state file behind
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write state file %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to replace state file %s: %w", s.path, err)
+	}
+	s.snapshot = snapshot
+
+	s.logger.Debug("Saved peer state",
+		zap.String("path", s.path),
+		zap.Int("peers", len(snapshot.Peers)),
+		zap.Int("dht_records", len(snapshot.DHTRecords)),
+		zap.Int("known_peers", len(snapshot.KnownPeers)))
+	return nil
+}
+
+// dumpDatastore returns all entries of the DHT datastore
+func (s *Store) dumpDatastore(ctx context.Context) (map[string][]byte, error) {
+	results, err := s.datastore.Query(ctx, query.Query{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query DHT datastore: %w", err)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DHT datastore: %w", err)
+	}
+
+	records := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		records[entry.Key] = entry.Value
+	}
+	return records, nil
+}
+
+// collectPeers returns the addresses of connected peers and routing table members
+func collectPeers(h host.Host, kadDHT *dht.IpfsDHT) []PeerAddrs {
+	candidates := h.Network().Peers()
+	if kadDHT != nil {
+		candidates = append(candidates, kadDHT.RoutingTable().ListPeers()...)
+	}
+
+	seen := make(map[peer.ID]bool)
+	peers := make([]PeerAddrs, 0, len(candidates))
+	for _, id := range candidates {
+		if id == h.ID() || seen[id] || len(peers) >= maxSavedPeers {
+			continue
+		}
+		seen[id] = true
+
+		addrs := h.Peerstore().Addrs(id)
+		if len(addrs) == 0 {
+			continue
+		}
+		entry := PeerAddrs{PeerID: id.String()}
+		for _, addr := range addrs {
+			entry.Addrs = append(entry.Addrs, addr.String())
+		}
+		peers = append(peers, entry)
+	}
+	return peers
+}
+
+// Rejoin connects to the saved peers and bootstraps the DHT through them. It is used when none of the
+// configured bootstrap peers are reachable.
+func (s *Store) Rejoin(ctx context.Context, h host.Host, kadDHT *dht.IpfsDHT) error {
+	savedPeers := s.SavedPeers()
+	if len(savedPeers) == 0 {
+		return fmt.Errorf("no saved peers in %s", s.path)
+	}
+
+	connected := s.ConnectSavedPeers(ctx, h)
+	if connected == 0 {
+		return fmt.Errorf("none of the %d saved peers are reachable", len(savedPeers))
+	}
+
+	s.logger.Info("Rejoined network via saved peers", zap.Int("connected_peers", connected))
+	if err := kadDHT.Bootstrap(ctx); err != nil {
+		return fmt.Errorf("bootstrap DHT: %w", err)
+	}
+	return nil
+}
+
+// ConnectSavedPeers dials all saved peers in parallel and returns how many connections succeeded
+func (s *Store) ConnectSavedPeers(ctx context.Context, h host.Host) int {
+	connectCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+
+	var connected atomic.Int32
+	var wg sync.WaitGroup
+	for _, info := range s.SavedPeers() {
+		if info.ID == h.ID() {
+			continue
+		}
+		wg.Add(1)
+		go func(info peer.AddrInfo) {
+			defer wg.Done()
+			if err := h.Connect(connectCtx, info); err != nil {
+				s.logger.Debug("Failed to connect to saved peer", zap.String("peer_id", info.ID.String()), zap.Error(err))
+				return
+			}
+			connected.Add(1)
+		}(info)
+	}
+	wg.Wait()
+
+	return int(connected.Load())
+}
@@ -0,0 +1,96 @@
+package peerstate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+
+	"github.com/crowdllama/crowdllama/pkg/crowdllama"
+)
+
+func newTestHost(t *testing.T) host.Host {
+	t.Helper()
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatalf("Failed to create host: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := h.Close(); err != nil {
+			t.Logf("Failed to close host: %v", err)
+		}
+	})
+	return h
+}
+
+func TestSaveAndOpenRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	logger := zap.NewNop()
+	stateDir := t.TempDir()
+
+	store, err := Open(stateDir, "worker", logger)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if len(store.SavedPeers()) != 0 {
+		t.Errorf("Expected empty store, got %d saved peers", len(store.SavedPeers()))
+	}
+
+	h1 := newTestHost(t)
+	h2 := newTestHost(t)
+	if err := h1.Connect(ctx, peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}); err != nil {
+		t.Fatalf("Failed to connect hosts: %v", err)
+	}
+
+	knownPeers := []*crowdllama.Resource{
+		{PeerID: h2.ID().String(), WorkerMode: true, SupportedModels: []string{"llama3.2"}},
+	}
+	if err := store.Save(ctx, h1, nil, knownPeers); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	reopened, err := Open(stateDir, "worker", logger)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+
+	savedPeers := reopened.SavedPeers()
+	if len(savedPeers) != 1 || savedPeers[0].ID != h2.ID() {
+		t.Fatalf("Expected saved peer %s, got %v", h2.ID(), savedPeers)
+	}
+
+	restored := reopened.KnownPeers()
+	if len(restored) != 1 || restored[0].PeerID != h2.ID().String() || !restored[0].WorkerMode {
+		t.Errorf("Unexpected known peers after reopen: %+v", restored)
+	}
+
+	// A fresh host should reach the saved peer using only the persisted addresses
+	h3 := newTestHost(t)
+	reopened.RestorePeerstore(h3)
+	if connected := reopened.ConnectSavedPeers(ctx, h3); connected != 1 {
+		t.Errorf("Expected to connect to 1 saved peer, connected to %d", connected)
+	}
+}
+
+func TestOpenIgnoresCorruptStateFile(t *testing.T) {
+	stateDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(stateDir, "dht.json"), []byte("{not json"), 0o600); err != nil {
+		t.Fatalf("Failed to write state file: %v", err)
+	}
+
+	store, err := Open(stateDir, "dht", zap.NewNop())
+	if err != nil {
+		t.Fatalf("Expected corrupt state file to be ignored, got error: %v", err)
+	}
+	if len(store.SavedPeers()) != 0 || len(store.KnownPeers()) != 0 {
+		t.Error("Expected empty state after ignoring corrupt file")
+	}
+}
@@ -0,0 +1,183 @@
+// Package schedule parses the weekly time windows in which a worker serves requests.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// day is the length of a whole-day window
+const day = 24 * time.Hour
+
+// dayNames maps the accepted day abbreviations to weekdays
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Window is a daily time range on a set of weekdays. A range that does not end after it starts runs past
+// midnight into the next day, and belongs to the day it starts on.
+type Window struct {
+	Days  [7]bool       // indexed by time.Weekday
+	Start time.Duration // offset from midnight
+	End   time.Duration // offset from midnight, at most 24h
+}
+
+// Schedule is a set of windows; a time is inside the schedule if it falls in any window
+type Schedule struct {
+	Windows []Window
+}
+
+// Parse parses a schedule such as "mon-fri 22:00-07:00; sat,sun". Windows are separated by semicolons and
+// consist of days, a time range or both: days default to every day and the time range to the whole day.
+func Parse(spec string) (*Schedule, error) {
+	s := &Schedule{}
+	for part := range strings.SplitSeq(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		window, err := parseWindow(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule window %q: %w", part, err)
+		}
+		s.Windows = append(s.Windows, window)
+	}
+	if len(s.Windows) == 0 {
+		return nil, errors.New("schedule has no windows")
+	}
+	return s, nil
+}
+
+// Active returns true if t falls inside one of the windows, in t's location
+func (s *Schedule) Active(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+
+	for _, w := range s.Windows {
+		if w.Start < w.End {
+			if w.Days[today] && offset >= w.Start && offset < w.End {
+				return true
+			}
+			continue
+		}
+		// The window runs overnight: the evening of a listed day or the morning after it
+		if (w.Days[today] && offset >= w.Start) || (w.Days[yesterday] && offset < w.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWindow parses a single window of days, a time range or both
+func parseWindow(spec string) (Window, error) {
+	fields := strings.Fields(spec)
+	if len(fields) > 2 {
+		return Window{}, errors.New("expected days, a time range or both")
+	}
+
+	var w Window
+	var haveDays, haveRange bool
+	for _, field := range fields {
+		var err error
+		if strings.Contains(field, ":") {
+			if haveRange {
+				return Window{}, errors.New("more than one time range")
+			}
+			w.Start, w.End, err = parseRange(field)
+			haveRange = true
+		} else {
+			if haveDays {
+				return Window{}, errors.New("more than one list of days")
+			}
+			w.Days, err = parseDays(field)
+			haveDays = true
+		}
+		if err != nil {
+			return Window{}, err
+		}
+	}
+
+	if !haveDays {
+		w.Days = [7]bool{true, true, true, true, true, true, true}
+	}
+	if !haveRange {
+		w.End = day
+	}
+	return w, nil
+}
+
+// parseDays parses a comma-separated list of days and day ranges, such as "mon-fri,sun"
+func parseDays(spec string) ([7]bool, error) {
+	var days [7]bool
+	for item := range strings.SplitSeq(spec, ",") {
+		first, last, isRange := strings.Cut(item, "-")
+		from, err := parseDay(first)
+		if err != nil {
+			return days, err
+		}
+		to := from
+		if isRange {
+			if to, err = parseDay(last); err != nil {
+				return days, err
+			}
+		}
+		// Ranges may wrap around the end of the week, e.g. fri-mon
+		for d := from; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseDay parses a three-letter day abbreviation
+func parseDay(name string) (time.Weekday, error) {
+	d, ok := dayNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown day %q, expected one of mon, tue, wed, thu, fri, sat, sun", name)
+	}
+	return d, nil
+}
+
+// parseRange parses a time range such as "22:00-07:00"
+func parseRange(spec string) (time.Duration, time.Duration, error) {
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("time range %q must be written as HH:MM-HH:MM", spec)
+	}
+	start, err := parseClock(first)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClock(last)
+	if err != nil {
+		return 0, 0, err
+	}
+	if start == day {
+		return 0, 0, errors.New("a time range cannot start at 24:00")
+	}
+	return start, end, nil
+}
+
+// parseClock parses a time of day as HH:MM, where 24:00 stands for the end of the day
+func parseClock(spec string) (time.Duration, error) {
+	hours, minutes, ok := strings.Cut(spec, ":")
+	h, hErr := strconv.Atoi(hours)
+	m, mErr := strconv.Atoi(minutes)
+	if !ok || hErr != nil || mErr != nil || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", spec)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
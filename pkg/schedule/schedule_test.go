@@ -0,0 +1,62 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+// at returns a time in the week of Monday 2024-01-01
+func at(weekday time.Weekday, hour, minute int) time.Time {
+	return time.Date(2024, 1, 1+(int(weekday)+6)%7, hour, minute, 0, 0, time.UTC)
+}
+
+func TestScheduleActive(t *testing.T) {
+	s, err := Parse("mon-fri 22:00-07:00; sat,sun")
+	if err != nil {
+		t.Fatalf("Failed to parse schedule: %v", err)
+	}
+
+	for _, tc := range []struct {
+		time   time.Time
+		active bool
+	}{
+		{at(time.Monday, 23, 0), true},
+		{at(time.Tuesday, 6, 59), true},
+		{at(time.Tuesday, 7, 0), false},
+		{at(time.Wednesday, 12, 0), false},
+		{at(time.Monday, 3, 0), false}, // Sunday ends at midnight and Monday's window starts at 22:00
+		{at(time.Monday, 8, 0), false},
+		{at(time.Saturday, 3, 0), true}, // the overnight window of Friday
+		{at(time.Sunday, 15, 0), true},
+	} {
+		if got := s.Active(tc.time); got != tc.active {
+			t.Errorf("Active(%s) = %v, want %v", tc.time.Format("Mon 15:04"), got, tc.active)
+		}
+	}
+}
+
+func TestScheduleDaysAndRanges(t *testing.T) {
+	s, err := Parse("fri-mon")
+	if err != nil {
+		t.Fatalf("Failed to parse schedule: %v", err)
+	}
+	if !s.Active(at(time.Sunday, 12, 0)) || s.Active(at(time.Wednesday, 12, 0)) {
+		t.Error("Expected a day range to wrap around the end of the week")
+	}
+
+	s, err = Parse("09:00-24:00")
+	if err != nil {
+		t.Fatalf("Failed to parse schedule: %v", err)
+	}
+	if !s.Active(at(time.Thursday, 23, 59)) || s.Active(at(time.Thursday, 8, 59)) {
+		t.Error("Expected a time range without days to apply every day")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{"", ";", "monday", "mon 9:00", "mon 25:00-26:00", "mon 10:00-11:00 12:00-13:00", "24:00-01:00"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
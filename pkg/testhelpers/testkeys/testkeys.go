@@ -0,0 +1,25 @@
+// Package testkeys provides key pairs for tests. It is separate from testhelpers, which imports the DHT server,
+// so that the packages the DHT server depends on can use it in their own tests.
+package testkeys
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// NewKey returns a fresh Ed25519 key and its peer ID
+func NewKey(t *testing.T) (crypto.PrivKey, peer.ID) {
+	t.Helper()
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to derive peer ID: %v", err)
+	}
+	return key, id
+}
@@ -532,6 +532,10 @@ func stepSendAndValidateRequest(_ context.Context, t *testing.T, consumerPort in
 		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, string(body))
 	}
 
+	if resp.Header.Get(gateway.WorkerHeader) == "" {
+		t.Errorf("Expected the %s header to name the serving worker", gateway.WorkerHeader)
+	}
+
 	// Parse and validate the response
 	var response gateway.GenerateResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {